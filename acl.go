@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 一组CIDR网段
+type cidrList []*net.IPNet
+
+// 解析CIDR列表，单个IP视为 /32 或 /128
+func parseCIDRList(entries []string) (cidrList, error) {
+	var list cidrList
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP地址: %s", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR: %s", entry)
+		}
+		list = append(list, ipNet)
+	}
+	return list, nil
+}
+
+func (l cidrList) contains(ip net.IP) bool {
+	for _, ipNet := range l {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 一组允许/拒绝规则 - 拒绝优先；允许列表为空时表示全部允许
+type accessRule struct {
+	allow cidrList
+	deny  cidrList
+}
+
+func newAccessRule(allow, deny []string) (accessRule, error) {
+	allowList, err := parseCIDRList(allow)
+	if err != nil {
+		return accessRule{}, err
+	}
+	denyList, err := parseCIDRList(deny)
+	if err != nil {
+		return accessRule{}, err
+	}
+	return accessRule{allow: allowList, deny: denyList}, nil
+}
+
+func (rule accessRule) permits(ip net.IP) bool {
+	if rule.deny.contains(ip) {
+		return false
+	}
+	return len(rule.allow) == 0 || rule.allow.contains(ip)
+}
+
+// 可以单独配置规则的路由名，与 setupRoutes 中注册的路由一致
+var accessRoutes = []string{
+	"browse", "download", "upload", "delete", "trash", "duplicates", "versions",
+	"hash", "usage", "clipboard", "events", "qrcode", "static", "metrics",
+}
+
+// 访问控制 - 全局规则、按路由的规则以及受信任的反向代理
+type accessControl struct {
+	global         accessRule
+	routes         map[string]accessRule
+	trustedProxies cidrList
+}
+
+// 根据配置创建访问控制；未知的路由名和重复的路由视为配置错误，避免规则因拼写错误而不生效
+func newAccessControl(settings AccessSettings) (*accessControl, error) {
+	global, err := newAccessRule(settings.Allow, settings.Deny)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := parseCIDRList(settings.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := &accessControl{global: global, routes: make(map[string]accessRule), trustedProxies: trusted}
+	for _, r := range settings.Rules {
+		if !containsString(accessRoutes, r.Route) {
+			return nil, fmt.Errorf("未知的路由: %q（可用的路由: %s）", r.Route, strings.Join(accessRoutes, ", "))
+		}
+		if _, ok := acl.routes[r.Route]; ok {
+			return nil, fmt.Errorf("路由 %s 的规则重复，请合并为一条", r.Route)
+		}
+		rule, err := newAccessRule(r.Allow, r.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("路由 %s 的规则: %v", r.Route, err)
+		}
+		acl.routes[r.Route] = rule
+	}
+	return acl, nil
+}
+
+// 计算真实客户端IP - 只有直连方是受信任代理时才采用 X-Forwarded-For，
+// 从右向左跳过受信任的代理，取第一个不受信任的地址
+func (acl *accessControl) resolveClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !acl.trustedProxies.contains(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !acl.trustedProxies.contains(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// 直连方是否为受信任的反向代理
+func (acl *accessControl) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && acl.trustedProxies.contains(ip)
+}
+
+// 检查IP是否可以访问指定路由
+func (acl *accessControl) permits(route string, ip net.IP) bool {
+	if ip == nil || !acl.global.permits(ip) {
+		return false
+	}
+	if rule, ok := acl.routes[route]; ok {
+		return rule.permits(ip)
+	}
+	return true
+}
+
+type clientIPKey struct{}
+
+// 获取客户端IP - 优先使用访问控制中间件解析出的真实IP
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(net.IP); ok && ip != nil {
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 访问控制中间件 - 解析真实客户端IP并按规则放行或拒绝
+func restrictAccess(acl *accessControl, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := acl.resolveClientIP(r)
+		if !acl.permits(route, ip) {
+			log.Printf("拒绝访问: %s %s (客户端: %s, 路由: %s)", r.Method, r.URL.Path, ip, route)
+			httpError(w, r, http.StatusForbidden, "access_denied")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewAccessRule(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny []string
+		ip          string
+		want        bool
+		wantErr     bool
+	}{
+		{"empty allows all", nil, nil, "203.0.113.7", true, false},
+		{"allowed network", []string{"192.168.1.0/24"}, nil, "192.168.1.20", true, false},
+		{"outside allowed network", []string{"192.168.1.0/24"}, nil, "192.168.2.20", false, false},
+		{"single IPv4", []string{" 10.0.0.1 "}, nil, "10.0.0.1", true, false},
+		{"single IPv4 is /32", []string{"10.0.0.1"}, nil, "10.0.0.2", false, false},
+		{"single IPv6", []string{"2001:db8::1"}, nil, "2001:db8::1", true, false},
+		{"IPv6 network", []string{"2001:db8::/32"}, nil, "2001:db8:1::5", true, false},
+		{"deny wins over allow", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.1.2.3", false, false},
+		{"deny only", nil, []string{"10.1.0.0/16"}, "10.2.0.1", true, false},
+		{"empty entries skipped", []string{"", "  "}, nil, "10.0.0.1", true, false},
+		{"invalid IP", []string{"10.0.0.300"}, nil, "", false, true},
+		{"invalid CIDR", nil, []string{"10.0.0.0/33"}, "", false, true},
+	}
+	for _, tt := range tests {
+		rule, err := newAccessRule(tt.allow, tt.deny)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := rule.permits(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%s: permits(%s) = %v, want %v", tt.name, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestAccessControlPermits(t *testing.T) {
+	acl, err := newAccessControl(AccessSettings{
+		Deny:  []string{"203.0.113.0/24"},
+		Rules: []AccessRule{{Route: "upload", Allow: []string{"192.168.1.0/24"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		route, ip string
+		want      bool
+	}{
+		{"upload", "192.168.1.5", true},
+		{"upload", "192.168.2.5", false},
+		{"download", "192.168.2.5", true},
+		{"download", "203.0.113.9", false},
+	}
+	for _, c := range checks {
+		if got := acl.permits(c.route, net.ParseIP(c.ip)); got != c.want {
+			t.Errorf("permits(%s, %s) = %v, want %v", c.route, c.ip, got, c.want)
+		}
+	}
+	if acl.permits("download", nil) {
+		t.Error("permits accepted an unparsable client address")
+	}
+
+	if _, err := newAccessControl(AccessSettings{Rules: []AccessRule{{Route: "upload", Allow: []string{"bad"}}}}); err == nil {
+		t.Error("invalid route rule was accepted")
+	}
+}
+
+func TestNewAccessControlRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []AccessRule
+		wantErr bool
+	}{
+		{"known routes", []AccessRule{{Route: "upload", Allow: []string{"10.0.0.0/8"}}, {Route: "metrics", Deny: []string{"0.0.0.0/0"}}}, false},
+		{"unknown route", []AccessRule{{Route: "uploads", Allow: []string{"10.0.0.0/8"}}}, true},
+		{"empty route", []AccessRule{{Allow: []string{"10.0.0.0/8"}}}, true},
+		{"duplicate route", []AccessRule{{Route: "upload", Allow: []string{"10.0.0.0/8"}}, {Route: "upload", Deny: []string{"10.1.0.0/16"}}}, true},
+		{"invalid rule", []AccessRule{{Route: "upload", Allow: []string{"bad"}}}, true},
+	}
+	for _, tt := range tests {
+		_, err := newAccessControl(AccessSettings{Rules: tt.rules})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	acl, err := newAccessControl(AccessSettings{TrustedProxies: []string{"10.0.0.1", "10.0.1.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+		trusted    bool
+	}{
+		{"direct client", "198.51.100.1:4000", nil, "198.51.100.1", false},
+		{"untrusted peer ignores header", "198.51.100.1:4000", []string{"192.0.2.9"}, "198.51.100.1", false},
+		{"trusted proxy", "10.0.0.1:4000", []string{"192.0.2.9"}, "192.0.2.9", true},
+		{"trusted proxy without header", "10.0.0.1:4000", nil, "10.0.0.1", true},
+		{"chain of trusted proxies", "10.0.0.1:4000", []string{"192.0.2.9, 10.0.1.7"}, "192.0.2.9", true},
+		{"spoofed leftmost entry", "10.0.0.1:4000", []string{"1.2.3.4, 192.0.2.9"}, "192.0.2.9", true},
+		{"multiple headers", "10.0.0.1:4000", []string{"1.2.3.4", "192.0.2.9"}, "192.0.2.9", true},
+		{"invalid hop stops", "10.0.0.1:4000", []string{"192.0.2.9, garbage"}, "10.0.0.1", true},
+		{"IPv6 peer", "[2001:db8::2]:4000", []string{"192.0.2.9"}, "2001:db8::2", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := acl.resolveClientIP(r); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("%s: resolveClientIP = %v, want %s", tt.name, got, tt.want)
+		}
+		if got := acl.fromTrustedProxy(r); got != tt.trusted {
+			t.Errorf("%s: fromTrustedProxy = %v, want %v", tt.name, got, tt.trusted)
+		}
+	}
+}
+
+func TestRestrictAccess(t *testing.T) {
+	acl, err := newAccessControl(AccessSettings{
+		TrustedProxies: []string{"10.0.0.1"},
+		Rules:          []AccessRule{{Route: "upload", Deny: []string{"192.0.2.0/24"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	handler := restrictAccess(acl, "upload", func(w http.ResponseWriter, r *http.Request) {
+		seen = clientIP(r)
+	})
+
+	// 代理转发的真实IP被拒绝
+	r := httptest.NewRequest("POST", "/upload", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "192.0.2.9")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusForbidden || w.Header().Get("X-Error-Code") != "access_denied" {
+		t.Errorf("denied client: status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+
+	// 放行时处理函数看到解析出的客户端IP
+	r = httptest.NewRequest("POST", "/upload", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.4")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || seen != "198.51.100.4" {
+		t.Errorf("allowed client: status = %d, clientIP = %q", w.Code, seen)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// 监听地址与对外公布的访问地址
+//
+// 监听地址决定服务器在哪些本机地址上接受连接；公布地址是IP选择器和二维码中给出的URL，
+// 只包含通过监听地址实际可以访问到的地址，配置了 public_url 时只公布该地址。
+type addressPlan struct {
+	bindAddrs  []string    // 实际监听的地址（host:port）
+	advertised []IPAddress // 对外公布的访问地址
+}
+
+// 拆分监听地址，允许只写端口（如 "8080"）
+func splitListenAddr(listen string) (string, string, error) {
+	if !strings.Contains(listen, ":") {
+		listen = ":" + listen
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", "", fmt.Errorf("无效的监听地址 %s: %v", listen, err)
+	}
+	if port == "" {
+		return "", "", fmt.Errorf("监听地址 %s 缺少端口", listen)
+	}
+	return host, port, nil
+}
+
+// 是否为通配地址（监听所有接口）
+func isWildcardHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// 解析公开访问地址，返回去掉末尾斜杠的URL
+func parsePublicURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("无效的 public_url: %s", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// 根据配置规划监听地址和公布地址
+func planAddresses(settings *Settings) (*addressPlan, error) {
+	host, port, err := splitListenAddr(settings.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &addressPlan{}
+	switch {
+	case len(settings.Interfaces) > 0:
+		// 只在指定网卡的地址上监听
+		if !isWildcardHost(host) {
+			return nil, fmt.Errorf("指定 interfaces 时 listen 只能包含端口，当前为 %s", settings.Listen)
+		}
+		for _, ip := range getAllIPs(port, settings.IPv6LinkLocalZones) {
+			if containsString(settings.Interfaces, ip.Interface) {
+				plan.bindAddrs = append(plan.bindAddrs, net.JoinHostPort(ip.IP, port))
+				plan.advertised = append(plan.advertised, ip)
+			}
+		}
+		if len(plan.bindAddrs) == 0 {
+			return nil, fmt.Errorf("网卡 %s 上没有可用地址", strings.Join(settings.Interfaces, ", "))
+		}
+	case isWildcardHost(host):
+		// 监听所有接口，公布所有地址
+		plan.bindAddrs = []string{net.JoinHostPort(host, port)}
+		plan.advertised = getAllIPs(port, settings.IPv6LinkLocalZones)
+	default:
+		// 监听指定的主机名或IP，只公布该地址
+		plan.bindAddrs = []string{net.JoinHostPort(host, port)}
+		display := host
+		if host == "localhost" {
+			display = "本地 (localhost)"
+		}
+		plan.advertised = []IPAddress{{IP: host, DisplayName: display, BaseURL: hostURL(host, port)}}
+	}
+
+	// 网卡地址都带上URL前缀
+	basePath := normalizeBasePath(settings.BasePath)
+	for i := range plan.advertised {
+		plan.advertised[i].BaseURL += basePath
+	}
+
+	// 反向代理部署时只公布显式指定的地址（public_url 应当已经包含URL前缀）
+	if settings.PublicURL != "" {
+		publicURL, err := parsePublicURL(settings.PublicURL)
+		if err != nil {
+			return nil, err
+		}
+		plan.advertised = []IPAddress{{IP: "public", DisplayName: publicURL, BaseURL: publicURL}}
+	}
+
+	return plan, nil
+}
+
+// 经受信任的反向代理访问时，根据 X-Forwarded-Proto / X-Forwarded-Host 构建访问地址；
+// 配置了 public_url 时以其为准，不采用转发头
+func forwardedAddress(r *http.Request, config *ServerConfig) (IPAddress, bool) {
+	if config.settings.PublicURL != "" || !config.acl.fromTrustedProxy(r) {
+		return IPAddress{}, false
+	}
+
+	// 多级代理时取最左边的值，即客户端实际访问的地址
+	host := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0])
+	if host == "" {
+		return IPAddress{}, false
+	}
+	proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+	if proto == "" {
+		proto = "http"
+	}
+	if proto != "http" && proto != "https" {
+		return IPAddress{}, false
+	}
+
+	// 只接受纯粹的 host[:port]，防止通过转发头注入路径或参数
+	u, err := url.Parse(proto + "://" + host)
+	if err != nil || u.Host != host || u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return IPAddress{}, false
+	}
+
+	baseURL := proto + "://" + host + config.basePath
+	return IPAddress{IP: "proxy", DisplayName: baseURL, BaseURL: baseURL}, true
+}
+
+// 默认公布地址：第一个非localhost的地址（通常是优先级最高的局域网IP）
+func (plan *addressPlan) defaultIP() string {
+	for _, ip := range plan.advertised {
+		if ip.IP != "localhost" {
+			return ip.IP
+		}
+	}
+	return plan.advertised[0].IP
+}
+
+// 监听所有地址 - 主机名解析出多个地址时（如localhost对应127.0.0.1和::1）分别监听，
+// 通配地址（空、0.0.0.0、::）由系统以双栈方式监听
+func listenAll(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		hosts := []string{host}
+		if host != "" && net.ParseIP(strings.SplitN(host, "%", 2)[0]) == nil {
+			resolved, err := net.LookupHost(host)
+			if err != nil {
+				return nil, err
+			}
+			hosts = resolved
+		}
+
+		for _, h := range hosts {
+			l, err := net.Listen("tcp", net.JoinHostPort(h, port))
+			if err != nil {
+				// 部分地址无法监听（如系统未启用IPv6）时继续尝试其余地址
+				log.Printf("监听 %s 失败: %v", net.JoinHostPort(h, port), err)
+				continue
+			}
+			listeners = append(listeners, l)
+		}
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("没有可用的监听地址: %s", strings.Join(addrs, ", "))
+	}
+	return listeners, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitListenAddr(t *testing.T) {
+	tests := []struct {
+		listen, host, port string
+		wantErr            bool
+	}{
+		{"8080", "", "8080", false},
+		{":8080", "", "8080", false},
+		{"0.0.0.0:8080", "0.0.0.0", "8080", false},
+		{"[::1]:8080", "::1", "8080", false},
+		{"localhost:", "", "", true},
+		{"[::1", "", "", true},
+	}
+	for _, tt := range tests {
+		host, port, err := splitListenAddr(tt.listen)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitListenAddr(%q): error = %v, wantErr %v", tt.listen, err, tt.wantErr)
+			continue
+		}
+		if host != tt.host || port != tt.port {
+			t.Errorf("splitListenAddr(%q) = %q, %q, want %q, %q", tt.listen, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestPlanAddresses(t *testing.T) {
+	tests := []struct {
+		name       string
+		settings   Settings
+		bindAddrs  []string
+		advertised []string // 各公布地址的 BaseURL，nil 表示不检查
+		wantErr    bool
+	}{
+		{
+			name:       "specific IPv4",
+			settings:   Settings{Listen: "192.0.2.5:9000"},
+			bindAddrs:  []string{"192.0.2.5:9000"},
+			advertised: []string{"http://192.0.2.5:9000"},
+		},
+		{
+			name:       "specific IPv6",
+			settings:   Settings{Listen: "[2001:db8::5]:9000"},
+			bindAddrs:  []string{"[2001:db8::5]:9000"},
+			advertised: []string{"http://[2001:db8::5]:9000"},
+		},
+		{
+			name:      "wildcard",
+			settings:  Settings{Listen: "8080"},
+			bindAddrs: []string{":8080"},
+		},
+		{
+			name:       "public URL replaces advertised addresses",
+			settings:   Settings{Listen: ":8080", PublicURL: "https://files.example.com/"},
+			bindAddrs:  []string{":8080"},
+			advertised: []string{"https://files.example.com"},
+		},
+		{
+			name:     "invalid public URL",
+			settings: Settings{Listen: ":8080", PublicURL: "files.example.com"},
+			wantErr:  true,
+		},
+		{
+			name:     "interfaces with a host",
+			settings: Settings{Listen: "127.0.0.1:8080", Interfaces: []string{"eth0"}},
+			wantErr:  true,
+		},
+		{
+			name:     "interface without addresses",
+			settings: Settings{Listen: "8080", Interfaces: []string{"no-such-interface"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		plan, err := planAddresses(&tt.settings)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if strings.Join(plan.bindAddrs, " ") != strings.Join(tt.bindAddrs, " ") {
+			t.Errorf("%s: bindAddrs = %v, want %v", tt.name, plan.bindAddrs, tt.bindAddrs)
+		}
+		if tt.advertised == nil {
+			continue
+		}
+		var got []string
+		for _, ip := range plan.advertised {
+			got = append(got, ip.BaseURL)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.advertised, " ") {
+			t.Errorf("%s: advertised = %v, want %v", tt.name, got, tt.advertised)
+		}
+	}
+}
+
+func TestPlanDefaultIP(t *testing.T) {
+	plan := &addressPlan{advertised: []IPAddress{{IP: "localhost"}, {IP: "192.168.1.10"}}}
+	if got := plan.defaultIP(); got != "192.168.1.10" {
+		t.Errorf("defaultIP = %q, want the first non-localhost address", got)
+	}
+	plan = &addressPlan{advertised: []IPAddress{{IP: "localhost"}}}
+	if got := plan.defaultIP(); got != "localhost" {
+		t.Errorf("defaultIP = %q, want localhost", got)
+	}
+}
+
+func TestListenAll(t *testing.T) {
+	listeners, err := listenAll([]string{"127.0.0.1:0", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range listeners {
+		if ip := l.Addr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+			t.Errorf("listening on %v, want loopback", ip)
+		}
+		l.Close()
+	}
+	if len(listeners) != 2 {
+		t.Errorf("listeners = %d, want 2", len(listeners))
+	}
+
+	if _, err := listenAll([]string{"no-port"}); err == nil {
+		t.Error("address without port was accepted")
+	}
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"/":             "",
+		"files":         "/files",
+		"/files/":       "/files",
+		"//files//a//":  "/files/a",
+		"/files/../a/":  "/a",
+		"/share/files/": "/share/files",
+	}
+	for in, want := range tests {
+		if got := normalizeBasePath(in); got != want {
+			t.Errorf("normalizeBasePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPlanAddressesBasePath(t *testing.T) {
+	plan, err := planAddresses(&Settings{Listen: "192.0.2.5:9000", BasePath: "/files/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.advertised[0].BaseURL; got != "http://192.0.2.5:9000/files" {
+		t.Errorf("BaseURL = %q, want the base path appended", got)
+	}
+
+	// public_url 自带前缀，不再追加
+	plan, err = planAddresses(&Settings{Listen: ":9000", BasePath: "/files", PublicURL: "https://example.com/files"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.advertised[0].BaseURL; got != "https://example.com/files" {
+		t.Errorf("public BaseURL = %q", got)
+	}
+}
+
+func TestForwardedAddress(t *testing.T) {
+	acl, err := newAccessControl(AccessSettings{TrustedProxies: []string{"10.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ServerConfig{settings: &Settings{}, acl: acl, basePath: "/files"}
+
+	tests := []struct {
+		name        string
+		remoteAddr  string
+		host, proto string
+		want        string // 空表示不采用转发头
+	}{
+		{"trusted proxy", "10.0.0.1:4000", "files.example.com", "https", "https://files.example.com/files"},
+		{"default proto", "10.0.0.1:4000", "files.example.com:8443", "", "http://files.example.com:8443/files"},
+		{"leftmost values", "10.0.0.1:4000", "a.example.com, b.internal", "HTTPS, http", "https://a.example.com/files"},
+		{"IPv6 host", "10.0.0.1:4000", "[2001:db8::1]:8080", "http", "http://[2001:db8::1]:8080/files"},
+		{"untrusted peer", "198.51.100.1:4000", "evil.example.com", "https", ""},
+		{"no host", "10.0.0.1:4000", "", "https", ""},
+		{"bad proto", "10.0.0.1:4000", "files.example.com", "javascript", ""},
+		{"path injection", "10.0.0.1:4000", "files.example.com/evil", "https", ""},
+		{"query injection", "10.0.0.1:4000", "files.example.com?x=1", "https", ""},
+		{"userinfo", "10.0.0.1:4000", "user@files.example.com", "https", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.host != "" {
+			r.Header.Set("X-Forwarded-Host", tt.host)
+		}
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		got, ok := forwardedAddress(r, config)
+		if ok != (tt.want != "") || got.BaseURL != tt.want {
+			t.Errorf("%s: forwardedAddress = %q, %v, want %q", tt.name, got.BaseURL, ok, tt.want)
+		}
+	}
+
+	// 配置了 public_url 时忽略转发头
+	config.settings.PublicURL = "https://public.example.com"
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-Host", "files.example.com")
+	if _, ok := forwardedAddress(r, config); ok {
+		t.Error("forwarded headers were used despite public_url")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// 管理页面（回收站等）的访问控制：除了按路由的访问规则外，配置了 admin_token 时还要求令牌
+func requireAdmin(config *ServerConfig, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := config.settings.AdminToken
+		if token != "" && !checkBearerToken(r, token) {
+			log.Printf("管理页面令牌无效: %s %s (客户端: %s)", r.Method, r.URL.Path, clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			httpError(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 管理页面中的链接和表单需要带上的令牌查询参数，未使用 ?token= 时为空
+func adminTokenQuery(r *http.Request) string {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return ""
+	}
+	return "?" + url.Values{"token": {token}}.Encode()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 剪贴板中的一条文本片段
+type clipItem struct {
+	ID      string    `json:"id"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// 返回给页面的片段，带上文本的二维码
+type clipView struct {
+	clipItem
+	QRCode string `json:"qrcode"` // 二维码图片（data URL），文本过长无法编码时为空
+}
+
+// 剪贴板操作的错误，对应错误码
+var (
+	errClipEmpty    = errors.New("clipboard_empty")
+	errClipTooLong  = errors.New("clipboard_too_long")
+	errClipInvalid  = errors.New("clipboard_invalid_text")
+	errClipNotFound = errors.New("clipboard_not_found")
+)
+
+// 共享剪贴板 - 文本片段保存在内存中，配置了文件时同时写入磁盘，过期后自动删除
+type clipboard struct {
+	mu        sync.Mutex
+	items     []clipItem        // 按创建时间从新到旧排列
+	qrCodes   map[string]string // 片段ID -> 二维码，不写入磁盘
+	file      string            // 保存片段的文件，为空表示只保存在内存中
+	ttl       time.Duration
+	maxItems  int
+	maxLength int
+}
+
+// 根据配置创建剪贴板，配置了文件时从中恢复未过期的片段
+func newClipboard(settings ClipboardSettings) (*clipboard, error) {
+	c := &clipboard{
+		qrCodes:   make(map[string]string),
+		ttl:       time.Duration(settings.ExpireMinutes) * time.Minute,
+		maxItems:  settings.MaxItems,
+		maxLength: settings.MaxLength,
+	}
+	if settings.File == "" {
+		return c, nil
+	}
+
+	file, err := filepath.Abs(settings.File)
+	if err != nil {
+		return nil, err
+	}
+	c.file = file
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.items); err != nil {
+		return nil, fmt.Errorf("解析剪贴板文件 %s 失败: %v", file, err)
+	}
+	c.prune(time.Now())
+	return c, nil
+}
+
+// 删除过期和超出数量的片段，返回是否有删除；调用时需持有锁
+func (c *clipboard) prune(now time.Time) bool {
+	kept := c.items[:0]
+	for _, item := range c.items {
+		if now.Before(item.Expires) && len(kept) < c.maxItems {
+			kept = append(kept, item)
+		} else {
+			delete(c.qrCodes, item.ID)
+		}
+	}
+	removed := len(kept) != len(c.items)
+	c.items = kept
+	return removed
+}
+
+// 把片段写入文件 - 先写临时文件再重命名，避免中途失败留下不完整的内容；调用时需持有锁
+func (c *clipboard) save() {
+	if c.file == "" {
+		return
+	}
+	data, err := json.Marshal(c.items)
+	if err != nil {
+		log.Printf("保存剪贴板失败: %v", err)
+		return
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("保存剪贴板失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		log.Printf("保存剪贴板失败: %v", err)
+	}
+}
+
+// 片段及其二维码，二维码在第一次需要时生成；调用时需持有锁
+func (c *clipboard) view(item clipItem) clipView {
+	qr, ok := c.qrCodes[item.ID]
+	if !ok {
+		qr = generateQRCodeURL(item.Text)
+		c.qrCodes[item.ID] = qr
+	}
+	return clipView{clipItem: item, QRCode: qr}
+}
+
+// 添加一条片段
+func (c *clipboard) add(text string) (clipView, error) {
+	if strings.TrimSpace(text) == "" {
+		return clipView{}, errClipEmpty
+	}
+	if len(text) > c.maxLength {
+		return clipView{}, errClipTooLong
+	}
+	if !utf8.ValidString(text) {
+		return clipView{}, errClipInvalid
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return clipView{}, err
+	}
+	now := time.Now()
+	item := clipItem{ID: hex.EncodeToString(id), Text: text, Created: now, Expires: now.Add(c.ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = append([]clipItem{item}, c.items...)
+	c.prune(now)
+	c.save()
+	return c.view(item), nil
+}
+
+// 所有未过期的片段，从新到旧
+func (c *clipboard) list() []clipView {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prune(time.Now()) {
+		c.save()
+	}
+	views := make([]clipView, 0, len(c.items))
+	for _, item := range c.items {
+		views = append(views, c.view(item))
+	}
+	return views
+}
+
+// 删除一条片段
+func (c *clipboard) remove(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, item := range c.items {
+		if item.ID == id {
+			c.items = append(c.items[:i], c.items[i+1:]...)
+			delete(c.qrCodes, id)
+			c.save()
+			return nil
+		}
+	}
+	return errClipNotFound
+}
+
+// 处理剪贴板请求：GET 列出片段，POST 添加片段（表单字段或JSON的 text），DELETE /clipboard/<id> 删除片段
+func handleClipboard(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	board := config.clipboard
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/clipboard/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		writeJSON(w, http.StatusOK, board.list())
+
+	case r.Method == http.MethodPost && id == "":
+		// 留出表单编码和JSON转义的余量
+		r.Body = http.MaxBytesReader(w, r.Body, int64(board.maxLength)*6+4096)
+		var text string
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+			var body struct {
+				Text string `json:"text"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				httpError(w, r, http.StatusBadRequest, "invalid_request")
+				return
+			}
+			text = body.Text
+		} else {
+			if err := r.ParseForm(); err != nil {
+				httpError(w, r, http.StatusBadRequest, "invalid_request")
+				return
+			}
+			text = r.PostForm.Get("text")
+		}
+
+		view, err := board.add(text)
+		switch {
+		case errors.Is(err, errClipEmpty), errors.Is(err, errClipInvalid):
+			httpError(w, r, http.StatusBadRequest, err.Error())
+		case errors.Is(err, errClipTooLong):
+			httpError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		case err != nil:
+			log.Printf("添加剪贴板片段失败: %v", err)
+			httpError(w, r, http.StatusInternalServerError, "internal")
+		default:
+			log.Printf("新的剪贴板片段: %s (%d 字节, 客户端: %s)", view.ID, len(text), clientIP(r))
+			writeJSON(w, http.StatusCreated, view)
+		}
+
+	case r.Method == http.MethodDelete && id != "":
+		if err := board.remove(id); err != nil {
+			httpError(w, r, http.StatusNotFound, "clipboard_not_found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, "unsupported_method")
+	}
+}
+
+// 以JSON格式返回数据
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("写入JSON响应失败: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClipboard(t *testing.T, settings ClipboardSettings) *clipboard {
+	t.Helper()
+	if settings.ExpireMinutes == 0 {
+		settings.ExpireMinutes = 60
+	}
+	if settings.MaxItems == 0 {
+		settings.MaxItems = 10
+	}
+	if settings.MaxLength == 0 {
+		settings.MaxLength = 100
+	}
+	c, err := newClipboard(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClipboardAdd(t *testing.T) {
+	c := newTestClipboard(t, ClipboardSettings{MaxItems: 2, MaxLength: 10})
+	if _, err := c.add("  \n"); !errors.Is(err, errClipEmpty) {
+		t.Errorf("blank text: err = %v, want errClipEmpty", err)
+	}
+	if _, err := c.add("01234567890"); !errors.Is(err, errClipTooLong) {
+		t.Errorf("long text: err = %v, want errClipTooLong", err)
+	}
+	if _, err := c.add("bad \xff"); !errors.Is(err, errClipInvalid) {
+		t.Errorf("invalid UTF-8: err = %v, want errClipInvalid", err)
+	}
+
+	first, err := c.add("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.QRCode == "" || !strings.HasPrefix(first.QRCode, "data:image/png;base64,") {
+		t.Error("snippet has no QR code")
+	}
+	c.add("second")
+	c.add("third")
+
+	// 从新到旧，超出数量时删除最旧的
+	var texts []string
+	for _, view := range c.list() {
+		texts = append(texts, view.Text)
+	}
+	if strings.Join(texts, ",") != "third,second" {
+		t.Errorf("list = %v, want [third second]", texts)
+	}
+	if _, ok := c.qrCodes[first.ID]; ok {
+		t.Error("QR code of a dropped snippet was kept")
+	}
+}
+
+func TestClipboardExpiry(t *testing.T) {
+	c := newTestClipboard(t, ClipboardSettings{})
+	old, _ := c.add("old")
+	c.add("new")
+
+	c.mu.Lock()
+	c.items[1].Expires = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+
+	views := c.list()
+	if len(views) != 1 || views[0].Text != "new" {
+		t.Errorf("list after expiry = %+v, want only the new snippet", views)
+	}
+	if err := c.remove(old.ID); !errors.Is(err, errClipNotFound) {
+		t.Errorf("remove expired: err = %v, want errClipNotFound", err)
+	}
+}
+
+func TestClipboardFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "clipboard.json")
+	c := newTestClipboard(t, ClipboardSettings{File: file})
+	kept, _ := c.add("kept")
+	removed, _ := c.add("removed")
+	expired, _ := c.add("expired")
+	if err := c.remove(removed.ID); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	for i := range c.items {
+		if c.items[i].ID == expired.ID {
+			c.items[i].Expires = time.Now().Add(-time.Second)
+		}
+	}
+	c.save()
+	c.mu.Unlock()
+
+	// 重新加载时只恢复未过期的片段
+	reloaded := newTestClipboard(t, ClipboardSettings{File: file})
+	views := reloaded.list()
+	if len(views) != 1 || views[0].ID != kept.ID || views[0].Text != "kept" {
+		t.Errorf("reloaded = %+v, want only the kept snippet", views)
+	}
+}
+
+func TestClipboardHandler(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+
+	// 表单和JSON都可以添加片段
+	r := httptest.NewRequest(http.MethodPost, "/clipboard/", strings.NewReader(url.Values{"text": {"from form"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(config, r); w.Code != http.StatusCreated {
+		t.Fatalf("form: status = %d, body = %s", w.Code, w.Body)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/clipboard/", strings.NewReader(`{"text":"from json"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(config, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("json: status = %d, body = %s", w.Code, w.Body)
+	}
+	var created clipView
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.Text != "from json" {
+		t.Fatalf("created = %+v, %v", created, err)
+	}
+
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/clipboard/", nil))
+	var views []clipView
+	if err := json.NewDecoder(w.Body).Decode(&views); err != nil || len(views) != 2 {
+		t.Fatalf("list = %+v, %v", views, err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/clipboard/", strings.NewReader(`{"text":""}`))
+	r.Header.Set("Content-Type", "application/json")
+	if w := serve(config, r); w.Code != http.StatusBadRequest || w.Header().Get("X-Error-Code") != "clipboard_empty" {
+		t.Errorf("empty: status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+	r = httptest.NewRequest(http.MethodPost, "/clipboard/", strings.NewReader("text=bad%FF"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(config, r); w.Code != http.StatusBadRequest || w.Header().Get("X-Error-Code") != "clipboard_invalid_text" {
+		t.Errorf("invalid UTF-8: status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+
+	if w := serve(config, httptest.NewRequest(http.MethodDelete, "/clipboard/"+created.ID, nil)); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", w.Code)
+	}
+	if w := serve(config, httptest.NewRequest(http.MethodDelete, "/clipboard/"+created.ID, nil)); w.Code != http.StatusNotFound {
+		t.Errorf("delete again: status = %d, want 404", w.Code)
+	}
+	if w := serve(config, httptest.NewRequest(http.MethodPut, "/clipboard/", nil)); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: status = %d, want 405", w.Code)
+	}
+
+	// 关闭剪贴板时没有该路由
+	settings := defaultSettings()
+	settings.Clipboard.Disabled = true
+	if w := serve(newTestConfig(t, settings, nil), httptest.NewRequest(http.MethodGet, "/clipboard/", nil)); w.Code == http.StatusOK {
+		t.Error("disabled clipboard is still served")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// 配置文件路径 - 通过 -config 参数指定，未指定时使用内置默认值
+var configFile = flag.String("config", "", "JSON配置文件路径")
+
+// 配置文件结构
+type Settings struct {
+	ShareDir string          `json:"share_dir"` // 要共享的目录
+	Storage  StorageSettings `json:"storage"`   // 存储后端配置
+	Listen   string          `json:"listen"`    // 服务器监听地址，如 ":8080"、"0.0.0.0:8080"、"192.168.1.5:8080"
+	Metrics  MetricsSettings `json:"metrics"`   // 监控指标配置
+	Limits   LimitSettings   `json:"limits"`    // 限流配置
+	Access   AccessSettings  `json:"access"`    // 访问控制配置
+
+	// 只在这些网卡的地址上监听（如 ["eth0"]），此时 listen 只需给出端口
+	Interfaces []string `json:"interfaces"`
+
+	// 对外访问地址，反向代理部署时设置（如 "https://files.example.com"），二维码只使用该地址
+	PublicURL string `json:"public_url"`
+
+	// URL前缀，部署在反向代理子路径下时设置（如 "/files/"），所有路由、链接和二维码都会带上
+	BasePath string `json:"base_path"`
+
+	// 主题目录，其中 templates/ 和 static/ 下的同名文件覆盖内置的页面模板和静态资源
+	ThemeDir string `json:"theme_dir"`
+
+	// 列出IPv6链路本地地址的接口名（如 eth0），生成的地址会带上zone；默认不列出链路本地地址
+	IPv6LinkLocalZones []string `json:"ipv6_link_local_zones"`
+
+	// 携带用户名的请求头，由前置的认证代理设置（如 X-Remote-User），为空表示不区分用户；
+	// 只采用来自 access.trusted_proxies 的请求中的值
+	UserHeader string `json:"user_header"`
+
+	// 共享剪贴板配置
+	Clipboard ClipboardSettings `json:"clipboard"`
+
+	// 目录实时更新配置
+	Watch WatchSettings `json:"watch"`
+
+	// 上传配置
+	Upload UploadSettings `json:"upload"`
+
+	// 上传配额与磁盘空间保护配置
+	Quota QuotaSettings `json:"quota"`
+
+	// 上传文件的病毒扫描配置
+	Scan ScanSettings `json:"scan"`
+
+	// 回收站配置
+	Trash TrashSettings `json:"trash"`
+
+	// 上传覆盖文件时保留的历史版本
+	Versions VersionSettings `json:"versions"`
+
+	// 文件校验和
+	Hash HashSettings `json:"hash"`
+
+	// 重复文件检测
+	Duplicates DuplicateSettings `json:"duplicates"`
+
+	// 隐藏文件和符号链接
+	Files FileSettings `json:"files"`
+
+	// 管理页面（回收站等）的访问令牌（Bearer 或 ?token=），为空时只受访问控制规则限制
+	AdminToken string `json:"admin_token"`
+}
+
+// 存储后端配置 - 默认直接提供共享目录中的文件
+type StorageSettings struct {
+	Type string `json:"type"` // local（默认，共享目录）、memory（内存中，重启后清空）、zip（只读，提供zip文件中的内容）
+	Path string `json:"path"` // zip文件的路径
+}
+
+// 监控指标配置
+type MetricsSettings struct {
+	Enabled bool   `json:"enabled"` // 是否启用 /metrics
+	Token   string `json:"token"`   // 访问令牌（Bearer 或 ?token=）
+	Listen  string `json:"listen"`  // 独立监听地址，设置后 /metrics 只在该地址提供
+}
+
+// 共享剪贴板配置
+type ClipboardSettings struct {
+	Disabled      bool   `json:"disabled"`       // 关闭共享剪贴板
+	File          string `json:"file"`           // 保存文本片段的文件，为空时只保存在内存中，重启后清空
+	ExpireMinutes int    `json:"expire_minutes"` // 片段保留时间（分钟）
+	MaxItems      int    `json:"max_items"`      // 最多保留的片段数，超出时删除最旧的
+	MaxLength     int    `json:"max_length"`     // 单个片段的最大字节数
+}
+
+// 目录实时更新配置 - 浏览页面通过SSE接收当前目录的变化
+type WatchSettings struct {
+	Mode         string `json:"mode"`          // auto（默认，Linux上使用inotify，其他系统轮询）、poll（只用轮询）、off（关闭）
+	PollInterval int    `json:"poll_interval"` // 轮询间隔（秒）
+}
+
+// 上传配置 - 大小单位为字节，0表示不限制
+type UploadSettings struct {
+	MaxFileSize    int64 `json:"max_file_size"`    // 单个文件的大小上限
+	MaxRequestSize int64 `json:"max_request_size"` // 单次上传请求的大小上限
+	MaxNameLength  int   `json:"max_name_length"`  // 文件名（上传文件夹时为每一级名称）的最大字符数
+
+	// 按扩展名（如 ".exe"）和检测到的内容类型（如 "application/x-msdownload"、"image/*"）限制上传的文件，
+	// 拒绝优先，允许列表为空表示全部允许
+	AllowExtensions []string `json:"allow_extensions"`
+	DenyExtensions  []string `json:"deny_extensions"`
+	AllowTypes      []string `json:"allow_types"`
+	DenyTypes       []string `json:"deny_types"`
+}
+
+// 上传配额配置 - 大小单位为字节，0表示不限制
+type QuotaSettings struct {
+	MinFreeSpace   int64      `json:"min_free_space"`  // 磁盘至少保留的可用空间，上传不会占用这部分空间
+	PerUser        int64      `json:"per_user"`        // 每个用户（未配置 user_header 时按IP）上传文件的总大小上限
+	Directories    []DirQuota `json:"directories"`     // 按目录的总大小上限
+	UsageFile      string     `json:"usage_file"`      // 保存上传者记录的文件，为空时只保存在内存中
+	RefreshMinutes int        `json:"refresh_minutes"` // 后台重新统计目录用量的间隔（分钟）
+}
+
+// 目录配额 - 目录及其子目录中所有文件的总大小上限
+type DirQuota struct {
+	Path  string `json:"path"`  // 共享目录中的路径，如 "/incoming"
+	Limit int64  `json:"limit"` // 大小上限（字节）
+}
+
+// 病毒扫描配置 - 上传完成后在后台扫描，扫描完成前文件不能下载，发现威胁的文件移到隔离目录
+type ScanSettings struct {
+	Mode              string   `json:"mode"`                // clamd、command，为空或 off 表示不扫描
+	Clamd             string   `json:"clamd"`               // clamd地址，如 "unix:/run/clamav/clamd.ctl"、"127.0.0.1:3310"
+	Command           []string `json:"command"`             // 扫描命令及参数，{file} 替换为文件路径；退出码0干净、1发现威胁
+	QuarantineDir     string   `json:"quarantine_dir"`      // 隔离目录，必须位于共享目录之外
+	QuarantineOnError bool     `json:"quarantine_on_error"` // 扫描出错时也隔离文件
+	Timeout           int      `json:"timeout"`             // 单个文件的扫描超时（秒）
+	Wait              int      `json:"wait"`                // 上传请求等待扫描结果的最长时间（秒），超时后结果为 pending
+	Workers           int      `json:"workers"`             // 同时扫描的文件数
+}
+
+// 回收站配置 - 删除和被覆盖的文件移到共享目录下的 .trash 中
+type TrashSettings struct {
+	Disabled   bool  `json:"disabled"`    // 关闭回收站，删除和覆盖直接生效
+	ExpireDays int   `json:"expire_days"` // 保留天数，0表示不按时间清理
+	MaxSize    int64 `json:"max_size"`    // 总大小上限（字节），超出时从最旧的开始清理，0表示不限制
+}
+
+// 历史版本配置
+type VersionSettings struct {
+	Disabled    bool `json:"disabled"`     // 关闭历史版本，覆盖时按回收站配置处理原文件
+	MaxVersions int  `json:"max_versions"` // 每个文件最多保留的版本数，0表示不限制
+	MaxAgeDays  int  `json:"max_age_days"` // 版本保留天数，0表示不按时间清理
+}
+
+// 校验和配置
+type HashSettings struct {
+	CacheEntries  int   `json:"cache_entries"`   // 缓存的文件数，按路径、修改时间和大小缓存计算结果
+	DigestMaxSize int64 `json:"digest_max_size"` // 下载时在后台计算 Repr-Digest 的文件大小上限（字节），更大的文件只使用缓存的结果，0表示只使用缓存
+}
+
+// 重复文件检测配置 - 后台按大小和内容的SHA-256查找重复文件
+type DuplicateSettings struct {
+	Disabled      bool  `json:"disabled"`       // 关闭重复文件检测
+	IntervalHours int   `json:"interval_hours"` // 后台重新检测的间隔（小时），0表示只在启动时和手动检测
+	MinSize       int64 `json:"min_size"`       // 参与检测的最小文件大小（字节），空文件总是跳过
+}
+
+// 隐藏文件和符号链接配置 - 隐藏的文件不显示，也不能下载、上传或删除
+type FileSettings struct {
+	Hidden     []string `json:"hidden"`      // 隐藏的路径，语法与 .gitignore 相同（如 ".*"、"*.tmp"、"/private/"），相对于共享目录
+	IgnoreFile string   `json:"ignore_file"` // 各目录中追加隐藏规则的文件名，规则相对于该文件所在目录，为空表示不读取
+	Symlinks   string   `json:"symlinks"`    // 符号链接策略：deny（不允许）、within_root（默认，只允许指向共享目录内）、follow（不检查）
+}
+
+// 限流配置 - 带宽单位为字节/秒，0表示不限制
+type LimitSettings struct {
+	GlobalBandwidth  int64   `json:"global_bandwidth"`   // 全局带宽上限
+	PerIPBandwidth   int64   `json:"per_ip_bandwidth"`   // 每IP带宽上限
+	PerUserBandwidth int64   `json:"per_user_bandwidth"` // 每用户带宽上限
+	RequestRate      float64 `json:"request_rate"`       // 每IP每秒请求数
+	RequestBurst     int     `json:"request_burst"`      // 每IP允许的突发请求数
+	MaxDownloads     int     `json:"max_downloads"`      // 同时进行的下载数上限
+}
+
+// 访问控制配置 - 列表项为CIDR或单个IP，拒绝优先，允许列表为空表示全部允许
+type AccessSettings struct {
+	Allow          []string     `json:"allow"`           // 全局允许的网段
+	Deny           []string     `json:"deny"`            // 全局拒绝的网段
+	Rules          []AccessRule `json:"rules"`           // 按路由的附加规则
+	TrustedProxies []string     `json:"trusted_proxies"` // 受信任的反向代理，只有来自这些地址的 X-Forwarded-For 才会被采用
+}
+
+// 按路由的访问规则，在全局规则之外额外生效
+type AccessRule struct {
+	Route string   `json:"route"` // 路由名: browse, download, upload, delete, trash, duplicates, versions, hash, usage, qrcode, clipboard, events, static, metrics
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// 默认配置
+func defaultSettings() *Settings {
+	return &Settings{
+		ShareDir: shareDir,
+		Listen:   serverAddr,
+		Clipboard: ClipboardSettings{
+			ExpireMinutes: 24 * 60,
+			MaxItems:      50,
+			MaxLength:     64 * 1024,
+		},
+		Watch: WatchSettings{
+			Mode:         "auto",
+			PollInterval: 2,
+		},
+		Upload: UploadSettings{
+			MaxRequestSize: 1024 * 1024 * 1024,
+			MaxNameLength:  255,
+		},
+		Scan: ScanSettings{
+			Timeout: 120,
+			Wait:    5,
+			Workers: 2,
+		},
+		Trash: TrashSettings{
+			ExpireDays: 30,
+		},
+		Versions: VersionSettings{
+			MaxVersions: 10,
+			MaxAgeDays:  90,
+		},
+		Hash: HashSettings{
+			CacheEntries:  1000,
+			DigestMaxSize: 64 * 1024 * 1024,
+		},
+		Duplicates: DuplicateSettings{
+			IntervalHours: 24,
+			MinSize:       1,
+		},
+		Files: FileSettings{
+			Hidden:     []string{".*", "Thumbs.db", "desktop.ini", "$RECYCLE.BIN/", "System Volume Information/"},
+			IgnoreFile: ".fileserverignore",
+			Symlinks:   symlinksWithinRoot,
+		},
+		Quota: QuotaSettings{
+			MinFreeSpace:   512 * 1024 * 1024,
+			RefreshMinutes: 10,
+		},
+	}
+}
+
+// 规范化URL前缀：以/开头、不以/结尾，根路径返回空字符串
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + path.Clean(basePath)
+}
+
+// 加载配置文件，文件中未出现的字段保留默认值
+func loadSettings(configPath string) (*Settings, error) {
+	settings := defaultSettings()
+	if configPath == "" {
+		return settings, nil
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	return settings, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// 删除操作的结果
+type deleteResult struct {
+	Path    string `json:"path"`               // 被删除的文件在共享目录中的相对路径
+	TrashID string `json:"trash_id,omitempty"` // 回收站中的ID，关闭回收站时为空（已彻底删除）
+}
+
+// 处理删除请求：DELETE 或 POST /delete/<路径>，启用回收站时移到回收站，否则彻底删除
+func handleFileDelete(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "unsupported_method")
+		return
+	}
+
+	urlRelativePath := strings.TrimPrefix(r.URL.Path, "/delete/")
+	cleaned, name, err := config.paths.validate(urlRelativePath)
+	if err != nil {
+		log.Printf("删除路径验证失败: %v (原始路径: %s)", err, urlRelativePath)
+		httpError(w, r, http.StatusForbidden, "forbidden_path")
+		return
+	}
+	// 不能删除共享目录本身
+	if name == "." {
+		httpError(w, r, http.StatusForbidden, "forbidden_path")
+		return
+	}
+	if isReadOnlyStorage(config.store) {
+		httpError(w, r, http.StatusForbidden, "read_only_storage")
+		return
+	}
+	info, err := config.store.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			httpError(w, r, http.StatusNotFound, "file_not_found")
+		} else {
+			log.Printf("获取文件信息错误: %v (路径: %s)", err, name)
+			httpError(w, r, http.StatusInternalServerError, "internal")
+		}
+		return
+	}
+	// 还在等待扫描的文件不能删除，否则从回收站恢复后就绕过了扫描
+	if config.scanner != nil && config.scanner.scanning(name) {
+		httpError(w, r, http.StatusConflict, "scan_in_progress")
+		return
+	}
+	// 没有回收站时删除无法撤销，不一次删除整个目录
+	if config.trash == nil && info.IsDir() {
+		entries, err := config.store.ReadDir(name)
+		if err != nil {
+			log.Printf("读取目录失败: %v (路径: %s)", err, name)
+			httpError(w, r, http.StatusInternalServerError, "delete_failed")
+			return
+		}
+		if len(entries) > 0 {
+			httpError(w, r, http.StatusConflict, "directory_not_empty")
+			return
+		}
+	}
+
+	user := uploadUser(r, config)
+	result := deleteResult{Path: cleaned}
+	if config.trash != nil {
+		item, err := config.trash.put(name, user, "delete")
+		if err != nil {
+			log.Printf("移到回收站失败: %v (路径: %s)", err, name)
+			httpError(w, r, http.StatusInternalServerError, "delete_failed")
+			return
+		}
+		result.TrashID = item.ID
+		log.Printf("已移到回收站: %s (用户: %s, ID: %s)", cleaned, user, item.ID)
+	} else {
+		if err := config.store.Remove(name); err != nil {
+			log.Printf("删除失败: %v (路径: %s)", err, name)
+			httpError(w, r, http.StatusInternalServerError, "delete_failed")
+			return
+		}
+		config.usage.recordRemove(name)
+		log.Printf("已删除: %s (用户: %s)", cleaned, user)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// 获取路径所在磁盘的可用空间和总空间（字节）
+func diskSpace(dir string) (free, total uint64, err error) {
+	dirPtr, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var freeToCaller, totalBytes, totalFree uint64
+	ret, _, callErr := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(dirPtr)),
+		uintptr(unsafe.Pointer(&freeToCaller)),
+		uintptr(unsafe.Pointer(&totalBytes)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if ret == 0 {
+		return 0, 0, callErr
+	}
+	return freeToCaller, totalBytes, nil
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 一组重复文件中的一个
+type duplicateFile struct {
+	Path    string    `json:"path"`     // 共享目录中的相对路径（正斜杠）
+	ModTime time.Time `json:"mod_time"` // 检测时的修改时间
+	Linked  bool      `json:"linked"`   // 与组中前面的某个文件是同一个文件（硬链接），不占用额外空间
+
+	info os.FileInfo // 检测时的文件信息，用于判断硬链接和文件是否被修改
+}
+
+// 内容相同的一组文件，第一个是建议保留的文件
+type duplicateGroup struct {
+	Hash        string          `json:"hash"` // SHA-256（十六进制）
+	Size        int64           `json:"size"`
+	Reclaimable int64           `json:"reclaimable"` // 只保留一份时可以释放的空间
+	Files       []duplicateFile `json:"files"`
+}
+
+// 一次检测的结果
+type duplicateReport struct {
+	Started     time.Time        `json:"started"`
+	Finished    time.Time        `json:"finished"`
+	Running     bool             `json:"running"` // 正在进行新的检测
+	Scanned     int              `json:"scanned"` // 检查过的文件数
+	Reclaimable int64            `json:"reclaimable"`
+	Groups      []duplicateGroup `json:"groups"`
+}
+
+// 重复文件处理中失败的一项
+type duplicateFailure struct {
+	Path    string `json:"path"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// 批量处理的结果
+type duplicateActionResult struct {
+	Action string             `json:"action"` // delete 或 link
+	Done   []string           `json:"done"`
+	Failed []duplicateFailure `json:"failed"`
+}
+
+// 处理重复文件时的错误，与错误码对应
+var (
+	errDuplicateChanged  = errors.New("duplicate_changed")
+	errDuplicateLastCopy = errors.New("duplicate_last_copy")
+	errDuplicateUnknown  = errors.New("duplicate_not_found")
+	errDuplicateNoLink   = errors.New("duplicate_link_unsupported")
+)
+
+// 重复文件检测 - 在后台先按大小分组，再按内容的SHA-256分组
+type duplicateFinder struct {
+	mu       sync.Mutex // 保护 report
+	applying sync.Mutex // 同时只进行一次处理，避免两次处理把同一组中的文件各自当作保留的文件而全部删除
+	store    storage
+	minSize  int64
+	hashes   *hashCache
+	trash    *trashBin
+	usage    *usageTracker
+	paths    *pathPolicy
+	report   duplicateReport
+	trigger  chan struct{}
+	interval time.Duration
+}
+
+// 根据配置创建重复文件检测，关闭时返回nil；启动后立即在后台检测一次
+func newDuplicateFinder(settings DuplicateSettings, store storage, hashes *hashCache, trash *trashBin, usage *usageTracker, paths *pathPolicy) *duplicateFinder {
+	if settings.Disabled {
+		return nil
+	}
+	f := &duplicateFinder{
+		store:    store,
+		minSize:  settings.MinSize,
+		hashes:   hashes,
+		trash:    trash,
+		usage:    usage,
+		paths:    paths,
+		trigger:  make(chan struct{}, 1),
+		interval: time.Duration(settings.IntervalHours) * time.Hour,
+	}
+	if f.minSize < 1 {
+		f.minSize = 1
+	}
+	f.rescan()
+	go f.loop()
+	return f
+}
+
+// 请求重新检测，正在检测时忽略
+func (f *duplicateFinder) rescan() {
+	select {
+	case f.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// 按请求和配置的间隔进行检测
+func (f *duplicateFinder) loop() {
+	var tick <-chan time.Time
+	if f.interval > 0 {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-f.trigger:
+		case <-tick:
+		}
+		f.scan()
+	}
+}
+
+// 检测一次，完成后替换之前的结果
+func (f *duplicateFinder) scan() {
+	f.mu.Lock()
+	f.report.Running = true
+	f.mu.Unlock()
+	started := time.Now()
+
+	// 按大小分组，跳过内部目录、隐藏的文件、符号链接和特殊文件
+	bySize := make(map[int64][]duplicateFile)
+	scanned := 0
+	fs.WalkDir(f.store, ".", func(rel string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if rel != "." && (isInternalPath(rel) || f.paths.hidden(rel, true)) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || f.paths.hidden(rel, false) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() < f.minSize {
+			return nil
+		}
+		scanned++
+		bySize[info.Size()] = append(bySize[info.Size()], duplicateFile{Path: rel, ModTime: info.ModTime(), info: info})
+		return nil
+	})
+
+	// 大小相同的文件再按内容分组
+	var groups []duplicateGroup
+	for size, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+		byHash := make(map[string][]duplicateFile)
+		for _, file := range files {
+			sum, err := f.hashes.sum(file.Path, file.info, "sha256")
+			if err != nil {
+				continue
+			}
+			key := hex.EncodeToString(sum)
+			byHash[key] = append(byHash[key], file)
+		}
+		for sum, same := range byHash {
+			if len(same) < 2 {
+				continue
+			}
+			groups = append(groups, duplicateGroup{Hash: sum, Size: size, Files: same})
+		}
+	}
+
+	report := duplicateReport{Started: started, Finished: time.Now(), Scanned: scanned, Groups: groups}
+	report.summarize()
+	log.Printf("重复文件检测完成: 检查 %d 个文件，%d 组重复，可释放 %s (用时 %v)",
+		scanned, len(groups), humanizeSize(report.Reclaimable), report.Finished.Sub(started).Round(time.Second))
+
+	f.mu.Lock()
+	f.report = report
+	f.mu.Unlock()
+}
+
+// 整理结果：组内按建议保留的顺序排列（路径短、修改早的在前，一般是原始文件），
+// 标出硬链接，去掉不再占用额外空间的组，统计可释放的空间，可释放空间多的组在前
+func (report *duplicateReport) summarize() {
+	groups := report.Groups[:0]
+	report.Reclaimable = 0
+	for _, group := range report.Groups {
+		files := group.Files
+		sort.SliceStable(files, func(i, j int) bool {
+			if len(files[i].Path) != len(files[j].Path) {
+				return len(files[i].Path) < len(files[j].Path)
+			}
+			if !files[i].ModTime.Equal(files[j].ModTime) {
+				return files[i].ModTime.Before(files[j].ModTime)
+			}
+			return files[i].Path < files[j].Path
+		})
+		distinct := 0
+		for i := range files {
+			files[i].Linked = false
+			for j := 0; j < i; j++ {
+				if os.SameFile(files[i].info, files[j].info) {
+					files[i].Linked = true
+					break
+				}
+			}
+			if !files[i].Linked {
+				distinct++
+			}
+		}
+		// 全部是同一个文件的硬链接时不再算作重复
+		if distinct < 2 {
+			continue
+		}
+		group.Reclaimable = group.Size * int64(distinct-1)
+		report.Reclaimable += group.Reclaimable
+		groups = append(groups, group)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Reclaimable != groups[j].Reclaimable {
+			return groups[i].Reclaimable > groups[j].Reclaimable
+		}
+		return groups[i].Files[0].Path < groups[j].Files[0].Path
+	})
+	report.Groups = groups
+}
+
+// 当前的检测结果
+func (f *duplicateFinder) current() duplicateReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	report := f.report
+	report.Groups = append([]duplicateGroup(nil), f.report.Groups...)
+	return report
+}
+
+// 处理选中的重复文件：delete 移到回收站（关闭回收站时直接删除），link 替换为指向保留文件的硬链接（只支持本地存储）。
+// 每组中没有选中的第一个文件作为保留的文件，整组都被选中时不处理，避免删除最后一份
+func (f *duplicateFinder) apply(action string, paths []string, user, lang string) duplicateActionResult {
+	result := duplicateActionResult{Action: action, Done: []string{}, Failed: []duplicateFailure{}}
+	selected := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		selected[strings.TrimPrefix(path.Clean("/"+p), "/")] = true
+	}
+	fail := func(p string, err error) {
+		code := "duplicate_failed"
+		if errors.Is(err, errDuplicateChanged) || errors.Is(err, errDuplicateLastCopy) || errors.Is(err, errDuplicateUnknown) || errors.Is(err, errDuplicateNoLink) {
+			code = err.Error()
+		} else {
+			log.Printf("处理重复文件失败: %v (操作: %s, 文件: %s)", err, action, p)
+		}
+		result.Failed = append(result.Failed, duplicateFailure{Path: p, Error: code, Message: translate(lang, "error."+code)})
+	}
+
+	f.applying.Lock()
+	defer f.applying.Unlock()
+
+	// 在锁内复制当前的分组，处理文件时不持有锁，页面和后台检测不会被文件操作阻塞
+	f.mu.Lock()
+	groups := make([]duplicateGroup, len(f.report.Groups))
+	for i, group := range f.report.Groups {
+		group.Files = append([]duplicateFile(nil), group.Files...)
+		groups[i] = group
+	}
+	f.mu.Unlock()
+
+	// 处理过的文件：路径 -> 更新后的条目，删除的文件为nil
+	changed := make(map[string]*duplicateFile)
+	for gi := range groups {
+		group := &groups[gi]
+		var keep *duplicateFile
+		for _, file := range group.Files {
+			if !selected[file.Path] {
+				file := file
+				keep = &file
+				break
+			}
+		}
+		for _, file := range group.Files {
+			if !selected[file.Path] {
+				continue
+			}
+			delete(selected, file.Path)
+			if keep == nil {
+				fail(file.Path, errDuplicateLastCopy)
+				continue
+			}
+			updated, err := f.applyOne(action, group, *keep, file, user)
+			if err != nil {
+				fail(file.Path, err)
+				continue
+			}
+			result.Done = append(result.Done, file.Path)
+			changed[file.Path] = updated
+		}
+	}
+	for p := range selected {
+		fail(p, errDuplicateUnknown)
+	}
+
+	// 重新加锁更新结果；处理期间完成的新检测同样按处理过的文件更新
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for gi := range f.report.Groups {
+		group := &f.report.Groups[gi]
+		remaining := make([]duplicateFile, 0, len(group.Files))
+		for _, file := range group.Files {
+			updated, ok := changed[file.Path]
+			switch {
+			case !ok:
+				remaining = append(remaining, file)
+			case updated != nil:
+				remaining = append(remaining, *updated)
+			}
+		}
+		group.Files = remaining
+	}
+	f.report.summarize()
+	return result
+}
+
+// 处理一个重复文件，替换为硬链接时返回更新后的条目；调用时持有 applying，不持有 mu
+func (f *duplicateFinder) applyOne(action string, group *duplicateGroup, keep, target duplicateFile, user string) (*duplicateFile, error) {
+	// 检测之后两个文件都可能被修改过，处理前重新确认内容相同
+	var infos [2]os.FileInfo
+	for i, file := range []duplicateFile{keep, target} {
+		info, err := f.store.Lstat(file.Path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != group.Size {
+			return nil, errDuplicateChanged
+		}
+		sum, err := f.hashes.sum(file.Path, info, "sha256")
+		if err != nil || hex.EncodeToString(sum) != group.Hash {
+			return nil, errDuplicateChanged
+		}
+		infos[i] = info
+	}
+
+	switch action {
+	case "delete":
+		if f.trash != nil {
+			if _, err := f.trash.put(target.Path, user, "duplicate"); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := f.store.Remove(target.Path); err != nil {
+				return nil, err
+			}
+			f.usage.recordRemove(target.Path)
+		}
+		log.Printf("已删除重复文件: %s (保留: %s, 用户: %s)", target.Path, keep.Path, user)
+		return nil, nil
+
+	case "link":
+		keepPath, ok := storageLocalPath(f.store, keep.Path)
+		if !ok {
+			return nil, errDuplicateNoLink
+		}
+		targetPath, _ := storageLocalPath(f.store, target.Path)
+		if !os.SameFile(infos[0], infos[1]) {
+			// 先在同一目录中创建硬链接，再替换原文件，替换失败时原文件保持不变
+			suffix := make([]byte, 4)
+			if _, err := rand.Read(suffix); err != nil {
+				return nil, err
+			}
+			tmp := filepath.Join(filepath.Dir(targetPath), ".fileserver-link-"+hex.EncodeToString(suffix))
+			if err := os.Link(keepPath, tmp); err != nil {
+				return nil, err
+			}
+			if err := os.Rename(tmp, targetPath); err != nil {
+				os.Remove(tmp)
+				return nil, err
+			}
+			log.Printf("已替换为硬链接: %s -> %s (用户: %s)", target.Path, keep.Path, user)
+		}
+		info, err := os.Lstat(targetPath)
+		if err != nil {
+			return nil, err
+		}
+		target.info = info
+		target.ModTime = info.ModTime()
+		return &target, nil
+	}
+	return nil, errDuplicateUnknown
+}
+
+// 处理重复文件页面的请求：
+// GET /duplicates/ 查看检测结果，POST /duplicates/scan 重新检测，
+// POST /duplicates/delete 或 /duplicates/link 处理表单中选中的文件（path 字段，可以有多个）
+func handleDuplicates(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	finder := config.duplicates
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/duplicates/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		report := finder.current()
+		if errorFormat(r) == "json" {
+			writeJSON(w, http.StatusOK, report)
+			return
+		}
+		renderDuplicatesPage(w, r, config, report)
+
+	case r.Method == http.MethodPost && action == "scan":
+		finder.rescan()
+		log.Printf("开始重复文件检测 (客户端: %s)", clientIP(r))
+		if errorFormat(r) == "json" {
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "scanning"})
+			return
+		}
+		http.Redirect(w, r, config.basePath+"/duplicates/"+adminTokenQuery(r), http.StatusSeeOther)
+
+	case r.Method == http.MethodPost && (action == "delete" || action == "link"):
+		if err := r.ParseForm(); err != nil {
+			httpError(w, r, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		result := finder.apply(action, r.PostForm["path"], uploadUser(r, config), requestLanguage(r))
+		if errorFormat(r) == "json" {
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+		query := adminTokenQuery(r)
+		if query == "" {
+			query = "?"
+		} else {
+			query += "&"
+		}
+		query += "done=" + strconv.Itoa(len(result.Done)) + "&failed=" + strconv.Itoa(len(result.Failed))
+		http.Redirect(w, r, config.basePath+"/duplicates/"+query, http.StatusSeeOther)
+
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, "unsupported_method")
+	}
+}
+
+// 重复文件页面中的一组
+type duplicateGroupView struct {
+	duplicateGroup
+	ShortHash       string
+	SizeText        string
+	ReclaimableText string
+	Files           []duplicateFileView
+}
+
+// 重复文件页面中的一个文件
+type duplicateFileView struct {
+	duplicateFile
+	ModTimeText string
+	URL         string
+	Selected    bool // 默认选中：除第一个文件和硬链接以外的文件
+}
+
+// 渲染重复文件页面
+func renderDuplicatesPage(w http.ResponseWriter, r *http.Request, config *ServerConfig, report duplicateReport) {
+	groups := make([]duplicateGroupView, 0, len(report.Groups))
+	for _, group := range report.Groups {
+		view := duplicateGroupView{
+			duplicateGroup:  group,
+			ShortHash:       group.Hash[:12],
+			SizeText:        humanizeSize(group.Size),
+			ReclaimableText: humanizeSize(group.Reclaimable),
+		}
+		for i, file := range group.Files {
+			view.Files = append(view.Files, duplicateFileView{
+				duplicateFile: file,
+				ModTimeText:   file.ModTime.Format("2006-01-02 15:04:05"),
+				URL:           config.basePath + downloadPath(file.Path),
+				Selected:      i > 0 && !file.Linked,
+			})
+		}
+		groups = append(groups, view)
+	}
+	query := r.URL.Query()
+	done, _ := strconv.Atoi(query.Get("done"))
+	failed, _ := strconv.Atoi(query.Get("failed"))
+	data := struct {
+		Lang        string
+		Theme       string
+		BasePath    string
+		TokenQuery  string
+		Running     bool
+		Scanned     bool
+		Finished    string
+		Duration    string
+		Files       int
+		Groups      []duplicateGroupView
+		Reclaimable string
+		Trash       bool
+		Link        bool
+		Done        int
+		Failed      int
+	}{
+		Lang:        requestLanguage(r),
+		Theme:       requestTheme(r),
+		BasePath:    config.basePath,
+		TokenQuery:  adminTokenQuery(r),
+		Running:     report.Running,
+		Scanned:     !report.Finished.IsZero(),
+		Finished:    report.Finished.Format("2006-01-02 15:04:05"),
+		Duration:    report.Finished.Sub(report.Started).Round(time.Second).String(),
+		Files:       report.Scanned,
+		Groups:      groups,
+		Reclaimable: humanizeSize(report.Reclaimable),
+		Trash:       config.trash != nil,
+		Link:        isLocalStorage(config.store),
+		Done:        done,
+		Failed:      failed,
+	}
+
+	var buf bytes.Buffer
+	if err := config.assets.templates.ExecuteTemplate(&buf, "duplicates.html", data); err != nil {
+		log.Printf("模板执行错误: %v", err)
+		httpError(w, r, http.StatusInternalServerError, "template_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 为测试配置创建重复文件检测并立即检测一次，不启动后台检测
+func newTestDuplicateFinder(t *testing.T, config *ServerConfig) *duplicateFinder {
+	t.Helper()
+	f := &duplicateFinder{
+		store:   config.store,
+		minSize: 1,
+		hashes:  config.hashes,
+		trash:   config.trash,
+		usage:   config.usage,
+		paths:   config.paths,
+		trigger: make(chan struct{}, 1),
+	}
+	f.scan()
+	config.duplicates = f
+	return f
+}
+
+func TestDuplicateScan(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	for _, name := range []string{"a.txt", "b/a.txt", "b/c.txt"} {
+		writeTestFile(t, config, name, "same content")
+	}
+	writeTestFile(t, config, "other.txt", "other content")
+	writeTestFile(t, config, "empty1.txt", "")
+	writeTestFile(t, config, "empty2.txt", "")
+	writeTestFile(t, config, "linked.bin", "linked data")
+	if err := os.Link(filepath.Join(config.absShareDir, "linked.bin"), filepath.Join(config.absShareDir, "link.bin")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	// 回收站中的文件不参与检测
+	writeTestFile(t, config, "gone.txt", "same content")
+	serve(config, httptest.NewRequest(http.MethodDelete, "/delete/gone.txt", nil))
+
+	report := newTestDuplicateFinder(t, config).current()
+	if report.Running || report.Scanned != 6 {
+		t.Errorf("report: running = %v, scanned = %d, want 6", report.Running, report.Scanned)
+	}
+	// 空文件和互为硬链接的文件不算重复
+	if len(report.Groups) != 1 {
+		t.Fatalf("groups = %+v, want 1", report.Groups)
+	}
+	group := report.Groups[0]
+	paths := make([]string, 0, len(group.Files))
+	for _, file := range group.Files {
+		paths = append(paths, file.Path)
+	}
+	if got := strings.Join(paths, ","); got != "a.txt,b/a.txt,b/c.txt" {
+		t.Errorf("files = %s, want a.txt first", got)
+	}
+	if group.Reclaimable != 2*int64(len("same content")) || report.Reclaimable != group.Reclaimable {
+		t.Errorf("reclaimable = %d / %d", group.Reclaimable, report.Reclaimable)
+	}
+}
+
+func TestDuplicateApply(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	for _, name := range []string{"a.txt", "b/a.txt", "b/c.txt"} {
+		writeTestFile(t, config, name, "same content")
+	}
+	f := newTestDuplicateFinder(t, config)
+
+	// 整组都选中时不处理，避免删除最后一份
+	result := f.apply("delete", []string{"a.txt", "b/a.txt", "b/c.txt", "missing.txt"}, "tester", "en")
+	if len(result.Done) != 0 || len(result.Failed) != 4 {
+		t.Fatalf("result = %+v, want 4 failed", result)
+	}
+	for _, failure := range result.Failed {
+		want := "duplicate_last_copy"
+		if failure.Path == "missing.txt" {
+			want = "duplicate_not_found"
+		}
+		if failure.Error != want {
+			t.Errorf("%s: error = %s, want %s", failure.Path, failure.Error, want)
+		}
+	}
+
+	// 没有选中的 b/c.txt 作为保留的文件，删除的文件移到回收站
+	result = f.apply("delete", []string{"a.txt", "b/a.txt"}, "tester", "en")
+	if len(result.Done) != 2 || len(result.Failed) != 0 {
+		t.Fatalf("result = %+v, want 2 done", result)
+	}
+	for _, name := range []string{"a.txt", "b/a.txt"} {
+		if _, err := os.Stat(filepath.Join(config.absShareDir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s was not deleted: %v", name, err)
+		}
+	}
+	if items, _ := config.trash.list(); len(items) != 2 || items[0].Reason != "duplicate" {
+		t.Errorf("trash items = %+v", items)
+	}
+	// 结果中去掉删除的文件，只剩一份的组不再列出
+	if report := f.current(); len(report.Groups) != 0 {
+		t.Errorf("groups after delete = %+v", report.Groups)
+	}
+}
+
+func TestDuplicateApplyChanged(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "a.txt", "same content")
+	writeTestFile(t, config, "b.txt", "same content")
+	f := newTestDuplicateFinder(t, config)
+
+	// 检测之后保留的文件被修改，不再删除另一个
+	writeTestFile(t, config, "a.txt", "edited content")
+	result := f.apply("delete", []string{"b.txt"}, "tester", "en")
+	if len(result.Failed) != 1 || result.Failed[0].Error != "duplicate_changed" {
+		t.Fatalf("result = %+v, want duplicate_changed", result)
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "b.txt")); err != nil {
+		t.Errorf("b.txt was deleted: %v", err)
+	}
+	if report := f.current(); len(report.Groups) != 1 {
+		t.Errorf("groups = %+v, want the group kept", report.Groups)
+	}
+}
+
+func TestDuplicateApplyConcurrent(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "a.txt", "same content")
+	writeTestFile(t, config, "b.txt", "same content")
+	f := newTestDuplicateFinder(t, config)
+
+	// 两次处理各自选中组中的一个文件，只能有一次成功，不会把两份都删除
+	var wg sync.WaitGroup
+	results := make([]duplicateActionResult, 2)
+	for i, name := range []string{"a.txt", "b.txt"} {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = f.apply("delete", []string{name}, "tester", "en")
+		}(i, name)
+	}
+	wg.Wait()
+	if done := len(results[0].Done) + len(results[1].Done); done != 1 {
+		t.Errorf("results = %+v, want exactly one deletion", results)
+	}
+	remaining := 0
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(config.absShareDir, name)); err == nil {
+			remaining++
+		}
+	}
+	if remaining != 1 {
+		t.Errorf("%d copies left, want 1", remaining)
+	}
+}
+
+func TestDuplicateLink(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "a.txt", "same content")
+	writeTestFile(t, config, "b.txt", "same content")
+	f := newTestDuplicateFinder(t, config)
+
+	result := f.apply("link", []string{"b.txt"}, "tester", "en")
+	if len(result.Done) != 1 {
+		t.Fatalf("result = %+v, want 1 done", result)
+	}
+	a, _ := os.Stat(filepath.Join(config.absShareDir, "a.txt"))
+	b, _ := os.Stat(filepath.Join(config.absShareDir, "b.txt"))
+	if !os.SameFile(a, b) {
+		t.Error("b.txt is not a hard link to a.txt")
+	}
+	// 互为硬链接后不再占用额外空间
+	if report := f.current(); len(report.Groups) != 0 {
+		t.Errorf("groups after link = %+v", report.Groups)
+	}
+}
+
+func TestDuplicatesHandler(t *testing.T) {
+	settings := defaultSettings()
+	settings.AdminToken = "secret"
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "a.txt", "same content")
+	writeTestFile(t, config, "b.txt", "same content")
+	newTestDuplicateFinder(t, config)
+
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/duplicates/", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/duplicates/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Accept", "application/json")
+	w := serve(config, r)
+	var report duplicateReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || len(report.Groups) != 1 {
+		t.Fatalf("report = %s, %v", w.Body, err)
+	}
+
+	form := url.Values{"path": {"b.txt", "missing.txt"}}
+	r = httptest.NewRequest(http.MethodPost, "/duplicates/delete?token=secret", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = serve(config, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/duplicates/?token=secret&done=1&failed=1" {
+		t.Errorf("delete: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/duplicates/?token=secret&done=1&failed=1", nil)
+	r.Header.Set("Accept", "text/html")
+	if w := serve(config, r); w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("duplicates page: status = %d", w.Code)
+	}
+}
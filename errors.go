@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// 错误响应的JSON格式
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorConfigKey struct{}
+
+// 为请求附上服务器配置，错误页面需要用它渲染模板和查找上级目录
+func withErrorPages(config *ServerConfig, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), errorConfigKey{}, config)))
+	}
+}
+
+// 根据请求判断错误响应的格式：脚本和API客户端用JSON，浏览器用HTML页面，其余（如curl）用纯文本
+func errorFormat(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	switch {
+	case r.Header.Get("X-Requested-With") == "XMLHttpRequest":
+		return "json"
+	case strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html"):
+		return "json"
+	case strings.Contains(accept, "text/html"):
+		return "html"
+	}
+	return "text"
+}
+
+// 返回错误响应：本地化的消息加上稳定的错误码（X-Error-Code 头），
+// 不向客户端暴露内部错误信息，具体原因只记录在日志中
+func httpError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	message := translate(requestLanguage(r), "error."+code)
+	w.Header().Set("X-Error-Code", code)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	switch errorFormat(r) {
+	case "json":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(errorResponse{Status: status, Code: code, Message: message})
+		return
+	case "html":
+		if config, ok := r.Context().Value(errorConfigKey{}).(*ServerConfig); ok && renderErrorPage(w, r, config, status, code, message) {
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("%s (%s)", message, code), status)
+}
+
+// 渲染HTML错误页面，模板出错时返回false，由调用方退回纯文本
+func renderErrorPage(w http.ResponseWriter, r *http.Request, config *ServerConfig, status int, code, message string) bool {
+	backPath, backURL := nearestDirectory(config, r.URL.Path)
+	data := struct {
+		Lang     string
+		Theme    string
+		Status   int
+		Code     string
+		Message  string
+		BackPath string
+		BackURL  string
+		HomeURL  string
+	}{
+		Lang:     requestLanguage(r),
+		Theme:    requestTheme(r),
+		Status:   status,
+		Code:     code,
+		Message:  message,
+		BackPath: backPath,
+		BackURL:  backURL,
+		HomeURL:  config.basePath + "/",
+	}
+
+	var buf bytes.Buffer
+	if err := config.assets.templates.ExecuteTemplate(&buf, "error.html", data); err != nil {
+		log.Printf("错误页面模板执行错误: %v", err)
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+	return true
+}
+
+// 查找请求路径上最近的一个存在的上级目录，返回其显示路径和浏览URL
+func nearestDirectory(config *ServerConfig, urlPath string) (string, string) {
+	// 去掉路由前缀，得到共享目录中的相对路径
+	for _, prefix := range []string{"/download/", "/upload/", "/delete/", "/versions/", "/hash/", "/usage/"} {
+		if rest, ok := strings.CutPrefix(urlPath, prefix); ok {
+			urlPath = rest
+			break
+		}
+	}
+	cleaned, _, err := config.paths.validate(urlPath)
+	if err != nil {
+		return "/", config.basePath + "/"
+	}
+
+	// 从上一级开始向上查找，出错的目录本身不作为返回目标
+	for cleaned != "" && cleaned != "." {
+		cleaned = path.Dir(cleaned)
+		if cleaned == "." {
+			break
+		}
+		if info, err := config.store.Stat(cleaned); err == nil && info.IsDir() {
+			display := "/" + cleaned + "/"
+			return display, config.basePath + (&url.URL{Path: display}).EscapedPath()
+		}
+	}
+	return "/", config.basePath + "/"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorFormat(t *testing.T) {
+	tests := []struct {
+		name, accept, requestedWith, want string
+	}{
+		{"curl", "*/*", "", "text"},
+		{"no accept", "", "", "text"},
+		{"browser", "text/html,application/xhtml+xml,*/*;q=0.8", "", "html"},
+		{"api client", "application/json", "", "json"},
+		{"browser that also accepts JSON", "text/html, application/json", "", "html"},
+		{"script", "*/*", "XMLHttpRequest", "json"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", tt.accept)
+		if tt.requestedWith != "" {
+			r.Header.Set("X-Requested-With", tt.requestedWith)
+		}
+		if got := errorFormat(r); got != tt.want {
+			t.Errorf("%s: errorFormat = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPErrorText(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?lang=en", nil)
+	w := httptest.NewRecorder()
+	httpError(w, r, http.StatusTooManyRequests, "rate_limited")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-Error-Code") != "rate_limited" {
+		t.Errorf("status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+	if want := catalogs["en"]["error.rate_limited"] + " (rate_limited)"; strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("body = %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestHTTPErrorJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?lang=en", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	httpError(w, r, http.StatusNotFound, "file_not_found")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := errorResponse{Status: http.StatusNotFound, Code: "file_not_found", Message: catalogs["en"]["error.file_not_found"]}
+	if resp != want {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	settings := defaultSettings()
+	settings.BasePath = "/files"
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "docs/a b/report.txt", "quarterly")
+
+	// 浏览器得到HTML页面，返回链接指向最近的存在的上级目录
+	r := httptest.NewRequest(http.MethodGet, "/files/download/docs/a%20b/missing.txt", nil)
+	r.Header.Set("Accept", "text/html")
+	w := serve(config, r)
+	if w.Code != http.StatusNotFound || w.Header().Get("X-Error-Code") != "file_not_found" {
+		t.Fatalf("status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Content-Type = %q, want HTML", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), `href="/files/docs/a%20b/"`) {
+		t.Error("error page does not link back to the nearest directory")
+	}
+}
+
+func TestNearestDirectory(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "docs/report.txt", "quarterly")
+
+	tests := []struct {
+		urlPath, display, backURL string
+	}{
+		{"/download/docs/report.txt", "/docs/", "/docs/"},
+		{"/docs/missing/deeper/file", "/docs/", "/docs/"},
+		{"/missing/file", "/", "/"},
+		{"/upload/docs/", "/", "/"},
+		{"/../etc/passwd", "/", "/"},
+	}
+	for _, tt := range tests {
+		display, backURL := nearestDirectory(config, tt.urlPath)
+		if display != tt.display || backURL != tt.backURL {
+			t.Errorf("nearestDirectory(%q) = %q, %q, want %q, %q", tt.urlPath, display, backURL, tt.display, tt.backURL)
+		}
+	}
+}
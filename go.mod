@@ -1,4 +1,10 @@
 module go-fileserver
 
 go 1.21
+
 require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0 // indirect
+)
@@ -0,0 +1,951 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// 用给定的配置和存储创建服务器配置，各组件与 initConfig 中的组合方式相同
+func newTestConfig(t *testing.T, settings *Settings, store storage) *ServerConfig {
+	t.Helper()
+	if settings == nil {
+		settings = defaultSettings()
+	}
+	// 没有指定存储时使用临时目录中的本地存储
+	absShareDir := ""
+	if store == nil {
+		absShareDir = t.TempDir()
+		store = newLocalStorage(absShareDir)
+	} else if root, ok := storageLocalPath(store, "."); ok {
+		absShareDir = root
+	}
+	basePath := normalizeBasePath(settings.BasePath)
+	acl, err := newAccessControl(settings.Access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := loadWebAssets(settings.ThemeDir, basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var board *clipboard
+	if !settings.Clipboard.Disabled {
+		if board, err = newClipboard(settings.Clipboard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := newPathPolicy(settings.Files, store, absShareDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := newUsageTracker(store, paths, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota, err := newQuotaManager(settings.Quota, store, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newUploadFilter(settings.Upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ServerConfig{
+		absShareDir: absShareDir,
+		store:       store,
+		allIPs:      []IPAddress{{IP: "localhost", DisplayName: "localhost", BaseURL: "http://localhost:8080" + basePath}},
+		defaultIP:   "localhost",
+		basePath:    basePath,
+		assets:      assets,
+		settings:    settings,
+		limiter:     newRateLimiter(settings.Limits, settings.UserHeader, acl),
+		acl:         acl,
+		clipboard:   board,
+		usage:       usage,
+		quota:       quota,
+		filter:      filter,
+		hashes:      newHashCache(settings.Hash, store),
+		paths:       paths,
+	}
+
+	if config.watcher, err = newDirWatcher(settings.Watch, store, basePath, paths); err != nil {
+		t.Fatal(err)
+	}
+	if config.scanner, err = newUploadScanner(settings.Scan, store, usage); err != nil {
+		t.Fatal(err)
+	}
+	if config.trash, err = newTrashBin(settings.Trash, store, usage, paths); err != nil {
+		t.Fatal(err)
+	}
+	if config.versions, err = newVersionStore(settings.Versions, store, usage); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// 在共享目录中写入文件，自动创建上级目录
+func writeTestFile(t *testing.T, config *ServerConfig, name, content string) {
+	t.Helper()
+	writeStoreFile(t, config.store, name, content)
+}
+
+// 通过存储写入文件，自动创建上级目录
+func writeStoreFile(t *testing.T, store storage, name, content string) {
+	t.Helper()
+	dir := ""
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			break
+		}
+		dir = path.Join(dir, part)
+		if _, err := store.Stat(dir); err != nil {
+			if err := store.Mkdir(dir); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	f, err := store.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, content)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 生成上传请求，files 为文件名 -> 内容
+func newUploadRequest(t *testing.T, target string, files map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := form.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, content)
+	}
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+// 解析上传结果，并按文件名排列各文件的结果
+func decodeUploadResult(t *testing.T, w *httptest.ResponseRecorder) (uploadResult, map[string]uploadFileResult) {
+	t.Helper()
+	var result uploadResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding upload result: %v", err)
+	}
+	files := make(map[string]uploadFileResult)
+	for _, f := range result.Files {
+		files[f.Name] = f
+	}
+	return result, files
+}
+
+func serve(config *ServerConfig, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	setupRoutes(config).ServeHTTP(w, r)
+	return w
+}
+
+func TestBasePathRoutes(t *testing.T) {
+	settings := defaultSettings()
+	settings.BasePath = "/files/"
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "docs/report.txt", "quarterly")
+
+	// 访问前缀本身时补上末尾斜杠
+	w := serve(config, httptest.NewRequest(http.MethodGet, "/files", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/files/" {
+		t.Errorf("prefix: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// 前缀之外的路径不存在
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/docs/", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("path outside prefix: status = %d, want 404", w.Code)
+	}
+
+	// 目录列表中的链接带前缀
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/files/docs/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing: status = %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `/files/download/docs/report.txt`) {
+		t.Error("listing link does not include the base path")
+	}
+
+	// 文件请求重定向到带前缀的下载路由
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/files/docs/report.txt", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/files/download/docs/report.txt" {
+		t.Errorf("file: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/files/download/docs/report.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "quarterly" {
+		t.Errorf("download: status = %d, body = %q", w.Code, w.Body.String())
+	}
+}
+
+func TestForwardedAddressInListing(t *testing.T) {
+	settings := defaultSettings()
+	settings.Access.TrustedProxies = []string{"10.0.0.1"}
+	config := newTestConfig(t, settings, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-Host", "files.example.com")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w := serve(config, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "https://files.example.com/") {
+		t.Error("QR code URL does not use the forwarded address")
+	}
+}
+
+func TestListingThemeAndIcons(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "photo.png", "png")
+	writeTestFile(t, config, "docs/notes.txt", "notes")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: themeCookie, Value: "dark"})
+	w := serve(config, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{`data-theme="dark"`, "file file-image", "icons.svg?v=" + config.assets.version + "#folder"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing does not contain %s", want)
+		}
+	}
+}
+
+func TestUploadJSONResults(t *testing.T) {
+	settings := defaultSettings()
+	settings.BasePath = "/files"
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "docs/keep.txt", "keep")
+
+	r := newUploadRequest(t, "/files/upload/docs/", map[string]string{"a b.txt": "hello", "..": "bad"})
+	r.Header.Set("Accept-Language", "en")
+	w := serve(config, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	result, files := decodeUploadResult(t, w)
+	if result.Succeeded != 1 || result.Failed != 1 || result.Message == "" {
+		t.Errorf("result = %+v", result)
+	}
+	ok := files["a b.txt"]
+	if ok.Status != "ok" || ok.Size != 5 || ok.StoredName != "a b.txt" || ok.Path != "/files/download/docs/a%20b.txt" {
+		t.Errorf("ok file = %+v", ok)
+	}
+	bad := files[".."]
+	if bad.Status != "failed" || bad.Error != "invalid_file_name" || bad.Message != catalogs["en"]["error.invalid_file_name"] {
+		t.Errorf("failed file = %+v", bad)
+	}
+	if content, err := os.ReadFile(filepath.Join(config.absShareDir, "docs", "a b.txt")); err != nil || string(content) != "hello" {
+		t.Errorf("stored content = %q, %v", content, err)
+	}
+
+	// 全部失败时返回422
+	w = serve(config, newUploadRequest(t, "/files/upload/docs/", map[string]string{".": "bad"}))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("all failed: status = %d, want 422", w.Code)
+	}
+}
+
+func TestUploadRequestErrors(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "docs/keep.txt", "keep")
+
+	// GET 重定向到目录浏览页面
+	w := serve(config, httptest.NewRequest(http.MethodGet, "/upload/docs", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/docs/" {
+		t.Errorf("GET: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		status int
+		code   string
+	}{
+		{"no file", newUploadRequest(t, "/upload/docs/", nil), http.StatusBadRequest, "no_upload_file"},
+		{"missing target", newUploadRequest(t, "/upload/missing/", map[string]string{"a.txt": "a"}), http.StatusNotFound, "upload_target_not_found"},
+		{"target is a file", newUploadRequest(t, "/upload/docs/keep.txt", map[string]string{"a.txt": "a"}), http.StatusBadRequest, "upload_target_not_dir"},
+		{"not multipart", httptest.NewRequest(http.MethodPost, "/upload/docs/", strings.NewReader("x")), http.StatusBadRequest, "invalid_upload_form"},
+		{"wrong method", httptest.NewRequest(http.MethodPut, "/upload/docs/", nil), http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		w := serve(config, tt.r)
+		if w.Code != tt.status || w.Header().Get("X-Error-Code") != tt.code {
+			t.Errorf("%s: status = %d, X-Error-Code = %q, want %d %s", tt.name, w.Code, w.Header().Get("X-Error-Code"), tt.status, tt.code)
+		}
+	}
+}
+
+// 生成上传文件夹的请求，每个文件之前有一个 relpath 字段
+func newFolderUploadRequest(t *testing.T, target string, files [][2]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, f := range files {
+		relPath, content := f[0], f[1]
+		form.WriteField("relpath", relPath)
+		part, err := form.CreateFormFile("file", path.Base(strings.ReplaceAll(relPath, `\`, "/")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, content)
+	}
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func TestFolderUpload(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "docs/notdir", "file")
+
+	w := serve(config, newFolderUploadRequest(t, "/upload/docs/", [][2]string{
+		{"photos/2024/a.jpg", "a"},
+		{`photos\2024\b.jpg`, "b"},
+		{"photos/../../escape.txt", "x"},
+		{"notdir/c.txt", "c"},
+		{"photos//d.txt", "d"},
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	result, files := decodeUploadResult(t, w)
+	if result.Succeeded != 2 || result.Failed != 3 {
+		t.Errorf("succeeded %d, failed %d, want 2 and 3", result.Succeeded, result.Failed)
+	}
+	if f := files["photos/2024/a.jpg"]; f.Status != "ok" || f.Path != "/download/docs/photos/2024/a.jpg" {
+		t.Errorf("nested file = %+v", f)
+	}
+	if f := files[`photos\2024\b.jpg`]; f.Status != "ok" || f.StoredName != "photos/2024/b.jpg" {
+		t.Errorf("backslash path = %+v", f)
+	}
+	for _, name := range []string{"photos/../../escape.txt", "notdir/c.txt", "photos//d.txt"} {
+		if f := files[name]; f.Status != "failed" || f.Error != "invalid_file_name" {
+			t.Errorf("%s = %+v, want invalid_file_name", name, f)
+		}
+	}
+	for _, name := range []string{"docs/photos/2024/a.jpg", "docs/photos/2024/b.jpg"} {
+		if _, err := os.Stat(filepath.Join(config.absShareDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s was not stored: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(config.absShareDir), "escape.txt")); err == nil {
+		t.Error("file was written outside the share directory")
+	}
+}
+
+func TestUploadSizeLimits(t *testing.T) {
+	settings := defaultSettings()
+	settings.Upload.MaxFileSize = 4
+	settings.Upload.MaxRequestSize = 4096
+	config := newTestConfig(t, settings, nil)
+
+	// 超过单个文件上限的文件失败并被删除，其余文件照常保存
+	w := serve(config, newUploadRequest(t, "/upload/", map[string]string{"small.txt": "1234", "big.txt": "12345"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	_, files := decodeUploadResult(t, w)
+	if files["small.txt"].Status != "ok" || files["big.txt"].Error != "file_too_large" {
+		t.Errorf("results = %+v", files)
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "big.txt")); !os.IsNotExist(err) {
+		t.Error("oversized file was kept")
+	}
+
+	// 声明的大小超过请求上限时直接拒绝
+	r := newUploadRequest(t, "/upload/", map[string]string{"a.txt": strings.Repeat("a", 5000)})
+	w = serve(config, r)
+	if w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("X-Error-Code") != "request_too_large" {
+		t.Errorf("declared size: status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+
+	// 未声明大小时在读取中途发现超出上限
+	config.settings.Upload.MaxFileSize = 0
+	r = newUploadRequest(t, "/upload/", map[string]string{"a.txt": strings.Repeat("a", 5000)})
+	r.ContentLength = -1
+	w = serve(config, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("streamed size: status = %d, want 413", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "a.txt")); !os.IsNotExist(err) {
+		t.Error("partially written file was kept")
+	}
+}
+
+func TestUploadQuota(t *testing.T) {
+	settings := defaultSettings()
+	settings.UserHeader = "X-Remote-User"
+	settings.Access.TrustedProxies = []string{"192.0.2.1"} // httptest 请求的来源地址
+	settings.Quota = QuotaSettings{PerUser: 10, Directories: []DirQuota{{Path: "/small", Limit: 4}}}
+	writeSmall := func(config *ServerConfig) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(config.absShareDir, "small"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := newTestConfig(t, settings, nil)
+	writeSmall(config)
+
+	// 声明的大小已经超出额度时直接拒绝
+	r := newUploadRequest(t, "/upload/small/", map[string]string{"a.txt": "12345"})
+	r.Header.Set("X-Remote-User", "alice")
+	if w := serve(config, r); w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("X-Error-Code") != "dir_quota_exceeded" {
+		t.Errorf("declared size: status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+
+	// 其余的请求不声明大小，按实际写入的字节数检查
+	upload := func(target, user string, files map[string]string) *httptest.ResponseRecorder {
+		r := newUploadRequest(t, target, files)
+		r.Header.Set("X-Remote-User", user)
+		r.ContentLength = -1
+		return serve(config, r)
+	}
+
+	// 目录配额：超出的文件失败并被删除，返回413
+	w := upload("/upload/small/", "alice", map[string]string{"a.txt": "12345"})
+	if _, files := decodeUploadResult(t, w); w.Code != http.StatusRequestEntityTooLarge || files["a.txt"].Error != "dir_quota_exceeded" {
+		t.Errorf("dir quota: status = %d, files = %+v", w.Code, files)
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "small", "a.txt")); !os.IsNotExist(err) {
+		t.Error("file beyond the directory quota was kept")
+	}
+
+	// 用户配额按上传者分别计算
+	if w := upload("/upload/", "alice", map[string]string{"a.txt": "12345678"}); w.Code != http.StatusOK {
+		t.Fatalf("within quota: status = %d, body = %s", w.Code, w.Body)
+	}
+	if got := config.usage.userUsage("user:alice"); got != 8 {
+		t.Errorf("alice usage = %d, want 8", got)
+	}
+	w = upload("/upload/", "alice", map[string]string{"b.txt": "123"})
+	_, files := decodeUploadResult(t, w)
+	if w.Code != http.StatusRequestEntityTooLarge || files["b.txt"].Error != "user_quota_exceeded" {
+		t.Errorf("user quota: status = %d, files = %+v", w.Code, files)
+	}
+	if w := upload("/upload/", "bob", map[string]string{"b.txt": "123"}); w.Code != http.StatusOK {
+		t.Errorf("other user: status = %d", w.Code)
+	}
+
+	// 覆盖自己的文件时按新的大小计算
+	if w := upload("/upload/", "alice", map[string]string{"a.txt": "12"}); w.Code != http.StatusOK {
+		t.Errorf("overwrite: status = %d, body = %s", w.Code, w.Body)
+	}
+	if got := config.usage.userUsage("user:alice"); got != 2 {
+		t.Errorf("alice usage after overwrite = %d, want 2", got)
+	}
+}
+
+func TestUploadFilterRejects(t *testing.T) {
+	settings := defaultSettings()
+	settings.Upload.DenyExtensions = []string{"exe"}
+	settings.Upload.DenyTypes = []string{"application/x-executable"}
+	settings.Upload.MaxNameLength = 10
+	config := newTestConfig(t, settings, nil)
+
+	r := newUploadRequest(t, "/upload/", map[string]string{
+		"ok.txt":            "plain text",
+		"setup.EXE.":        "MZ",
+		"prog.bin":          "\x7fELF binary",
+		"much-too-long.txt": "x",
+	})
+	r.Header.Set("Accept-Language", "en")
+	w := serve(config, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	_, files := decodeUploadResult(t, w)
+	want := map[string]string{
+		"ok.txt":            "",
+		"setup.EXE.":        "file_type_not_allowed",
+		"prog.bin":          "content_type_not_allowed",
+		"much-too-long.txt": "file_name_too_long",
+	}
+	for name, code := range want {
+		if files[name].Error != code {
+			t.Errorf("%s: error = %q, want %q", name, files[name].Error, code)
+		}
+		if code != "" {
+			if _, err := os.Stat(filepath.Join(config.absShareDir, name)); !os.IsNotExist(err) {
+				t.Errorf("%s: rejected file was stored", name)
+			}
+		}
+	}
+	if msg := files["setup.EXE."].Message; !strings.Contains(msg, ".exe") {
+		t.Errorf("message %q does not name the extension", msg)
+	}
+	if content, _ := os.ReadFile(filepath.Join(config.absShareDir, "ok.txt")); string(content) != "plain text" {
+		t.Errorf("sniffed file content = %q, want it written in full", content)
+	}
+}
+
+func TestDeleteMovesToTrash(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "docs/a.txt", "a")
+
+	w := serve(config, httptest.NewRequest(http.MethodDelete, "/delete/docs/a.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d, body = %s", w.Code, w.Body)
+	}
+	var result deleteResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.Path != "docs/a.txt" || result.TrashID == "" {
+		t.Fatalf("delete result = %+v, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "docs", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("file is still in place: %v", err)
+	}
+
+	// 回收站目录不能浏览、下载或删除
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/.trash/", nil),
+		httptest.NewRequest(http.MethodGet, "/download/.trash/"+result.TrashID, nil),
+		httptest.NewRequest(http.MethodDelete, "/delete/.trash", nil),
+	} {
+		if w := serve(config, r); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want 403", r.Method, r.URL.Path, w.Code)
+		}
+	}
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/", nil)); strings.Contains(w.Body.String(), ".trash") {
+		t.Error("listing shows the trash directory")
+	}
+}
+
+func TestDeleteErrors(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodDelete, "/delete/missing.txt", http.StatusNotFound, "file_not_found"},
+		{http.MethodDelete, "/delete/", http.StatusForbidden, "forbidden_path"},
+		{http.MethodGet, "/delete/a.txt", http.StatusMethodNotAllowed, "unsupported_method"},
+	}
+	for _, tt := range tests {
+		w := serve(config, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status || w.Header().Get("X-Error-Code") != tt.code {
+			t.Errorf("%s %s: status = %d, X-Error-Code = %q, want %d %s", tt.method, tt.path, w.Code, w.Header().Get("X-Error-Code"), tt.status, tt.code)
+		}
+	}
+}
+
+func TestDeleteWithoutTrash(t *testing.T) {
+	settings := defaultSettings()
+	settings.Trash.Disabled = true
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "a.txt", "a")
+
+	w := serve(config, httptest.NewRequest(http.MethodPost, "/delete/a.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d, body = %s", w.Code, w.Body)
+	}
+	var result deleteResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.TrashID != "" {
+		t.Errorf("delete result = %+v, %v, want no trash ID", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("file was not deleted: %v", err)
+	}
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/trash/", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("trash page without trash: status = %d, want 404", w.Code)
+	}
+
+	// 没有回收站时只能删除空目录
+	writeTestFile(t, config, "dir/b.txt", "b")
+	w = serve(config, httptest.NewRequest(http.MethodDelete, "/delete/dir", nil))
+	if w.Code != http.StatusConflict || w.Header().Get("X-Error-Code") != "directory_not_empty" {
+		t.Errorf("non-empty directory: status = %d, X-Error-Code = %q, want 409 directory_not_empty", w.Code, w.Header().Get("X-Error-Code"))
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "dir", "b.txt")); err != nil {
+		t.Errorf("directory content was deleted: %v", err)
+	}
+	os.Remove(filepath.Join(config.absShareDir, "dir", "b.txt"))
+	if w := serve(config, httptest.NewRequest(http.MethodDelete, "/delete/dir", nil)); w.Code != http.StatusOK {
+		t.Errorf("empty directory: status = %d, want 200", w.Code)
+	}
+}
+
+func TestDeleteWhileScanning(t *testing.T) {
+	settings := defaultSettings()
+	settings.Scan = ScanSettings{Mode: "command", Command: []string{"true"}, QuarantineDir: filepath.Join(t.TempDir(), "q")}
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "docs/new.txt", "new")
+
+	// 直接登记为等待扫描，不放入队列
+	config.scanner.mu.Lock()
+	config.scanner.pending["docs/new.txt"] = &scanJob{path: "docs/new.txt", done: make(chan struct{})}
+	config.scanner.mu.Unlock()
+
+	for _, target := range []string{"/delete/docs/new.txt", "/delete/docs"} {
+		w := serve(config, httptest.NewRequest(http.MethodDelete, target, nil))
+		if w.Code != http.StatusConflict || w.Header().Get("X-Error-Code") != "scan_in_progress" {
+			t.Errorf("DELETE %s: status = %d, X-Error-Code = %q, want 409 scan_in_progress", target, w.Code, w.Header().Get("X-Error-Code"))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "docs", "new.txt")); err != nil {
+		t.Errorf("file was deleted during the scan: %v", err)
+	}
+}
+
+func TestTrashHandler(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "a.txt", "a")
+	writeTestFile(t, config, "b.txt", "b")
+	serve(config, httptest.NewRequest(http.MethodDelete, "/delete/a.txt", nil))
+	serve(config, httptest.NewRequest(http.MethodDelete, "/delete/b.txt", nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/trash/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := serve(config, r)
+	var items []trashItem
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil || len(items) != 2 {
+		t.Fatalf("trash list = %s, %v", w.Body, err)
+	}
+	ids := map[string]string{}
+	for _, item := range items {
+		ids[item.Path] = item.ID
+	}
+
+	// 恢复后文件回到原处
+	r = httptest.NewRequest(http.MethodPost, "/trash/"+ids["a.txt"]+"/restore", nil)
+	r.Header.Set("Accept", "application/json")
+	w = serve(config, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"path":"a.txt"`) {
+		t.Errorf("restore: status = %d, body = %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "a.txt")); err != nil {
+		t.Errorf("restored file is missing: %v", err)
+	}
+
+	// 页面提交的表单完成后返回回收站页面
+	w = serve(config, httptest.NewRequest(http.MethodPost, "/trash/"+ids["b.txt"]+"/purge", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/trash/" {
+		t.Errorf("purge: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+	w = serve(config, httptest.NewRequest(http.MethodPost, "/trash/"+ids["b.txt"]+"/restore", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("X-Error-Code") != "trash_not_found" {
+		t.Errorf("restore purged item: status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/trash/", nil)
+	r.Header.Set("Accept", "text/html")
+	if w := serve(config, r); w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("trash page: status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestTrashAdminToken(t *testing.T) {
+	settings := defaultSettings()
+	settings.AdminToken = "secret"
+	config := newTestConfig(t, settings, nil)
+
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/trash/", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/trash/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if w := serve(config, r); w.Code != http.StatusOK {
+		t.Errorf("with bearer token: status = %d, want 200", w.Code)
+	}
+	w := serve(config, httptest.NewRequest(http.MethodPost, "/trash/purge?token=secret", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/trash/?token=secret" {
+		t.Errorf("purge with query token: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestUploadOverwriteMovesToTrash(t *testing.T) {
+	settings := defaultSettings()
+	settings.Versions.Disabled = true
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "a.txt", "old")
+
+	w := serve(config, newUploadRequest(t, "/upload/", map[string]string{"a.txt": "new"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, body = %s", w.Code, w.Body)
+	}
+	items, err := config.trash.list()
+	if err != nil || len(items) != 1 || items[0].Path != "a.txt" || items[0].Reason != "overwrite" {
+		t.Fatalf("trash items = %+v, %v", items, err)
+	}
+	restored, err := config.trash.restore(items[0].ID)
+	if err != nil || restored != "a (1).txt" {
+		t.Fatalf("restore = %q, %v", restored, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(config.absShareDir, "a (1).txt")); string(data) != "old" {
+		t.Errorf("restored content = %q, want old", data)
+	}
+}
+
+func TestUploadChecksum(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	sum := sha256.Sum256([]byte("hello"))
+	good := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name, checksum, status, code string
+	}{
+		{"good.txt", good, "ok", ""},
+		{"bare.txt", hex.EncodeToString(sum[:]), "ok", ""},
+		{"bad.txt", "sha256:" + strings.Repeat("0", 64), "failed", "checksum_mismatch"},
+		{"algo.txt", "crc32:00000000", "failed", "unsupported_hash_algo"},
+		{"short.txt", "1234", "failed", "invalid_checksum"},
+	}
+	for _, tt := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("checksum", tt.checksum)
+		part, _ := mw.CreateFormFile("file", tt.name)
+		io.WriteString(part, "hello")
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/upload/", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r.Header.Set("Accept", "application/json")
+
+		_, files := decodeUploadResult(t, serve(config, r))
+		f := files[tt.name]
+		if f.Status != tt.status || f.Error != tt.code {
+			t.Errorf("%s: result = %+v, want %s %s", tt.name, f, tt.status, tt.code)
+			continue
+		}
+		_, err := os.Stat(filepath.Join(config.absShareDir, tt.name))
+		if tt.status == "ok" && (err != nil || f.Checksum != good) {
+			t.Errorf("%s: checksum = %q, stat error = %v", tt.name, f.Checksum, err)
+		}
+		if tt.status == "failed" && !os.IsNotExist(err) {
+			t.Errorf("%s: rejected file was kept: %v", tt.name, err)
+		}
+	}
+
+	// 校验通过的校验和直接进入缓存
+	info, _ := config.store.Stat("good.txt")
+	if cached, ok := config.hashes.cached("good.txt", info, "sha256"); !ok || !bytes.Equal(cached, sum[:]) {
+		t.Errorf("verified checksum was not cached: %x, %v", cached, ok)
+	}
+}
+
+func TestMemStorageList(t *testing.T) {
+	store := newMemStorage()
+	store.Mkdir("docs")
+	writeStoreFile(t, store, "docs/report.txt", "quarterly")
+	writeStoreFile(t, store, "docs/.secret", "hidden")
+	config := newTestConfig(t, nil, store)
+
+	r := httptest.NewRequest(http.MethodGet, "/docs/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := serve(config, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "report.txt") {
+		t.Error("listing does not contain report.txt")
+	}
+	if strings.Contains(body, ".secret") {
+		t.Error("listing contains hidden file .secret")
+	}
+
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/missing/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing directory: status = %d, want 404", w.Code)
+	}
+}
+
+func TestMemStorageDownload(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "hello.txt", "hello, world")
+	config := newTestConfig(t, nil, store)
+
+	w := serve(config, httptest.NewRequest(http.MethodGet, "/download/hello.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := w.Body.String(); got != "hello, world" {
+		t.Errorf("body = %q, want %q", got, "hello, world")
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/download/missing.txt", http.StatusNotFound},
+		{"/download/.hidden", http.StatusForbidden},
+		{"/download/sub", http.StatusBadRequest},
+	}
+	writeStoreFile(t, store, ".hidden", "x")
+	store.Mkdir("sub")
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = tt.path
+		if w := serve(config, r); w.Code != tt.want {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
+
+func TestMemStorageUpload(t *testing.T) {
+	store := newMemStorage()
+	store.Mkdir("in")
+	writeStoreFile(t, store, "in/old.txt", "old content")
+	config := newTestConfig(t, nil, store)
+
+	w := serve(config, newUploadRequest(t, "/upload/in/", map[string]string{"new.txt": "new content"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var result uploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Succeeded != 1 || result.Failed != 0 {
+		t.Fatalf("succeeded = %d, failed = %d, want 1, 0", result.Succeeded, result.Failed)
+	}
+	if data, err := fs.ReadFile(store, "in/new.txt"); err != nil || string(data) != "new content" {
+		t.Errorf("in/new.txt = %q, %v", data, err)
+	}
+
+	// 覆盖已有文件
+	w = serve(config, newUploadRequest(t, "/upload/in/", map[string]string{"old.txt": "replaced"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("overwrite: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if data, err := fs.ReadFile(store, "in/old.txt"); err != nil || string(data) != "replaced" {
+		t.Errorf("in/old.txt = %q, %v", data, err)
+	}
+
+	w = serve(config, newUploadRequest(t, "/upload/missing/", map[string]string{"a.txt": "a"}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing target: status = %d, want 404", w.Code)
+	}
+}
+
+func TestMemStorageDelete(t *testing.T) {
+	store := newMemStorage()
+	store.Mkdir("dir")
+	writeStoreFile(t, store, "dir/a.txt", "a")
+	writeStoreFile(t, store, "b.txt", "b")
+	config := newTestConfig(t, nil, store)
+
+	for _, name := range []string{"b.txt", "dir"} {
+		w := serve(config, httptest.NewRequest(http.MethodDelete, "/delete/"+name, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("DELETE %s: status = %d, want 200", name, w.Code)
+		}
+		if _, err := store.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s still exists after delete: %v", name, err)
+		}
+	}
+	if _, err := store.Stat("dir/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("dir/a.txt still exists after deleting dir: %v", err)
+	}
+
+	w := serve(config, httptest.NewRequest(http.MethodDelete, "/delete/missing.txt", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing file: status = %d, want 404", w.Code)
+	}
+}
+
+func TestReadOnlyStorage(t *testing.T) {
+	store := newFSStorage(fstest.MapFS{"a.txt": {Data: []byte("a")}})
+	config := newTestConfig(t, nil, store)
+
+	if w := serve(config, httptest.NewRequest(http.MethodDelete, "/delete/a.txt", nil)); w.Code != http.StatusForbidden {
+		t.Errorf("delete: status = %d, want 403", w.Code)
+	}
+	if w := serve(config, newUploadRequest(t, "/upload/", map[string]string{"b.txt": "b"})); w.Code != http.StatusForbidden {
+		t.Errorf("upload: status = %d, want 403", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := serve(config, r)
+	if strings.Contains(w.Body.String(), `id="uploadForm"`) {
+		t.Error("page shows the upload form on read-only storage")
+	}
+}
+
+func TestMemStorageTrash(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "a.txt", "a")
+	settings := defaultSettings()
+	settings.Versions.Disabled = true
+	settings.Duplicates.Disabled = true
+	config := newTestConfig(t, settings, store)
+
+	w := serve(config, httptest.NewRequest(http.MethodDelete, "/delete/a.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var result deleteResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.TrashID == "" {
+		t.Fatalf("delete result = %+v, %v", result, err)
+	}
+	restored, err := config.trash.restore(result.TrashID)
+	if err != nil || restored != "a.txt" {
+		t.Fatalf("restore = %q, %v", restored, err)
+	}
+	if data, err := fs.ReadFile(store, "a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+}
+
+func TestUploadFailureKeepsOriginal(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "a.txt", "original")
+	config := newTestConfig(t, nil, store)
+
+	// 校验和不匹配时不保存，原来的文件保持不变，也不留下临时文件
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("checksum", "sha256:"+strings.Repeat("0", 64))
+	part, _ := form.CreateFormFile("file", "a.txt")
+	io.WriteString(part, "replacement")
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/upload/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	w := serve(config, r)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422: %s", w.Code, w.Body)
+	}
+	if data, err := fs.ReadFile(store, "a.txt"); err != nil || string(data) != "original" {
+		t.Errorf("a.txt = %q, %v, want original content", data, err)
+	}
+	entries, _ := store.ReadDir(".")
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), uploadTempPrefix) {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
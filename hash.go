@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// 校验和算法
+type hashAlgorithm struct {
+	new        func() hash.Hash
+	digestName string // Digest 头（RFC 3230）中的名称
+	reprName   string // Repr-Digest 头（RFC 9530）中的名称，为空表示不在其中使用
+}
+
+// 支持的校验和算法，名称用于 ?algo= 和上传时的 checksum 字段
+var hashAlgorithms = map[string]hashAlgorithm{
+	"sha256":  {new: sha256.New, digestName: "SHA-256", reprName: "sha-256"},
+	"sha1":    {new: sha1.New, digestName: "SHA"},
+	"md5":     {new: md5.New, digestName: "MD5"},
+	"blake2b": {new: newBlake2b},
+}
+
+// Digest 头中按顺序列出的算法
+var digestAlgorithms = []string{"sha256", "sha1", "md5"}
+
+// BLAKE2b-512，不带密钥时不会出错
+func newBlake2b() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// 校验和相关的错误
+var (
+	errUnsupportedHash = errors.New("unsupported_hash_algo")
+	errInvalidChecksum = errors.New("invalid_checksum")
+)
+
+// 解析上传时提交的校验和，格式为 "<算法>:<十六进制>"（如 sha256:ab12...），
+// 省略算法时按长度判断：64位为sha256，40位为sha1，32位为md5，128位为blake2b
+func parseChecksum(value string) (string, []byte, error) {
+	value = strings.TrimSpace(value)
+	algo, digest, ok := strings.Cut(value, ":")
+	if ok {
+		algo = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(algo)), "-", "")
+	} else {
+		digest = value
+		switch len(value) {
+		case 64:
+			algo = "sha256"
+		case 40:
+			algo = "sha1"
+		case 32:
+			algo = "md5"
+		case 128:
+			algo = "blake2b"
+		default:
+			return "", nil, errInvalidChecksum
+		}
+	}
+	alg, ok := hashAlgorithms[algo]
+	if !ok {
+		return "", nil, errUnsupportedHash
+	}
+	sum, err := hex.DecodeString(strings.TrimSpace(digest))
+	if err != nil || len(sum) != alg.new().Size() {
+		return "", nil, errInvalidChecksum
+	}
+	return algo, sum, nil
+}
+
+// 一个文件已计算的校验和，文件的大小或修改时间变化后失效
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	sums    map[string][]byte
+	used    time.Time
+}
+
+// 正在进行的计算，同一文件的同一算法只计算一次
+type hashCall struct {
+	done chan struct{}
+	sum  []byte
+	err  error
+}
+
+// 校验和缓存 - 按存储中的名称、修改时间和大小缓存计算结果
+type hashCache struct {
+	files    storage // 计算校验和时读取文件
+	mu       sync.Mutex
+	entries  map[string]*hashEntry
+	inflight map[string]*hashCall
+	max      int
+}
+
+func newHashCache(settings HashSettings, store storage) *hashCache {
+	return &hashCache{
+		files:    store,
+		entries:  make(map[string]*hashEntry),
+		inflight: make(map[string]*hashCall),
+		max:      settings.CacheEntries,
+	}
+}
+
+// 查找缓存的校验和
+func (c *hashCache) cached(name string, info fs.FileInfo, algo string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	sum, ok := entry.sums[algo]
+	if ok {
+		entry.used = time.Now()
+	}
+	return sum, ok
+}
+
+// 缓存一个校验和，超出数量时淘汰最久未使用的文件
+func (c *hashCache) store(name string, info fs.FileInfo, algo string, sum []byte) {
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		if !ok && len(c.entries) >= c.max {
+			var oldest string
+			for p, e := range c.entries {
+				if oldest == "" || e.used.Before(c.entries[oldest].used) {
+					oldest = p
+				}
+			}
+			delete(c.entries, oldest)
+		}
+		entry = &hashEntry{size: info.Size(), modTime: info.ModTime(), sums: make(map[string][]byte)}
+		c.entries[name] = entry
+	}
+	entry.sums[algo] = sum
+	entry.used = time.Now()
+}
+
+// 计算文件的校验和，优先使用缓存
+func (c *hashCache) sum(name string, info fs.FileInfo, algo string) ([]byte, error) {
+	if sum, ok := c.cached(name, info, algo); ok {
+		return sum, nil
+	}
+
+	key := name + "\x00" + algo
+	c.mu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.sum, call.err
+	}
+	call := &hashCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	call.sum, call.err = hashFile(c.files, name, algo)
+	// 计算期间文件被修改时不缓存结果
+	if call.err == nil {
+		if after, err := c.files.Stat(name); err == nil && after.Size() == info.Size() && after.ModTime().Equal(info.ModTime()) {
+			c.store(name, info, algo, call.sum)
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(call.done)
+	return call.sum, call.err
+}
+
+// 读取整个文件计算校验和
+func hashFile(fsys fs.FS, name, algo string) ([]byte, error) {
+	alg, ok := hashAlgorithms[algo]
+	if !ok {
+		return nil, errUnsupportedHash
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := alg.new()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// 设置下载响应的 Repr-Digest 和 Digest 头：只使用已缓存的校验和，不让下载等待计算；
+// 没有缓存时不超过 maxSize 的文件在后台计算 SHA-256，之后的下载再带上
+func (c *hashCache) setDigestHeaders(w http.ResponseWriter, name string, info fs.FileInfo, maxSize int64) {
+	if _, ok := c.cached(name, info, "sha256"); !ok && info.Size() <= maxSize {
+		go c.sum(name, info, "sha256")
+	}
+
+	var digest, repr []string
+	for _, algo := range digestAlgorithms {
+		sum, ok := c.cached(name, info, algo)
+		if !ok {
+			continue
+		}
+		alg := hashAlgorithms[algo]
+		encoded := base64.StdEncoding.EncodeToString(sum)
+		digest = append(digest, alg.digestName+"="+encoded)
+		if alg.reprName != "" {
+			repr = append(repr, alg.reprName+"=:"+encoded+":")
+		}
+	}
+	if len(repr) > 0 {
+		w.Header().Set("Repr-Digest", strings.Join(repr, ", "))
+	}
+	if len(digest) > 0 {
+		w.Header().Set("Digest", strings.Join(digest, ","))
+	}
+}
+
+// 校验和接口的返回结果
+type hashResult struct {
+	Path string `json:"path"`
+	Algo string `json:"algo"`
+	Hash string `json:"hash"` // 十六进制
+	Size int64  `json:"size"`
+}
+
+// 处理校验和请求：GET /hash/<文件>?algo=sha256|sha1|md5|blake2b（默认sha256），
+// 以JSON方式请求时返回JSON，否则返回与 sha256sum 等工具相同格式的文本
+func handleFileHash(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	urlRelativePath := strings.TrimPrefix(r.URL.Path, "/hash/")
+	cleaned, name, err := config.paths.validate(urlRelativePath)
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "forbidden_path")
+		return
+	}
+
+	algo := strings.ToLower(r.URL.Query().Get("algo"))
+	if algo == "" {
+		algo = "sha256"
+	}
+	if _, ok := hashAlgorithms[algo]; !ok {
+		httpError(w, r, http.StatusBadRequest, "unsupported_hash_algo")
+		return
+	}
+
+	info, err := config.store.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			httpError(w, r, http.StatusNotFound, "file_not_found")
+		} else {
+			httpError(w, r, http.StatusInternalServerError, "internal")
+		}
+		return
+	}
+	if info.IsDir() {
+		httpError(w, r, http.StatusBadRequest, "cannot_hash_dir")
+		return
+	}
+
+	// 需要读取整个文件时和下载共用并发数限制
+	sum, ok := config.hashes.cached(name, info, algo)
+	if !ok {
+		release, ok := config.limiter.acquireDownload()
+		if !ok {
+			tooManyRequests(w, r, 5*time.Second, "too_many_downloads")
+			return
+		}
+		defer release()
+		sum, err = config.hashes.sum(name, info, algo)
+		if err != nil {
+			httpError(w, r, http.StatusInternalServerError, "open_failed")
+			return
+		}
+	}
+
+	result := hashResult{Path: cleaned, Algo: algo, Hash: hex.EncodeToString(sum), Size: info.Size()}
+	if errorFormat(r) == "json" {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s  %s\n", result.Hash, path.Base(cleaned))
+}
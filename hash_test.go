@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sha := strings.Repeat("ab", 32)
+	tests := []struct {
+		value    string
+		wantAlgo string
+		wantErr  error
+	}{
+		{"sha256:" + sha, "sha256", nil},
+		{" SHA-256 : " + sha + " ", "sha256", nil},
+		{sha, "sha256", nil},
+		{strings.Repeat("0", 40), "sha1", nil},
+		{strings.Repeat("0", 32), "md5", nil},
+		{strings.Repeat("0", 128), "blake2b", nil},
+		{"blake2b:" + strings.Repeat("0", 128), "blake2b", nil},
+		{"crc32:00000000", "", errUnsupportedHash},
+		{"sha256:" + strings.Repeat("0", 40), "", errInvalidChecksum},
+		{"sha256:" + strings.Repeat("zz", 32), "", errInvalidChecksum},
+		{"1234", "", errInvalidChecksum},
+	}
+	for _, tt := range tests {
+		algo, sum, err := parseChecksum(tt.value)
+		if err != tt.wantErr {
+			t.Errorf("parseChecksum(%q) error = %v, want %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (algo != tt.wantAlgo || len(sum) != hashAlgorithms[algo].new().Size()) {
+			t.Errorf("parseChecksum(%q) = %s, %x", tt.value, algo, sum)
+		}
+	}
+}
+
+// 记录打开文件次数的存储，用于确认缓存命中时不再读取文件
+type countingStorage struct {
+	storage
+	opens atomic.Int32
+}
+
+func (s *countingStorage) Open(name string) (fs.File, error) {
+	s.opens.Add(1)
+	return s.storage.Open(name)
+}
+
+func TestHashCache(t *testing.T) {
+	mem := newMemStorage()
+	writeStoreFile(t, mem, "a.txt", "hello")
+	store := &countingStorage{storage: mem}
+	cache := newHashCache(HashSettings{CacheEntries: 2}, store)
+
+	info, _ := store.Stat("a.txt")
+	want := sha256.Sum256([]byte("hello"))
+	for i := 0; i < 3; i++ {
+		sum, err := cache.sum("a.txt", info, "sha256")
+		if err != nil || hex.EncodeToString(sum) != hex.EncodeToString(want[:]) {
+			t.Fatalf("sum = %x, %v, want %x", sum, err, want)
+		}
+	}
+	if n := store.opens.Load(); n != 1 {
+		t.Errorf("file opened %d times, want 1", n)
+	}
+
+	// 每种算法分别缓存
+	if _, err := cache.sum("a.txt", info, "md5"); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.opens.Load(); n != 2 {
+		t.Errorf("file opened %d times after md5, want 2", n)
+	}
+	if _, err := cache.sum("a.txt", info, "crc32"); err != errUnsupportedHash {
+		t.Errorf("unsupported algorithm: err = %v", err)
+	}
+
+	// 文件被修改后缓存失效
+	writeStoreFile(t, mem, "a.txt", "hello, world")
+	info, _ = store.Stat("a.txt")
+	if _, ok := cache.cached("a.txt", info, "sha256"); ok {
+		t.Error("cache hit after the file changed")
+	}
+	sum, _ := cache.sum("a.txt", info, "sha256")
+	if want := sha256.Sum256([]byte("hello, world")); hex.EncodeToString(sum) != hex.EncodeToString(want[:]) {
+		t.Errorf("sum after change = %x, want %x", sum, want)
+	}
+
+	// 超出数量时淘汰最久未使用的文件
+	writeStoreFile(t, mem, "b.txt", "b")
+	writeStoreFile(t, mem, "c.txt", "c")
+	for _, name := range []string{"b.txt", "c.txt"} {
+		info, _ := store.Stat(name)
+		time.Sleep(time.Millisecond)
+		cache.sum(name, info, "sha256")
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("entries = %d, want 2", len(cache.entries))
+	}
+	if _, ok := cache.entries["a.txt"]; ok {
+		t.Error("least recently used entry was not evicted")
+	}
+
+	// 缓存数量为0时不缓存
+	off := newHashCache(HashSettings{}, store)
+	off.sum("b.txt", info, "sha256")
+	if len(off.entries) != 0 {
+		t.Errorf("disabled cache stored %d entries", len(off.entries))
+	}
+}
+
+func TestHashCacheConcurrent(t *testing.T) {
+	mem := newMemStorage()
+	writeStoreFile(t, mem, "big.bin", strings.Repeat("x", 1<<20))
+	store := &countingStorage{storage: mem}
+	cache := newHashCache(HashSettings{CacheEntries: 10}, store)
+	info, _ := store.Stat("big.bin")
+
+	// 同时请求同一文件的同一算法，结果相同
+	var wg sync.WaitGroup
+	sums := make([]string, 8)
+	for i := range sums {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sum, err := cache.sum("big.bin", info, "sha256")
+			if err != nil {
+				t.Error(err)
+			}
+			sums[i] = hex.EncodeToString(sum)
+		}(i)
+	}
+	wg.Wait()
+	for _, sum := range sums[1:] {
+		if sum != sums[0] {
+			t.Fatalf("concurrent sums differ: %v", sums)
+		}
+	}
+	if n := store.opens.Load(); n > int32(len(sums)) || n < 1 {
+		t.Errorf("file opened %d times", n)
+	}
+}
+
+func TestDigestHeaders(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "small.txt", "small")
+	writeTestFile(t, config, "large.txt", strings.Repeat("L", 100))
+	config.settings.Hash.DigestMaxSize = 10
+
+	// 第一次下载不等待计算，校验和在后台计算后由之后的下载带上
+	w := serve(config, httptest.NewRequest(http.MethodGet, "/download/small.txt", nil))
+	if got := w.Header().Get("Digest"); got != "" {
+		t.Errorf("uncached Digest = %q, want none", got)
+	}
+	info, err := config.store.Stat("small.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := config.hashes.cached("small.txt", info, "sha256"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("digest was not computed in the background")
+		}
+	}
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/download/small.txt", nil))
+	sha := sha256.Sum256([]byte("small"))
+	encoded := base64.StdEncoding.EncodeToString(sha[:])
+	if got := w.Header().Get("Repr-Digest"); got != "sha-256=:"+encoded+":" {
+		t.Errorf("Repr-Digest = %q", got)
+	}
+	if got := w.Header().Get("Digest"); got != "SHA-256="+encoded {
+		t.Errorf("Digest = %q", got)
+	}
+
+	// 超过上限的文件在计算过之后才带上，之前计算过的其他算法一并列出
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/download/large.txt", nil))
+	if got := w.Header().Get("Repr-Digest"); got != "" {
+		t.Errorf("large file Repr-Digest = %q, want none", got)
+	}
+	for _, algo := range []string{"sha256", "md5"} {
+		r := httptest.NewRequest(http.MethodGet, "/hash/large.txt?algo="+algo, nil)
+		r.Header.Set("Accept", "application/json")
+		w = serve(config, r)
+		var result hashResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.Algo != algo || result.Size != 100 || result.Path != "large.txt" {
+			t.Fatalf("hash %s: %d %s", algo, w.Code, w.Body)
+		}
+	}
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/download/large.txt", nil))
+	largeSHA := sha256.Sum256([]byte(strings.Repeat("L", 100)))
+	largeMD5 := md5.Sum([]byte(strings.Repeat("L", 100)))
+	wantDigest := "SHA-256=" + base64.StdEncoding.EncodeToString(largeSHA[:]) + ",MD5=" + base64.StdEncoding.EncodeToString(largeMD5[:])
+	if got := w.Header().Get("Digest"); got != wantDigest {
+		t.Errorf("Digest = %q, want %q", got, wantDigest)
+	}
+
+	tests := []struct {
+		path string
+		code string
+	}{
+		{"/hash/large.txt?algo=crc32", "unsupported_hash_algo"},
+		{"/hash/missing.txt", "file_not_found"},
+		{"/hash/", "cannot_hash_dir"},
+	}
+	for _, tt := range tests {
+		if w := serve(config, httptest.NewRequest(http.MethodGet, tt.path, nil)); w.Header().Get("X-Error-Code") != tt.code {
+			t.Errorf("GET %s: code = %q, want %q", tt.path, w.Header().Get("X-Error-Code"), tt.code)
+		}
+	}
+
+	// 文本格式与 sha256sum 相同
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/hash/small.txt", nil))
+	if want := hex.EncodeToString(sha[:]) + "  small.txt\n"; w.Body.String() != want {
+		t.Errorf("text body = %q, want %q", w.Body, want)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 默认语言，找不到匹配的语言或消息时使用
+const defaultLanguage = "zh-CN"
+
+// 保存语言选择的Cookie名
+const languageCookie = "lang"
+
+// 消息目录：语言代码 -> 消息键 -> 文本
+var catalogs = loadCatalogs()
+
+// 可选语言，用于页面上的语言切换
+type Language struct {
+	Code string
+	Name string
+}
+
+// 加载内置的消息目录（web/i18n/<语言代码>.json）
+func loadCatalogs() map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	names, err := fs.Glob(webFiles, "web/i18n/*.json")
+	if err != nil {
+		log.Fatalf("加载语言文件失败: %v", err)
+	}
+	for _, name := range names {
+		content, err := fs.ReadFile(webFiles, name)
+		if err != nil {
+			log.Fatalf("读取语言文件失败: %v", err)
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(content, &messages); err != nil {
+			log.Fatalf("解析语言文件 %s 失败: %v", name, err)
+		}
+		result[strings.TrimSuffix(path.Base(name), ".json")] = messages
+	}
+	return result
+}
+
+// 所有可选语言，按代码排序
+func availableLanguages() []Language {
+	var languages []Language
+	for _, code := range sortedKeys(catalogs) {
+		languages = append(languages, Language{Code: code, Name: catalogs[code]["language.name"]})
+	}
+	return languages
+}
+
+// 把语言标签匹配到已有的消息目录，如 "en-US" -> "en"、"zh" -> "zh-CN"
+func matchLanguage(tag string) (string, bool) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "", false
+	}
+	for code := range catalogs {
+		if strings.EqualFold(code, tag) {
+			return code, true
+		}
+	}
+	primary := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+	for _, code := range sortedKeys(catalogs) {
+		if strings.ToLower(strings.SplitN(code, "-", 2)[0]) == primary {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// 确定请求使用的语言：?lang= 参数、Cookie、Accept-Language，依次优先
+func requestLanguage(r *http.Request) string {
+	if code, ok := matchLanguage(r.URL.Query().Get("lang")); ok {
+		return code
+	}
+	if cookie, err := r.Cookie(languageCookie); err == nil {
+		if code, ok := matchLanguage(cookie.Value); ok {
+			return code
+		}
+	}
+
+	// 按q值从高到低尝试 Accept-Language 中的语言
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		tags = append(tags, weighted{tag: fields[0], q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, t := range tags {
+		if code, ok := matchLanguage(t.tag); ok && t.q > 0 {
+			return code
+		}
+	}
+	return defaultLanguage
+}
+
+// 翻译消息，带参数时按fmt格式化；缺失的消息回退到默认语言，仍缺失时返回消息键
+func translate(lang, key string, args ...interface{}) string {
+	message, ok := catalogs[lang][key]
+	if !ok {
+		message, ok = catalogs[defaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// 指定前缀的所有消息（去掉前缀），用于传给页面脚本
+func messagesWithPrefix(lang, prefix string) map[string]string {
+	result := make(map[string]string)
+	for key := range catalogs[defaultLanguage] {
+		if name, ok := strings.CutPrefix(key, prefix); ok {
+			result[name] = translate(lang, key)
+		}
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchLanguage(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"en", "en", true},
+		{"EN-us", "en", true},
+		{"zh", "zh-CN", true},
+		{"zh-cn", "zh-CN", true},
+		{"zh-TW", "zh-CN", true},
+		{" en ", "en", true},
+		{"fr", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := matchLanguage(tt.tag)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchLanguage(%q) = %q, %v, want %q, %v", tt.tag, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	tests := []struct {
+		name           string
+		query, cookie  string
+		acceptLanguage string
+		want           string
+	}{
+		{"default", "", "", "", defaultLanguage},
+		{"accept language", "", "", "en-US,en;q=0.9", "en"},
+		{"q values", "", "", "zh-CN;q=0.5, en;q=0.8", "en"},
+		{"unsupported skipped", "", "", "fr, en;q=0.3", "en"},
+		{"q zero ignored", "", "", "en;q=0", defaultLanguage},
+		{"cookie over header", "", "zh-CN", "en", "zh-CN"},
+		{"query over cookie", "en", "zh-CN", "zh-CN", "en"},
+		{"invalid query ignored", "fr", "en", "", "en"},
+	}
+	for _, tt := range tests {
+		target := "/"
+		if tt.query != "" {
+			target += "?lang=" + tt.query
+		}
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: languageCookie, Value: tt.cookie})
+		}
+		if tt.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if got := requestLanguage(r); got != tt.want {
+			t.Errorf("%s: requestLanguage = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateFallback(t *testing.T) {
+	// 临时加入只存在于默认语言中的消息
+	catalogs[defaultLanguage]["test.only_default"] = "默认 %d"
+	defer delete(catalogs[defaultLanguage], "test.only_default")
+
+	if got := translate("en", "test.only_default", 3); got != "默认 3" {
+		t.Errorf("missing message = %q, want the default language", got)
+	}
+	if got := translate("fr", "error.rate_limited"); got != catalogs[defaultLanguage]["error.rate_limited"] {
+		t.Errorf("unknown language = %q, want the default language", got)
+	}
+	if got := translate("en", "test.missing"); got != "test.missing" {
+		t.Errorf("missing everywhere = %q, want the key", got)
+	}
+	if got := translate("en", "error.rate_limited"); got != catalogs["en"]["error.rate_limited"] {
+		t.Errorf("existing message = %q", got)
+	}
+}
+
+func TestCatalogsComplete(t *testing.T) {
+	for code, messages := range catalogs {
+		for key := range catalogs[defaultLanguage] {
+			if _, ok := messages[key]; !ok {
+				t.Errorf("%s: missing %s", code, key)
+			}
+		}
+		for key := range messages {
+			if _, ok := catalogs[defaultLanguage][key]; !ok {
+				t.Errorf("%s: %s is not in the default catalog", code, key)
+			}
+		}
+	}
+}
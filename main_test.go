@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHostURL(t *testing.T) {
+	tests := []struct {
+		host, port, want string
+	}{
+		{"192.168.1.10", "8080", "http://192.168.1.10:8080"},
+		{"localhost", "80", "http://localhost:80"},
+		{"2001:db8::1", "8080", "http://[2001:db8::1]:8080"},
+		{"fe80::1%eth0", "8080", "http://[fe80::1%25eth0]:8080"},
+	}
+	for _, tt := range tests {
+		if got := hostURL(tt.host, tt.port); got != tt.want {
+			t.Errorf("hostURL(%q, %q) = %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestIPFamilyRank(t *testing.T) {
+	ips := []string{"fe80::1%eth0", "2001:db8::1", "10.0.0.2", "fd00::5", "192.168.1.10"}
+	sort.SliceStable(ips, func(i, j int) bool { return ipFamilyRank(ips[i]) < ipFamilyRank(ips[j]) })
+	want := []string{"10.0.0.2", "192.168.1.10", "2001:db8::1", "fd00::5", "fe80::1%eth0"}
+	if strings.Join(ips, " ") != strings.Join(want, " ") {
+		t.Errorf("sorted = %v, want %v", ips, want)
+	}
+}
+
+func TestGenerateQRDataEscapesZone(t *testing.T) {
+	selected := IPAddress{IP: "fe80::1%eth0", BaseURL: hostURL("fe80::1%eth0", "8080"), Interface: "eth0"}
+	_, pageURL := generateQRData("/docs/", selected)
+	want := "http://[fe80::1%25eth0]:8080/docs/?ip=fe80%3A%3A1%25eth0"
+	if pageURL != want {
+		t.Errorf("page URL = %q, want %q", pageURL, want)
+	}
+
+	// localhost 和 public_url 不带IP参数
+	if _, pageURL := generateQRData("/", IPAddress{IP: "localhost", BaseURL: hostURL("localhost", "8080")}); pageURL != "http://localhost:8080/" {
+		t.Errorf("localhost page URL = %q", pageURL)
+	}
+	if _, pageURL := generateQRData("/a/", IPAddress{IP: "public", BaseURL: "https://files.example.com"}); pageURL != "https://files.example.com/a/" {
+		t.Errorf("public page URL = %q", pageURL)
+	}
+}
+
+func TestFileCategory(t *testing.T) {
+	tests := map[string]string{
+		"photo.JPG":   "image",
+		"clip.mkv":    "video",
+		"song.flac":   "audio",
+		"backup.7z":   "archive",
+		"data.tar":    "archive",
+		"main.go":     "code",
+		"page.html":   "code",
+		"report.pdf":  "document",
+		"sheet.xlsx":  "document",
+		"notes.md":    "text",
+		"readme.txt":  "text",
+		"program.exe": "file",
+		"noext":       "file",
+	}
+	for name, want := range tests {
+		if got := fileCategory(name); got != want {
+			t.Errorf("fileCategory(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	// 源代码以纯文本显示
+	if got := getMimeType("main.go"); got != "text/plain" {
+		t.Errorf("getMimeType(main.go) = %q, want text/plain", got)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求耗时直方图的桶边界（秒）
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// 直方图数据
+type histogram struct {
+	counts []uint64 // 每个桶的累计计数，与 latencyBuckets 一一对应
+	sum    float64
+	count  uint64
+}
+
+// 监控指标注册表 - 以Prometheus文本格式输出
+type metricsRegistry struct {
+	mu              sync.Mutex
+	requests        map[string]uint64     // route|method|code -> 请求数
+	durations       map[string]*histogram // route -> 请求耗时
+	bytesSent       map[string]uint64     // route -> 发送字节数
+	bytesReceived   map[string]uint64     // route -> 接收字节数
+	activeTransfers map[string]int64      // direction -> 进行中的传输数
+	uploadFailures  map[string]uint64     // reason -> 上传失败次数
+	scans           map[string]uint64     // result -> 病毒扫描次数
+	quarantineFails uint64                // 隔离文件失败次数
+	recordFails     uint64                // 文件已隔离但隔离记录写入失败的次数
+}
+
+// 全局监控指标
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		requests:        make(map[string]uint64),
+		durations:       make(map[string]*histogram),
+		bytesSent:       make(map[string]uint64),
+		bytesReceived:   make(map[string]uint64),
+		activeTransfers: map[string]int64{"download": 0, "upload": 0},
+		uploadFailures:  make(map[string]uint64),
+		scans:           make(map[string]uint64),
+	}
+}
+
+// 记录一次请求
+func (m *metricsRegistry) observeRequest(route, method string, code int, elapsed time.Duration, sent, received int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[fmt.Sprintf("%s|%s|%d", route, method, code)]++
+	m.bytesSent[route] += uint64(sent)
+	m.bytesReceived[route] += uint64(received)
+
+	h, ok := m.durations[route]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.durations[route] = h
+	}
+	seconds := elapsed.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// 开始一次传输，返回结束时调用的函数
+func (m *metricsRegistry) startTransfer(direction string) func() {
+	m.mu.Lock()
+	m.activeTransfers[direction]++
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		m.activeTransfers[direction]--
+		m.mu.Unlock()
+	}
+}
+
+// 记录一次上传失败
+func (m *metricsRegistry) uploadFailed(reason string) {
+	m.mu.Lock()
+	m.uploadFailures[reason]++
+	m.mu.Unlock()
+}
+
+// 记录一次病毒扫描
+func (m *metricsRegistry) scanned(result string) {
+	m.mu.Lock()
+	m.scans[result]++
+	m.mu.Unlock()
+}
+
+// 记录一次隔离失败
+func (m *metricsRegistry) quarantineFailed() {
+	m.mu.Lock()
+	m.quarantineFails++
+	m.mu.Unlock()
+}
+
+// 记录一次隔离记录写入失败
+func (m *metricsRegistry) quarantineRecordFailed() {
+	m.mu.Lock()
+	m.recordFails++
+	m.mu.Unlock()
+}
+
+// 以Prometheus文本格式写出所有指标，diskDir 为空时（不是本地存储）不输出磁盘空间
+func (m *metricsRegistry) writeTo(w io.Writer, diskDir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP fileserver_http_requests_total HTTP请求总数")
+	fmt.Fprintln(w, "# TYPE fileserver_http_requests_total counter")
+	for _, key := range sortedKeys(m.requests) {
+		parts := strings.SplitN(key, "|", 3)
+		fmt.Fprintf(w, "fileserver_http_requests_total{route=%q,method=%q,code=%q} %d\n", parts[0], parts[1], parts[2], m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP fileserver_http_request_duration_seconds HTTP请求耗时")
+	fmt.Fprintln(w, "# TYPE fileserver_http_request_duration_seconds histogram")
+	for _, route := range sortedKeys(m.durations) {
+		h := m.durations[route]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "fileserver_http_request_duration_seconds_bucket{route=%q,le=\"%g\"} %d\n", route, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "fileserver_http_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", route, h.count)
+		fmt.Fprintf(w, "fileserver_http_request_duration_seconds_sum{route=%q} %g\n", route, h.sum)
+		fmt.Fprintf(w, "fileserver_http_request_duration_seconds_count{route=%q} %d\n", route, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP fileserver_bytes_sent_total 发送的字节数")
+	fmt.Fprintln(w, "# TYPE fileserver_bytes_sent_total counter")
+	for _, route := range sortedKeys(m.bytesSent) {
+		fmt.Fprintf(w, "fileserver_bytes_sent_total{route=%q} %d\n", route, m.bytesSent[route])
+	}
+
+	fmt.Fprintln(w, "# HELP fileserver_bytes_received_total 接收的字节数")
+	fmt.Fprintln(w, "# TYPE fileserver_bytes_received_total counter")
+	for _, route := range sortedKeys(m.bytesReceived) {
+		fmt.Fprintf(w, "fileserver_bytes_received_total{route=%q} %d\n", route, m.bytesReceived[route])
+	}
+
+	fmt.Fprintln(w, "# HELP fileserver_active_transfers 进行中的传输数")
+	fmt.Fprintln(w, "# TYPE fileserver_active_transfers gauge")
+	for _, direction := range sortedKeys(m.activeTransfers) {
+		fmt.Fprintf(w, "fileserver_active_transfers{direction=%q} %d\n", direction, m.activeTransfers[direction])
+	}
+
+	fmt.Fprintln(w, "# HELP fileserver_upload_failures_total 上传失败次数")
+	fmt.Fprintln(w, "# TYPE fileserver_upload_failures_total counter")
+	for _, reason := range sortedKeys(m.uploadFailures) {
+		fmt.Fprintf(w, "fileserver_upload_failures_total{reason=%q} %d\n", reason, m.uploadFailures[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP fileserver_upload_scans_total 上传文件的病毒扫描次数")
+	fmt.Fprintln(w, "# TYPE fileserver_upload_scans_total counter")
+	for _, result := range sortedKeys(m.scans) {
+		fmt.Fprintf(w, "fileserver_upload_scans_total{result=%q} %d\n", result, m.scans[result])
+	}
+
+	fmt.Fprintln(w, "# HELP fileserver_quarantine_failures_total 隔离文件失败次数，失败的文件保持不可下载")
+	fmt.Fprintln(w, "# TYPE fileserver_quarantine_failures_total counter")
+	fmt.Fprintf(w, "fileserver_quarantine_failures_total %d\n", m.quarantineFails)
+
+	fmt.Fprintln(w, "# HELP fileserver_quarantine_record_failures_total 文件已隔离但隔离记录写入失败的次数")
+	fmt.Fprintln(w, "# TYPE fileserver_quarantine_record_failures_total counter")
+	fmt.Fprintf(w, "fileserver_quarantine_record_failures_total %d\n", m.recordFails)
+
+	// 磁盘空间在每次抓取时实时获取
+	if diskDir == "" {
+		return
+	}
+	free, total, err := diskSpace(diskDir)
+	if err != nil {
+		log.Printf("获取磁盘空间失败: %v", err)
+		return
+	}
+	fmt.Fprintln(w, "# HELP fileserver_disk_free_bytes 共享目录所在磁盘的可用空间")
+	fmt.Fprintln(w, "# TYPE fileserver_disk_free_bytes gauge")
+	fmt.Fprintf(w, "fileserver_disk_free_bytes %d\n", free)
+	fmt.Fprintln(w, "# HELP fileserver_disk_total_bytes 共享目录所在磁盘的总空间")
+	fmt.Fprintln(w, "# TYPE fileserver_disk_total_bytes gauge")
+	fmt.Fprintf(w, "fileserver_disk_total_bytes %d\n", total)
+}
+
+// 返回排序后的map键，保证输出顺序稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 记录状态码和字节数的ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.written += int64(n)
+	return n, err
+}
+
+// 支持流式响应
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// 供 http.ResponseController 访问底层连接
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// 统计读取字节数的请求体
+type countingReader struct {
+	io.ReadCloser
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+// 为路由处理器添加请求计数、耗时和流量统计
+func instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		next(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		metrics.observeRequest(route, r.Method, recorder.status, time.Since(start), recorder.written, body.read)
+	}
+}
+
+// 处理监控指标请求
+func handleMetrics(w http.ResponseWriter, r *http.Request, diskDir, token string) {
+	if token != "" && !checkBearerToken(r, token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		httpError(w, r, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.writeTo(w, diskDir)
+}
+
+// 校验访问令牌，支持 Authorization: Bearer 和 ?token= 两种方式
+func checkBearerToken(r *http.Request, token string) bool {
+	provided := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// 根据配置注册 /metrics：独立地址优先，否则挂在主服务上并要求令牌
+func setupMetrics(mux *http.ServeMux, config *ServerConfig) {
+	settings := config.settings.Metrics
+	if !settings.Enabled {
+		return
+	}
+
+	diskDir, _ := storageLocalPath(config.store, ".")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handleMetrics(w, r, diskDir, settings.Token)
+	}
+
+	if settings.Listen != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc("/metrics", handler)
+		go func() {
+			log.Printf("监控指标已启动: http://%s/metrics", settings.Listen)
+			if err := http.ListenAndServe(settings.Listen, metricsMux); err != nil {
+				log.Fatal("监控指标服务启动失败: ", err)
+			}
+		}()
+		return
+	}
+
+	if settings.Token == "" {
+		log.Printf("警告: 未配置 metrics.token 或 metrics.listen，/metrics 未启用")
+		return
+	}
+	mux.HandleFunc("/metrics", restrictAccess(config.acl, "metrics", handler))
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 符号链接策略
+const (
+	symlinksDeny       = "deny"        // 不显示、不访问任何符号链接
+	symlinksWithinRoot = "within_root" // 只允许指向共享目录内（且不是内部目录或隐藏路径）的符号链接
+	symlinksFollow     = "follow"      // 不做检查，直接跟随
+)
+
+// 路径检查的错误
+var (
+	errHiddenPath     = errors.New("隐藏的路径")
+	errSymlinkDenied  = errors.New("不允许访问符号链接")
+	errSymlinkOutside = errors.New("符号链接指向共享目录之外")
+)
+
+// 一条隐藏规则，语法与 .gitignore 相同
+type ignoreRule struct {
+	base     string   // 规则所在目录（共享目录中的相对路径，正斜杠），配置文件中的规则为空
+	segments []string // 按 / 分开的模式，** 匹配任意多级目录
+	anchored bool     // 模式中含有 / 时相对于 base 匹配，否则匹配任意一级的名称
+	dirOnly  bool     // 以 / 结尾的模式只匹配目录
+	negate   bool     // 以 ! 开头的模式重新显示之前的规则隐藏的路径
+}
+
+// 解析一行规则，空行和注释返回false
+func parseIgnoreRule(base, line string) (ignoreRule, bool, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// \# 和 \! 表示以 # 或 ! 开头的名称
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false, nil
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	rule.segments = strings.Split(line, "/")
+	for _, segment := range rule.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return ignoreRule{}, false, fmt.Errorf("无效的模式 %q", line)
+		}
+	}
+	return rule, true, nil
+}
+
+// 规则是否匹配路径（共享目录中的相对路径，正斜杠）
+func (rule ignoreRule) match(relPath string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	sub := relPath
+	if rule.base != "" {
+		var ok bool
+		if sub, ok = strings.CutPrefix(relPath, rule.base+"/"); !ok {
+			return false
+		}
+	}
+	if !rule.anchored {
+		ok, _ := path.Match(rule.segments[0], path.Base(sub))
+		return ok
+	}
+	return matchSegments(rule.segments, strings.Split(sub, "/"))
+}
+
+// 逐级匹配路径，** 匹配零到多级目录，末尾的 ** 匹配其中的所有内容
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// 已读取的目录规则文件，修改时间或大小变化后重新读取
+type ignoreFileEntry struct {
+	modTime time.Time
+	size    int64
+	rules   []ignoreRule
+}
+
+// 路径策略 - 隐藏匹配规则的文件，按符号链接策略检查每次访问的真实路径
+type pathPolicy struct {
+	store      storage
+	root       string // 共享目录的绝对路径
+	realRoot   string // 解析符号链接后的共享目录，不是本地存储时为空（没有符号链接，不做检查）
+	symlinks   string
+	ignoreFile string       // 各目录中的规则文件名，为空表示不读取
+	rules      []ignoreRule // 配置中的规则，相对于共享目录
+
+	mu    sync.Mutex
+	files map[string]ignoreFileEntry // 目录相对路径 -> 其中的规则文件
+}
+
+func newPathPolicy(settings FileSettings, store storage, absShareDir string) (*pathPolicy, error) {
+	var realRoot string
+	if isLocalStorage(store) {
+		var err error
+		if realRoot, err = filepath.EvalSymlinks(absShareDir); err != nil {
+			return nil, err
+		}
+	}
+	p := &pathPolicy{
+		store:      store,
+		root:       absShareDir,
+		realRoot:   realRoot,
+		symlinks:   settings.Symlinks,
+		ignoreFile: settings.IgnoreFile,
+		files:      make(map[string]ignoreFileEntry),
+	}
+	switch p.symlinks {
+	case "":
+		p.symlinks = symlinksWithinRoot
+	case symlinksDeny, symlinksWithinRoot, symlinksFollow:
+	default:
+		return nil, fmt.Errorf("无效的 files.symlinks: %s", settings.Symlinks)
+	}
+	if p.ignoreFile != "" && !validFileName(p.ignoreFile) {
+		return nil, fmt.Errorf("无效的 files.ignore_file: %s", settings.IgnoreFile)
+	}
+	for _, pattern := range settings.Hidden {
+		rule, ok, err := parseIgnoreRule("", pattern)
+		if err != nil {
+			return nil, fmt.Errorf("files.hidden: %v", err)
+		}
+		if ok {
+			p.rules = append(p.rules, rule)
+		}
+	}
+	return p, nil
+}
+
+// 读取目录中的规则文件，文件不存在时没有规则
+func (p *pathPolicy) dirRules(dirRel string) []ignoreRule {
+	if p.ignoreFile == "" {
+		return nil
+	}
+	file := path.Join(dirRel, p.ignoreFile)
+	info, err := p.store.Stat(file)
+	if err != nil || info.IsDir() {
+		p.mu.Lock()
+		delete(p.files, dirRel)
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.mu.Lock()
+	entry, ok := p.files[dirRel]
+	p.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.rules
+	}
+
+	f, err := p.store.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	entry = ignoreFileEntry{modTime: info.ModTime(), size: info.Size()}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		rule, ok, err := parseIgnoreRule(dirRel, scanner.Text())
+		if err != nil {
+			log.Printf("忽略 %s 第 %d 行: %v", file, line, err)
+			continue
+		}
+		if ok {
+			entry.rules = append(entry.rules, rule)
+		}
+	}
+	p.mu.Lock()
+	p.files[dirRel] = entry
+	p.mu.Unlock()
+	return entry.rules
+}
+
+// 目录中的条目适用的所有规则：配置中的规则在前，然后从共享目录开始逐级加上各目录的规则文件
+func (p *pathPolicy) rulesFor(dirRel string) []ignoreRule {
+	rules := append([]ignoreRule(nil), p.rules...)
+	rules = append(rules, p.dirRules("")...)
+	if dirRel == "" {
+		return rules
+	}
+	parts := strings.Split(dirRel, "/")
+	for i := range parts {
+		rules = append(rules, p.dirRules(strings.Join(parts[:i+1], "/"))...)
+	}
+	return rules
+}
+
+// 按规则判断一个条目是否隐藏，后面的规则优先
+func (p *pathPolicy) matchRules(rules []ignoreRule, relPath string, isDir bool) bool {
+	if p.ignoreFile != "" && path.Base(relPath) == p.ignoreFile {
+		return true
+	}
+	// 上传中的临时文件总是隐藏
+	if strings.HasPrefix(path.Base(relPath), uploadTempPrefix) {
+		return true
+	}
+	hidden := false
+	for _, rule := range rules {
+		if rule.match(relPath, isDir) {
+			hidden = !rule.negate
+		}
+	}
+	return hidden
+}
+
+// 路径（共享目录中的相对路径，正斜杠）是否隐藏：任意一级上级目录被隐藏时其中的内容也隐藏；
+// 没有策略（nil）时不隐藏任何路径
+func (p *pathPolicy) hidden(relPath string, isDir bool) bool {
+	if p == nil || relPath == "" || relPath == "." {
+		return false
+	}
+	parts := strings.Split(relPath, "/")
+	rules := p.rulesFor("")
+	for i := range parts {
+		if i > 0 {
+			rules = append(rules, p.dirRules(strings.Join(parts[:i], "/"))...)
+		}
+		last := i == len(parts)-1
+		if p.matchRules(rules, strings.Join(parts[:i+1], "/"), isDir || !last) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按符号链接策略检查路径：deny 时路径中不能有符号链接，within_root 时解析后的真实路径
+// 必须位于共享目录内且不是内部目录或隐藏路径；路径不存在时检查其中存在的部分
+func (p *pathPolicy) checkSymlinks(relPath, fullPath string) error {
+	if p.realRoot == "" {
+		return nil
+	}
+	switch p.symlinks {
+	case symlinksFollow:
+		return nil
+	case symlinksDeny:
+		if relPath == "" || relPath == "." {
+			return nil
+		}
+		current := p.root
+		for _, part := range strings.Split(relPath, "/") {
+			current = filepath.Join(current, part)
+			info, err := os.Lstat(current)
+			if err != nil {
+				// 其余部分不存在，不会经过符号链接
+				return nil
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return errSymlinkDenied
+			}
+		}
+		return nil
+	}
+
+	// 找到存在的最长前缀并解析，再接上不存在的部分
+	existing, rest := fullPath, ""
+	realPath, err := filepath.EvalSymlinks(existing)
+	for err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// 指向不存在的目标的符号链接：写入时会在目标位置创建文件
+		if _, lerr := os.Lstat(existing); lerr == nil || existing == p.root {
+			return errSymlinkOutside
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+		realPath, err = filepath.EvalSymlinks(existing)
+	}
+	target := filepath.Join(realPath, rest)
+	if target == filepath.Join(p.realRoot, filepath.FromSlash(relPath)) {
+		// 没有经过符号链接
+		return nil
+	}
+	realRel, err := filepath.Rel(p.realRoot, target)
+	if err != nil || realRel == ".." || strings.HasPrefix(realRel, ".."+string(filepath.Separator)) {
+		return errSymlinkOutside
+	}
+	realRel = filepath.ToSlash(realRel)
+	if isInternalPath(realRel) {
+		return errSymlinkOutside
+	}
+	info, err := os.Stat(target)
+	if p.hidden(realRel, err == nil && info.IsDir()) {
+		return errHiddenPath
+	}
+	return nil
+}
+
+// 验证请求路径并按隐藏规则和符号链接策略检查，返回清理后的URL相对路径和存储中的名称（根目录为 "."）；
+// 所有访问共享目录中文件的请求都通过这里
+func (p *pathPolicy) validate(urlPath string) (string, string, error) {
+	cleaned, fullPath, err := validateRequestPath(urlPath, p.root)
+	if err != nil {
+		return "", "", err
+	}
+	name := cleaned
+	if name == "" {
+		name = "."
+	}
+	if name != "." {
+		info, err := p.store.Stat(name)
+		if p.hidden(cleaned, err == nil && info.IsDir()) {
+			return "", "", errHiddenPath
+		}
+	}
+	if err := p.checkSymlinks(cleaned, fullPath); err != nil {
+		return "", "", err
+	}
+	return cleaned, name, nil
+}
+
+// 过滤目录中的条目：去掉隐藏的条目和策略不允许的符号链接，允许的符号链接换成其指向的目标的信息；
+// dir 是目录在存储中的名称
+func (p *pathPolicy) filter(dir string, entries []fs.DirEntry) []fs.DirEntry {
+	dirRel := dir
+	if dirRel == "." {
+		dirRel = ""
+	}
+	rules := p.rulesFor(dirRel)
+
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		relPath := path.Join(dirRel, entry.Name())
+		if entry.Type()&os.ModeSymlink != 0 {
+			if p.symlinks == symlinksDeny {
+				continue
+			}
+			fullPath := filepath.Join(p.root, filepath.FromSlash(relPath))
+			if err := p.checkSymlinks(relPath, fullPath); err != nil {
+				continue
+			}
+			info, err := os.Stat(fullPath)
+			if err != nil {
+				// 指向不存在的目标
+				continue
+			}
+			entry = fs.FileInfoToDirEntry(info)
+		}
+		if p.matchRules(rules, relPath, entry.IsDir()) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result
+}
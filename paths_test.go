@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRuleMatch(t *testing.T) {
+	tests := []struct {
+		pattern, base string
+		path          string
+		isDir         bool
+		want          bool
+	}{
+		{"*.log", "", "app.log", false, true},
+		{"*.log", "", "logs/app.log", false, true},
+		{"*.log", "", "app.txt", false, false},
+		{".*", "", "docs/.secret", false, true},
+		{"build/", "", "build", true, true},
+		{"build/", "", "build", false, false},
+		{"/private", "", "private", true, true},
+		{"/private", "", "docs/private", true, false},
+		{"docs/*.tmp", "", "docs/a.tmp", false, true},
+		{"docs/*.tmp", "", "docs/sub/a.tmp", false, false},
+		{"**/cache", "", "cache", true, true},
+		{"**/cache", "", "a/b/cache", true, true},
+		{"a/**/b", "", "a/b", false, true},
+		{"a/**/b", "", "a/x/y/b", false, true},
+		{"a/**", "", "a/x/y", false, true},
+		{"a/**", "", "a", true, false},
+		{"*.tmp", "docs", "docs/x/a.tmp", false, true},
+		{"*.tmp", "docs", "other/a.tmp", false, false},
+		{"/notes.txt", "docs", "docs/notes.txt", false, true},
+		{"/notes.txt", "docs", "docs/sub/notes.txt", false, false},
+		{`\#name`, "", "#name", false, true},
+		{"file?.txt", "", "file1.txt", false, true},
+		{"file[0-9].txt", "", "filea.txt", false, false},
+	}
+	for _, tt := range tests {
+		rule, ok, err := parseIgnoreRule(tt.base, tt.pattern)
+		if err != nil || !ok {
+			t.Errorf("parseIgnoreRule(%q) = %v, %v", tt.pattern, ok, err)
+			continue
+		}
+		if got := rule.match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q (base %q) match(%q, dir=%v) = %v, want %v", tt.pattern, tt.base, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if _, ok, err := parseIgnoreRule("", line); ok || err != nil {
+			t.Errorf("parseIgnoreRule(%q) = %v, %v, want skipped", line, ok, err)
+		}
+	}
+	if _, _, err := parseIgnoreRule("", "[a-"); err == nil {
+		t.Error("parseIgnoreRule accepted an invalid pattern")
+	}
+}
+
+func TestPathPolicyHidden(t *testing.T) {
+	root := t.TempDir()
+	store := newLocalStorage(root)
+	writeStoreFile(t, store, "docs/drafts/a.txt", "draft")
+	writeStoreFile(t, store, "secret/a.txt", "secret")
+	writeStoreFile(t, store, "docs/.fileignore", "drafts/\n*.bak\n!keep.bak\n")
+	p, err := newPathPolicy(FileSettings{Hidden: []string{".*", "/secret/", "*.tmp"}, IgnoreFile: ".fileignore"}, store, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{".env", false, true},
+		{"secret", true, true},
+		{"secret/a.txt", false, true}, // 上级目录隐藏时其中的内容也隐藏
+		{"docs/secret", true, false},  // 以 / 开头的规则只匹配共享目录下的一级
+		{"a.tmp", false, true},
+		{"docs/a.txt", false, false},
+		{"docs/drafts", true, true},
+		{"docs/drafts/a.txt", false, true},
+		{"docs/a.bak", false, true},
+		{"docs/keep.bak", false, false}, // ! 重新显示
+		{"other.bak", false, false},     // 规则文件只作用于所在目录
+		{"docs/.fileignore", false, true},
+	}
+	for _, tt := range tests {
+		if got := p.hidden(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("hidden(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// 规则文件修改后重新读取
+	writeStoreFile(t, store, "docs/.fileignore", "*.txt\n")
+	if !p.hidden("docs/a.txt", false) || p.hidden("docs/a.bak", false) {
+		t.Error("changed ignore file was not reloaded")
+	}
+
+	if _, _, err := p.validate("/docs/a.txt"); !errors.Is(err, errHiddenPath) {
+		t.Errorf("validate hidden path: err = %v, want errHiddenPath", err)
+	}
+	if _, _, err := p.validate("/.trash/x"); err == nil {
+		t.Error("validate allowed an internal path")
+	}
+
+	if _, err := newPathPolicy(FileSettings{Symlinks: "sometimes"}, store, root); err == nil {
+		t.Error("newPathPolicy accepted an unknown symlink policy")
+	}
+	if _, err := newPathPolicy(FileSettings{IgnoreFile: "../x"}, store, root); err == nil {
+		t.Error("newPathPolicy accepted an invalid ignore file name")
+	}
+}
+
+func TestSymlinkPolicy(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	store := newLocalStorage(root)
+	writeStoreFile(t, store, "docs/a.txt", "x")
+	writeStoreFile(t, store, "private/p.txt", "x")
+	writeStoreFile(t, newLocalStorage(outside), "o.txt", "x")
+	os.Mkdir(filepath.Join(root, ".trash"), 0755)
+	links := map[string]string{
+		"inside":    filepath.Join(root, "docs", "a.txt"),
+		"insidedir": filepath.Join(root, "docs"),
+		"outside":   filepath.Join(outside, "o.txt"),
+		"outdir":    outside,
+		"internal":  filepath.Join(root, ".trash"),
+		"hidden":    filepath.Join(root, "private", "p.txt"),
+		"dangling":  filepath.Join(outside, "missing.txt"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks not supported:", err)
+		}
+	}
+
+	tests := []struct {
+		path                     string
+		deny, withinRoot, follow error
+	}{
+		{"/docs/a.txt", nil, nil, nil},
+		{"/inside", errSymlinkDenied, nil, nil},
+		{"/insidedir/a.txt", errSymlinkDenied, nil, nil},
+		{"/insidedir/new.txt", errSymlinkDenied, nil, nil},
+		{"/outside", errSymlinkDenied, errSymlinkOutside, nil},
+		{"/outdir/o.txt", errSymlinkDenied, errSymlinkOutside, nil},
+		{"/outdir/new.txt", errSymlinkDenied, errSymlinkOutside, nil},
+		{"/internal", errSymlinkDenied, errSymlinkOutside, nil},
+		{"/hidden", errSymlinkDenied, errHiddenPath, nil},
+		{"/dangling", errSymlinkDenied, errSymlinkOutside, nil},
+	}
+	for _, policy := range []string{symlinksDeny, symlinksWithinRoot, symlinksFollow} {
+		p, err := newPathPolicy(FileSettings{Hidden: []string{"/private/"}, Symlinks: policy}, store, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			want := map[string]error{symlinksDeny: tt.deny, symlinksWithinRoot: tt.withinRoot, symlinksFollow: tt.follow}[policy]
+			if _, _, err := p.validate(tt.path); !errors.Is(err, want) {
+				t.Errorf("%s: validate(%s) = %v, want %v", policy, tt.path, err, want)
+			}
+		}
+	}
+
+	// 目录列表中去掉策略不允许的符号链接
+	listed := func(policy string) []string {
+		p, err := newPathPolicy(FileSettings{Hidden: []string{"/private/", ".*"}, Symlinks: policy}, store, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range p.filter(".", entries) {
+			names = append(names, entry.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+	wants := map[string][]string{
+		symlinksDeny:       {"docs"},
+		symlinksWithinRoot: {"docs", "inside", "insidedir"},
+		symlinksFollow:     {"docs", "hidden", "inside", "insidedir", "internal", "outdir", "outside"},
+	}
+	for policy, want := range wants {
+		if got := listed(policy); !slices.Equal(got, want) {
+			t.Errorf("%s: listing = %v, want %v", policy, got, want)
+		}
+	}
+}
+
+func TestHiddenPathsInHandlers(t *testing.T) {
+	settings := defaultSettings()
+	settings.Files.Hidden = append(settings.Files.Hidden, "*.tmp", "/private/")
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "visible.txt", "v")
+	writeTestFile(t, config, "draft.tmp", "d")
+	writeTestFile(t, config, ".env", "e")
+	writeTestFile(t, config, "private/p.txt", "p")
+
+	body := serve(config, httptest.NewRequest(http.MethodGet, "/", nil)).Body.String()
+	if !strings.Contains(body, "visible.txt") {
+		t.Error("listing does not contain visible.txt")
+	}
+	for _, name := range []string{"draft.tmp", ".env", "private"} {
+		if strings.Contains(body, name) {
+			t.Errorf("listing contains hidden %s", name)
+		}
+	}
+
+	// 隐藏的文件不能下载、删除或取校验和，也不能上传到隐藏的路径
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/download/draft.tmp", nil),
+		httptest.NewRequest(http.MethodGet, "/download/private/p.txt", nil),
+		httptest.NewRequest(http.MethodGet, "/private/", nil),
+		httptest.NewRequest(http.MethodDelete, "/delete/.env", nil),
+		httptest.NewRequest(http.MethodGet, "/hash/draft.tmp", nil),
+	} {
+		if w := serve(config, r); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want 403", r.Method, r.URL.Path, w.Code)
+		}
+	}
+	w := serve(config, newUploadRequest(t, "/upload/private/", map[string]string{"a.txt": "a"}))
+	if w.Code != http.StatusBadRequest || w.Header().Get("X-Error-Code") != "invalid_upload_target" {
+		t.Errorf("upload into hidden directory: status = %d, X-Error-Code = %q", w.Code, w.Header().Get("X-Error-Code"))
+	}
+	r := newUploadRequest(t, "/upload/", map[string]string{"new.tmp": "n"})
+	r.Header.Set("Accept", "application/json")
+	if _, files := decodeUploadResult(t, serve(config, r)); files["new.tmp"].Status != "failed" {
+		t.Errorf("upload of a hidden name = %+v, want failed", files["new.tmp"])
+	}
+	if _, err := os.Stat(filepath.Join(config.absShareDir, "new.tmp")); !os.IsNotExist(err) {
+		t.Errorf("hidden upload was saved: %v", err)
+	}
+}
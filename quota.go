@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// 按目录的配额
+type dirQuota struct {
+	dir   string // 目录在存储中的名称
+	limit int64  // 目录（含子目录）的总大小上限
+}
+
+// 上传配额与磁盘空间保护
+type quotaManager struct {
+	store        storage
+	usage        *usageTracker
+	minFreeSpace int64 // 磁盘至少保留的可用空间
+	perUser      int64 // 每个用户的上传总量上限，0表示不限制
+	dirs         []dirQuota
+
+	// 正在写入的上传预留的字节数，写入完成并计入用量后释放；
+	// 同时进行的上传共同受配额限制，不会各自按同一份已用量计算而一起超出
+	mu           sync.Mutex
+	userReserved map[string]int64 // 用户 -> 预留的字节数
+	dirReserved  map[string]int64 // 配额目录 -> 预留的字节数
+}
+
+// 根据配置创建配额管理
+func newQuotaManager(settings QuotaSettings, store storage, usage *usageTracker) (*quotaManager, error) {
+	q := &quotaManager{
+		store:        store,
+		usage:        usage,
+		minFreeSpace: settings.MinFreeSpace,
+		perUser:      settings.PerUser,
+		userReserved: make(map[string]int64),
+		dirReserved:  make(map[string]int64),
+	}
+	for _, d := range settings.Directories {
+		dir := path.Clean(strings.Trim(d.Path, "/"))
+		if !fs.ValidPath(dir) || isInternalPath(dir) {
+			return nil, fmt.Errorf("目录配额 %s: 无效的路径", d.Path)
+		}
+		if d.Limit <= 0 {
+			return nil, fmt.Errorf("目录配额 %s 的 limit 必须大于0", d.Path)
+		}
+		q.dirs = append(q.dirs, dirQuota{dir: dir, limit: d.Limit})
+	}
+	return q, nil
+}
+
+// 还能写入的字节数，以及用完时返回的状态码和错误码
+type uploadBudget struct {
+	remaining int64 // 小于0表示不限制
+	status    int
+	code      string
+}
+
+// 在限额中取更小的一个
+func (b *uploadBudget) tighten(remaining int64, status int, code string) {
+	if remaining < 0 {
+		remaining = 0
+	}
+	if b.remaining < 0 || remaining < b.remaining {
+		*b = uploadBudget{remaining: remaining, status: status, code: code}
+	}
+}
+
+// 计算用户向目录上传时的剩余额度：磁盘可用空间（扣除保留空间，只有本地存储检查）、用户配额和所在目录的配额，
+// 配额中扣除正在进行的上传预留的字节数；destDir 是目录在存储中的名称
+func (q *quotaManager) budget(user, destDir string) uploadBudget {
+	b := q.diskBudget(destDir)
+	dirUsed := q.dirsUsed(destDir)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.quotaBudget(&b, user, dirUsed)
+	return b
+}
+
+// 磁盘可用空间扣除保留空间后的额度，不是本地存储时不限制
+func (q *quotaManager) diskBudget(destDir string) uploadBudget {
+	b := uploadBudget{remaining: -1}
+	if localDir, ok := storageLocalPath(q.store, destDir); ok {
+		if free, _, err := diskSpace(localDir); err == nil {
+			b.tighten(int64(free)-q.minFreeSpace, http.StatusInsufficientStorage, "insufficient_storage")
+		} else {
+			log.Printf("获取磁盘空间失败: %v", err)
+		}
+	}
+	return b
+}
+
+// 包含 destDir 的配额目录的已用量；没有缓存时需要遍历目录，所以在加锁之前调用
+func (q *quotaManager) dirsUsed(destDir string) map[string]int64 {
+	var used map[string]int64
+	for _, d := range q.dirs {
+		if !nameWithin(destDir, d.dir) {
+			continue
+		}
+		usage, err := q.usage.dirUsage(d.dir)
+		if err != nil {
+			log.Printf("统计目录用量失败: %v (目录: %s)", err, d.dir)
+			continue
+		}
+		if used == nil {
+			used = make(map[string]int64)
+		}
+		used[d.dir] = usage.Size
+	}
+	return used
+}
+
+// 用户配额和目录配额的剩余额度，扣除已预留的字节数；dirUsed 来自 dirsUsed，调用时需持有锁
+func (q *quotaManager) quotaBudget(b *uploadBudget, user string, dirUsed map[string]int64) {
+	if q.perUser > 0 {
+		b.tighten(q.perUser-q.usage.userUsage(user)-q.userReserved[user], http.StatusRequestEntityTooLarge, "user_quota_exceeded")
+	}
+	for _, d := range q.dirs {
+		if used, ok := dirUsed[d.dir]; ok {
+			b.tighten(d.limit-used-q.dirReserved[d.dir], http.StatusRequestEntityTooLarge, "dir_quota_exceeded")
+		}
+	}
+}
+
+// 一次上传预留的配额，随写入逐步增加
+type quotaReservation struct {
+	q       *quotaManager
+	user    string
+	destDir string
+	dirUsed map[string]int64 // 开始写入时配额目录的已用量，写入过程中不再重新统计
+	size    int64
+}
+
+// 开始向目录写入一个文件，写入完成后必须调用 release
+func (q *quotaManager) reserve(user, destDir string) *quotaReservation {
+	return &quotaReservation{q: q, user: user, destDir: destDir, dirUsed: q.dirsUsed(destDir)}
+}
+
+// 再预留n字节，超出用户或目录配额时不预留并返回对应的额度
+func (r *quotaReservation) grow(n int64) (uploadBudget, bool) {
+	q := r.q
+	if q.perUser <= 0 && len(q.dirs) == 0 {
+		return uploadBudget{}, true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	b := uploadBudget{remaining: -1}
+	q.quotaBudget(&b, r.user, r.dirUsed)
+	if b.remaining >= 0 && n > b.remaining {
+		return b, false
+	}
+	r.size += n
+	q.userReserved[r.user] += n
+	for _, d := range q.dirs {
+		if nameWithin(r.destDir, d.dir) {
+			q.dirReserved[d.dir] += n
+		}
+	}
+	return b, true
+}
+
+// 释放预留的字节数：上传成功时在计入用量之后调用，失败时直接调用
+func (r *quotaReservation) release() {
+	q := r.q
+	if r.size == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.userReserved[r.user] -= r.size
+	if q.userReserved[r.user] == 0 {
+		delete(q.userReserved, r.user)
+	}
+	for _, d := range q.dirs {
+		if nameWithin(r.destDir, d.dir) {
+			q.dirReserved[d.dir] -= r.size
+			if q.dirReserved[d.dir] == 0 {
+				delete(q.dirReserved, d.dir)
+			}
+		}
+	}
+	r.size = 0
+}
+
+// 写入超出额度
+var errBudgetExceeded = errors.New("超出上传额度")
+
+// 限制写入字节数的Writer：不超过磁盘额度，并按写入的字节数预留配额；
+// 超出时返回 errBudgetExceeded，exceeded 为超出的额度
+type budgetWriter struct {
+	w           io.Writer
+	disk        uploadBudget // 磁盘额度，remaining 小于0表示不限制
+	reservation *quotaReservation
+	exceeded    uploadBudget
+}
+
+func (bw *budgetWriter) Write(p []byte) (int, error) {
+	if bw.disk.remaining >= 0 && int64(len(p)) > bw.disk.remaining {
+		bw.exceeded = bw.disk
+		return 0, errBudgetExceeded
+	}
+	if bw.reservation != nil {
+		if b, ok := bw.reservation.grow(int64(len(p))); !ok {
+			bw.exceeded = b
+			return 0, errBudgetExceeded
+		}
+	}
+	n, err := bw.w.Write(p)
+	if bw.disk.remaining >= 0 {
+		bw.disk.remaining -= int64(n)
+	}
+	return n, err
+}
+
+// 上传者标识 - 配置了 user_header 时为用户名，否则为客户端IP
+func uploadUser(r *http.Request, config *ServerConfig) string {
+	if user := config.limiter.requestUser(r); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + clientIP(r)
+}
+
+// 目录的磁盘与配额用量，显示在页面上
+type diskInfo struct {
+	Used      string
+	Total     string
+	Free      string
+	Percent   int
+	QuotaUsed string // 当前用户已用的配额，未配置每用户配额时为空
+	Quota     string
+}
+
+// 获取页面上显示的磁盘用量，不是本地存储时不显示
+func pageDiskInfo(r *http.Request, config *ServerConfig) *diskInfo {
+	root, ok := storageLocalPath(config.store, ".")
+	if !ok {
+		return nil
+	}
+	free, total, err := diskSpace(root)
+	if err != nil || total == 0 {
+		return nil
+	}
+	info := &diskInfo{
+		Used:    humanizeSize(int64(total - free)),
+		Total:   humanizeSize(int64(total)),
+		Free:    humanizeSize(int64(free)),
+		Percent: int((total - free) * 100 / total),
+	}
+	if q := config.quota; q.perUser > 0 {
+		info.QuotaUsed = humanizeSize(q.usage.userUsage(uploadUser(r, config)))
+		info.Quota = humanizeSize(q.perUser)
+	}
+	return info
+}
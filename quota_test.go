@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 临时目录上的配额管理，shared 目录中已有 40 字节
+func newTestQuota(t *testing.T, settings QuotaSettings) *quotaManager {
+	t.Helper()
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "shared"), 0755)
+	os.Mkdir(filepath.Join(root, "other"), 0755)
+	os.WriteFile(filepath.Join(root, "shared", "a.bin"), []byte(strings.Repeat("a", 40)), 0644)
+	store := newLocalStorage(root)
+	usage, err := newUsageTracker(store, nil, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := newQuotaManager(settings, store, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestQuotaBudget(t *testing.T) {
+	q := newTestQuota(t, QuotaSettings{
+		PerUser:     100,
+		Directories: []DirQuota{{Path: "/shared/", Limit: 50}},
+	})
+	q.usage.recordUpload("user:alice", "other/x.bin", 30, 0)
+
+	tests := []struct {
+		user, dir string
+		want      uploadBudget
+	}{
+		{"user:bob", ".", uploadBudget{remaining: 100, status: http.StatusRequestEntityTooLarge, code: "user_quota_exceeded"}},
+		{"user:alice", "other", uploadBudget{remaining: 70, status: http.StatusRequestEntityTooLarge, code: "user_quota_exceeded"}},
+		{"user:bob", "shared", uploadBudget{remaining: 10, status: http.StatusRequestEntityTooLarge, code: "dir_quota_exceeded"}},
+		{"user:bob", "shared/sub", uploadBudget{remaining: 10, status: http.StatusRequestEntityTooLarge, code: "dir_quota_exceeded"}},
+		{"user:bob", "sharedx", uploadBudget{remaining: 100, status: http.StatusRequestEntityTooLarge, code: "user_quota_exceeded"}},
+	}
+	for _, tt := range tests {
+		if got := q.budget(tt.user, tt.dir); got != tt.want {
+			t.Errorf("budget(%s, %s) = %+v, want %+v", tt.user, tt.dir, got, tt.want)
+		}
+	}
+
+	// 没有配额时只受磁盘空间限制
+	if got := newTestQuota(t, QuotaSettings{}).budget("user:bob", "shared"); got.code != "insufficient_storage" {
+		t.Errorf("unlimited budget = %+v, want only the disk limit", got)
+	}
+
+	// 保留的磁盘空间超过可用空间时没有额度
+	q.minFreeSpace = 1 << 62
+	if got := q.budget("user:bob", "."); got.remaining != 0 || got.code != "insufficient_storage" {
+		t.Errorf("budget without free space = %+v", got)
+	}
+}
+
+func TestQuotaInvalidDirectory(t *testing.T) {
+	store := newMemStorage()
+	usage, _ := newUsageTracker(store, nil, "", time.Hour)
+	for _, d := range []DirQuota{{Path: "/../x", Limit: 1}, {Path: "/.trash/", Limit: 1}, {Path: "/ok/", Limit: 0}} {
+		if _, err := newQuotaManager(QuotaSettings{Directories: []DirQuota{d}}, store, usage); err == nil {
+			t.Errorf("newQuotaManager accepted directory quota %+v", d)
+		}
+	}
+}
+
+func TestQuotaReservation(t *testing.T) {
+	q := newTestQuota(t, QuotaSettings{PerUser: 100})
+
+	// 两个同时进行的上传共同受配额限制
+	first := q.reserve("user:alice", ".")
+	second := q.reserve("user:alice", ".")
+	if _, ok := first.grow(60); !ok {
+		t.Fatal("first upload could not reserve 60 bytes")
+	}
+	if got := q.budget("user:alice", "."); got.remaining != 40 {
+		t.Errorf("budget during upload = %d, want 40", got.remaining)
+	}
+	if b, ok := second.grow(60); ok || b.code != "user_quota_exceeded" {
+		t.Errorf("second upload reserved beyond the quota: %+v, %v", b, ok)
+	}
+
+	// 失败的上传释放预留后额度恢复
+	first.release()
+	if _, ok := second.grow(60); !ok {
+		t.Error("reservation was not released")
+	}
+
+	// 成功的上传计入用量后释放预留，不会重复计算
+	q.usage.recordUpload("user:alice", "a.bin", 60, 0)
+	second.release()
+	if got := q.budget("user:alice", "."); got.remaining != 40 {
+		t.Errorf("budget after upload = %d, want 40", got.remaining)
+	}
+	if len(q.userReserved) != 0 {
+		t.Errorf("reservations left after release: %v", q.userReserved)
+	}
+}
+
+func TestQuotaReservationDirUsage(t *testing.T) {
+	q := newTestQuota(t, QuotaSettings{Directories: []DirQuota{{Path: "/shared/", Limit: 50}}})
+	r := q.reserve("user:bob", "shared")
+	defer r.release()
+
+	// 写入过程中使用开始时统计的目录用量，不重新遍历目录
+	q.usage.mu.Lock()
+	q.usage.dirs["shared"] = dirUsage{}
+	q.usage.mu.Unlock()
+	if b, ok := r.grow(20); ok || b.remaining != 10 {
+		t.Errorf("grow(20) = %+v, %v, want the 10 bytes left when the upload started", b, ok)
+	}
+	if _, ok := r.grow(10); !ok {
+		t.Error("grow(10) within the quota failed")
+	}
+}
+
+func TestBudgetWriter(t *testing.T) {
+	q := newTestQuota(t, QuotaSettings{Directories: []DirQuota{{Path: "shared", Limit: 50}}})
+
+	var written strings.Builder
+	w := &budgetWriter{w: &written, disk: uploadBudget{remaining: -1}, reservation: q.reserve("user:bob", "shared")}
+	if _, err := io.WriteString(w, strings.Repeat("b", 10)); err != nil {
+		t.Fatalf("write within quota: %v", err)
+	}
+	if _, err := io.WriteString(w, "c"); !errors.Is(err, errBudgetExceeded) {
+		t.Fatalf("write beyond quota: err = %v, want errBudgetExceeded", err)
+	}
+	if w.exceeded.code != "dir_quota_exceeded" || written.Len() != 10 {
+		t.Errorf("exceeded = %+v, written = %d", w.exceeded, written.Len())
+	}
+
+	disk := uploadBudget{remaining: 5, status: http.StatusInsufficientStorage, code: "insufficient_storage"}
+	w = &budgetWriter{w: io.Discard, disk: disk}
+	if _, err := io.WriteString(w, "123456"); !errors.Is(err, errBudgetExceeded) || w.exceeded.code != "insufficient_storage" {
+		t.Errorf("disk budget: err = %v, exceeded = %+v", err, w.exceeded)
+	}
+}
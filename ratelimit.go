@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 令牌桶 - 允许令牌数为负，表示已预支、需要等待的量
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 桶容量
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// 按经过的时间补充令牌，调用时需持有锁
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// 尝试取出n个令牌，不足时返回需要等待的时间
+func (b *tokenBucket) allow(n float64) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	return false, time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// 预支n个令牌，返回调用方需要等待的时间
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// 按键（IP或用户名）分组的令牌桶，空闲的桶会被定期清理
+type bucketGroup struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newBucketGroup(rate, burst float64) *bucketGroup {
+	g := &bucketGroup{rate: rate, burst: burst, buckets: make(map[string]*tokenBucket)}
+	go g.cleanup()
+	return g
+}
+
+func (g *bucketGroup) get(key string) *tokenBucket {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, ok := g.buckets[key]
+	if !ok {
+		b = newTokenBucket(g.rate, g.burst)
+		g.buckets[key] = b
+	}
+	return b
+}
+
+// 每分钟清理一次空闲的桶
+func (g *bucketGroup) cleanup() {
+	for now := range time.Tick(time.Minute) {
+		g.sweep(now)
+	}
+}
+
+// 删除已经补满的桶 - 与新建的桶等价，删除不影响限流效果
+func (g *bucketGroup) sweep(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, b := range g.buckets {
+		b.mu.Lock()
+		b.refill(now)
+		full := b.tokens >= b.burst
+		b.mu.Unlock()
+		if full {
+			delete(g.buckets, key)
+		}
+	}
+}
+
+// 限流器 - 请求频率、带宽和并发下载数
+type rateLimiter struct {
+	requests      *bucketGroup  // 每IP请求频率
+	globalBW      *tokenBucket  // 全局带宽
+	ipBW          *bucketGroup  // 每IP带宽
+	userBW        *bucketGroup  // 每用户带宽
+	downloadSlots chan struct{} // 并发下载槽位
+	userHeader    string        // 携带用户名的请求头
+	acl           *accessControl
+}
+
+// 根据配置创建限流器，未配置的限制项保持为nil
+func newRateLimiter(settings LimitSettings, userHeader string, acl *accessControl) *rateLimiter {
+	l := &rateLimiter{userHeader: userHeader, acl: acl}
+
+	if settings.RequestRate > 0 {
+		burst := float64(settings.RequestBurst)
+		if burst < 1 {
+			burst = math.Max(1, settings.RequestRate)
+		}
+		l.requests = newBucketGroup(settings.RequestRate, burst)
+	}
+	// 带宽桶容量为一秒的流量，允许短时突发
+	if settings.GlobalBandwidth > 0 {
+		l.globalBW = newTokenBucket(float64(settings.GlobalBandwidth), float64(settings.GlobalBandwidth))
+	}
+	if settings.PerIPBandwidth > 0 {
+		l.ipBW = newBucketGroup(float64(settings.PerIPBandwidth), float64(settings.PerIPBandwidth))
+	}
+	if settings.PerUserBandwidth > 0 {
+		l.userBW = newBucketGroup(float64(settings.PerUserBandwidth), float64(settings.PerUserBandwidth))
+	}
+	if settings.MaxDownloads > 0 {
+		l.downloadSlots = make(chan struct{}, settings.MaxDownloads)
+	}
+	return l
+}
+
+// 获取请求的用户名 - 由前置的认证代理通过请求头传入，未配置时为空
+func (l *rateLimiter) requestUser(r *http.Request) string {
+	// 用户名由前置的认证代理写入，直连的客户端可以随意伪造，只采用受信任代理转发的请求中的值
+	if l.userHeader == "" || l.acl == nil || !l.acl.fromTrustedProxy(r) {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(l.userHeader))
+}
+
+// 返回429并告知客户端多久后重试
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, code string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+	httpError(w, r, http.StatusTooManyRequests, code)
+}
+
+// 每IP请求频率限制中间件
+func limitRequests(l *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.requests != nil {
+			if ok, wait := l.requests.get(clientIP(r)).allow(1); !ok {
+				tooManyRequests(w, r, wait, "rate_limited")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// 占用一个下载槽位，槽位已满时返回false
+func (l *rateLimiter) acquireDownload() (release func(), ok bool) {
+	if l.downloadSlots == nil {
+		return func() {}, true
+	}
+	select {
+	case l.downloadSlots <- struct{}{}:
+		return func() { <-l.downloadSlots }, true
+	default:
+		return nil, false
+	}
+}
+
+// 请求适用的所有带宽桶
+func (l *rateLimiter) bandwidthBuckets(r *http.Request) []*tokenBucket {
+	var buckets []*tokenBucket
+	if l.globalBW != nil {
+		buckets = append(buckets, l.globalBW)
+	}
+	if l.ipBW != nil {
+		buckets = append(buckets, l.ipBW.get(clientIP(r)))
+	}
+	if user := l.requestUser(r); user != "" && l.userBW != nil {
+		buckets = append(buckets, l.userBW.get(user))
+	}
+	return buckets
+}
+
+// 带宽节流器 - 每传输一块数据前从所有适用的桶中预支令牌并等待
+type throttle struct {
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+// 每次节流的最大块大小
+const throttleChunk = 32 * 1024
+
+func (t *throttle) wait(n int) error {
+	var delay time.Duration
+	for _, b := range t.buckets {
+		if d := b.reserve(float64(n)); d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+// 限速写入器
+type throttledWriter struct {
+	w io.Writer
+	t *throttle
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		if err := tw.t.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := tw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// 限速读取器
+type throttledReader struct {
+	io.ReadCloser
+	t *throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := tr.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := tr.t.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// 为下载响应包装限速写入器，没有适用的限制时原样返回
+func (l *rateLimiter) throttleWriter(w io.Writer, r *http.Request) io.Writer {
+	buckets := l.bandwidthBuckets(r)
+	if len(buckets) == 0 {
+		return w
+	}
+	return &throttledWriter{w: w, t: &throttle{ctx: r.Context(), buckets: buckets}}
+}
+
+// 为上传请求体包装限速读取器，没有适用的限制时原样返回
+func (l *rateLimiter) throttleReader(body io.ReadCloser, r *http.Request) io.ReadCloser {
+	buckets := l.bandwidthBuckets(r)
+	if len(buckets) == 0 {
+		return body
+	}
+	return &throttledReader{ReadCloser: body, t: &throttle{ctx: r.Context(), buckets: buckets}}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow(1); !ok {
+			t.Fatalf("request %d within burst was rejected", i+1)
+		}
+	}
+	ok, wait := b.allow(1)
+	if ok {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("wait = %v, want up to 100ms at 10 tokens/s", wait)
+	}
+
+	// 经过的时间按速率补充令牌，最多补到桶容量
+	b.mu.Lock()
+	b.last = b.last.Add(-time.Hour)
+	b.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow(1); !ok {
+			t.Fatalf("request %d after refill was rejected", i+1)
+		}
+	}
+	if ok, _ := b.allow(1); ok {
+		t.Error("bucket refilled beyond its burst")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	b := newTokenBucket(100, 100)
+	if wait := b.reserve(100); wait != 0 {
+		t.Errorf("reserve within burst: wait = %v, want 0", wait)
+	}
+	// 预支后令牌为负，等待时间按欠下的量计算
+	wait := b.reserve(50)
+	if wait < 400*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("reserve beyond burst: wait = %v, want about 500ms", wait)
+	}
+}
+
+func TestBucketGroupSweep(t *testing.T) {
+	g := &bucketGroup{rate: 1, burst: 5, buckets: make(map[string]*tokenBucket)}
+	if g.get("a") != g.get("a") {
+		t.Fatal("get returned different buckets for the same key")
+	}
+	g.get("a").allow(5)
+	g.get("b")
+
+	// 补满的桶被删除，仍在限流中的桶保留
+	now := time.Now()
+	g.sweep(now)
+	if _, ok := g.buckets["b"]; ok {
+		t.Error("full bucket was kept")
+	}
+	if _, ok := g.buckets["a"]; !ok {
+		t.Error("drained bucket was removed")
+	}
+	g.sweep(now.Add(time.Minute))
+	if len(g.buckets) != 0 {
+		t.Errorf("buckets after refill = %d, want 0", len(g.buckets))
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	l := newRateLimiter(LimitSettings{RequestRate: 1, RequestBurst: 2}, "", nil)
+	handler := limitRequests(l, func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := request("192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	w := request("192.0.2.1:1001")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if w.Header().Get("X-Error-Code") != "rate_limited" {
+		t.Errorf("X-Error-Code = %q, want rate_limited", w.Header().Get("X-Error-Code"))
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+	}
+	// 每个IP单独计数
+	if w := request("192.0.2.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want 200", w.Code)
+	}
+}
+
+func TestAcquireDownload(t *testing.T) {
+	l := newRateLimiter(LimitSettings{MaxDownloads: 1}, "", nil)
+	release, ok := l.acquireDownload()
+	if !ok {
+		t.Fatal("first download was rejected")
+	}
+	if _, ok := l.acquireDownload(); ok {
+		t.Error("second download was allowed beyond the limit")
+	}
+	release()
+	if _, ok := l.acquireDownload(); !ok {
+		t.Error("slot was not released")
+	}
+
+	if _, ok := newRateLimiter(LimitSettings{}, "", nil).acquireDownload(); !ok {
+		t.Error("unlimited downloads were rejected")
+	}
+}
+
+func TestBandwidthBuckets(t *testing.T) {
+	acl, err := newAccessControl(AccessSettings{TrustedProxies: []string{"192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newRateLimiter(LimitSettings{GlobalBandwidth: 1000, PerIPBandwidth: 1000, PerUserBandwidth: 1000}, "X-Remote-User", acl)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := len(l.bandwidthBuckets(r)); got != 2 {
+		t.Errorf("anonymous request: %d buckets, want global and per-IP", got)
+	}
+	r.Header.Set("X-Remote-User", " alice ")
+	if user := l.requestUser(r); user != "alice" {
+		t.Errorf("requestUser = %q, want alice", user)
+	}
+	if got := len(l.bandwidthBuckets(r)); got != 3 {
+		t.Errorf("user request: %d buckets, want 3", got)
+	}
+
+	// 不是来自受信任代理的请求头被忽略，按客户端IP处理
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "198.51.100.7:1234"
+	r.Header.Set("X-Remote-User", "alice")
+	if user := l.requestUser(r); user != "" {
+		t.Errorf("untrusted client: requestUser = %q, want empty", user)
+	}
+	if got := len(l.bandwidthBuckets(r)); got != 2 {
+		t.Errorf("untrusted client: %d buckets, want global and per-IP", got)
+	}
+
+	// 没有限制时不包装
+	var buf bytes.Buffer
+	if w := newRateLimiter(LimitSettings{}, "", nil).throttleWriter(&buf, r); w != &buf {
+		t.Error("writer was wrapped without bandwidth limits")
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	bucket := newTokenBucket(1<<20, 1<<20)
+	var buf bytes.Buffer
+	w := &throttledWriter{w: &buf, t: &throttle{ctx: context.Background(), buckets: []*tokenBucket{bucket}}}
+	data := bytes.Repeat([]byte("x"), 3*throttleChunk+10)
+	if n, err := w.Write(data); err != nil || n != len(data) || buf.Len() != len(data) {
+		t.Fatalf("Write = %d, %v; buffered %d", n, err, buf.Len())
+	}
+
+	// 需要等待时请求取消则停止写入
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := &throttledWriter{w: &buf, t: &throttle{ctx: ctx, buckets: []*tokenBucket{newTokenBucket(1, 1)}}}
+	if _, err := slow.Write([]byte("more than one token")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write after cancel: err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 扫描结果
+type scanOutcome struct {
+	Status string // clean、infected 或 error
+	Threat string // 发现的威胁名称，出错时为错误信息
+}
+
+// 病毒扫描引擎，name 是文件在存储中的名称
+type scanEngine interface {
+	scan(ctx context.Context, store storage, name string) (scanOutcome, error)
+}
+
+// 通过clamd的 INSTREAM 命令扫描 - 文件内容经套接字发送，clamd不需要能访问共享目录
+type clamdEngine struct {
+	network string // unix 或 tcp
+	address string
+}
+
+// clamd 地址：unix:/run/clamav/clamd.ctl、tcp:127.0.0.1:3310，或直接写 host:port
+func newClamdEngine(addr string) (*clamdEngine, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return &clamdEngine{network: "unix", address: strings.TrimPrefix(addr, "unix:")}, nil
+	case strings.HasPrefix(addr, "tcp:"):
+		return &clamdEngine{network: "tcp", address: strings.TrimPrefix(addr, "tcp:")}, nil
+	case strings.HasPrefix(addr, "/"):
+		return &clamdEngine{network: "unix", address: addr}, nil
+	case addr != "":
+		return &clamdEngine{network: "tcp", address: addr}, nil
+	}
+	return nil, errors.New("未配置clamd地址")
+}
+
+// INSTREAM 每块的大小，需小于clamd的 StreamMaxLength
+const clamdChunkSize = 64 * 1024
+
+func (c *clamdEngine) scan(ctx context.Context, store storage, name string) (scanOutcome, error) {
+	file, err := store.Open(name)
+	if err != nil {
+		return scanOutcome{}, err
+	}
+	defer file.Close()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return scanOutcome{}, fmt.Errorf("连接clamd失败: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// 命令以 "z" 开头、以NUL结尾；内容按块发送，每块前是4字节大端长度，长度为0的块表示结束
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return scanOutcome{}, err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := file.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return scanOutcome{}, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return scanOutcome{}, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return scanOutcome{}, err
+	}
+
+	// 回复同样以NUL结尾
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return scanOutcome{}, err
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// 解析clamd的回复："stream: OK"、"stream: Eicar-Signature FOUND" 或 "... ERROR"
+func parseClamdReply(reply string) (scanOutcome, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return scanOutcome{Status: "clean"}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return scanOutcome{Status: "infected", Threat: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return scanOutcome{}, fmt.Errorf("clamd返回错误: %s", reply)
+}
+
+// 调用外部命令扫描 - 参数中的 {file} 替换为文件路径（没有时追加在最后），
+// 退出码0表示干净、1表示发现威胁（输出的第一行作为威胁名称），其他表示出错，与clamscan一致；
+// 外部命令需要磁盘上的文件，只能用于本地存储
+type commandEngine struct {
+	args []string
+}
+
+func (c *commandEngine) scan(ctx context.Context, store storage, name string) (scanOutcome, error) {
+	path, ok := storageLocalPath(store, name)
+	if !ok {
+		return scanOutcome{}, errors.New("扫描命令只能用于本地存储")
+	}
+	args := make([]string, 0, len(c.args)+1)
+	replaced := false
+	for _, arg := range c.args {
+		if strings.Contains(arg, "{file}") {
+			arg = strings.ReplaceAll(arg, "{file}", path)
+			replaced = true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, path)
+	}
+
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return scanOutcome{Status: "clean"}, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		threat, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		if threat == "" {
+			threat = "unknown"
+		}
+		return scanOutcome{Status: "infected", Threat: threat}, nil
+	}
+	return scanOutcome{}, fmt.Errorf("扫描命令失败: %v: %s", err, strings.TrimSpace(string(output)))
+}
+
+// 一个等待扫描的上传文件
+type scanJob struct {
+	path    string // 文件在存储中的名称
+	user    string // 上传者
+	done    chan struct{}
+	outcome scanOutcome // done 关闭后有效
+}
+
+// 上传文件的病毒扫描 - 上传完成后放入队列，由后台异步扫描，发现威胁的文件移到隔离目录
+type uploadScanner struct {
+	engine            scanEngine
+	store             storage
+	quarantineDir     string
+	quarantineOnError bool
+	timeout           time.Duration
+	wait              time.Duration // 上传请求等待扫描结果的最长时间
+	jobs              chan *scanJob
+	usage             *usageTracker
+
+	mu      sync.Mutex
+	pending map[string]*scanJob    // 文件名称 -> 等待中的扫描
+	blocked map[string]blockedFile // 文件名称 -> 应当隔离但隔离失败的文件，仍然不能下载
+
+	// 文件的扫描状态变化时调用，用于更新正在浏览该目录的页面
+	onChange func(path string)
+}
+
+// 隔离失败的文件，被替换（修改时间或大小变化）或删除后不再拦截
+type blockedFile struct {
+	outcome scanOutcome
+	size    int64
+	modTime time.Time
+}
+
+// 根据配置创建病毒扫描，未启用时返回nil
+func newUploadScanner(settings ScanSettings, store storage, usage *usageTracker) (*uploadScanner, error) {
+	var engine scanEngine
+	switch settings.Mode {
+	case "", "off":
+		return nil, nil
+	case "clamd":
+		clamd, err := newClamdEngine(settings.Clamd)
+		if err != nil {
+			return nil, err
+		}
+		engine = clamd
+	case "command":
+		if len(settings.Command) == 0 {
+			return nil, errors.New("未配置扫描命令")
+		}
+		if !isLocalStorage(store) {
+			return nil, errors.New("扫描命令只能用于本地存储，请改用clamd")
+		}
+		engine = &commandEngine{args: settings.Command}
+	default:
+		return nil, fmt.Errorf("未知的扫描方式: %q", settings.Mode)
+	}
+
+	// 隔离目录是磁盘上的目录，本地存储时必须位于共享目录之外，否则隔离的文件仍然可以下载
+	if settings.QuarantineDir == "" {
+		return nil, errors.New("未配置隔离目录")
+	}
+	quarantineDir, err := filepath.Abs(settings.QuarantineDir)
+	if err != nil {
+		return nil, err
+	}
+	if root, ok := storageLocalPath(store, "."); ok {
+		if rel, err := filepath.Rel(root, quarantineDir); err == nil && !strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("隔离目录 %s 不能位于共享目录中", quarantineDir)
+		}
+	}
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		return nil, err
+	}
+
+	workers := settings.Workers
+	if workers <= 0 {
+		workers = 2
+	}
+	s := &uploadScanner{
+		engine:            engine,
+		store:             store,
+		quarantineDir:     quarantineDir,
+		quarantineOnError: settings.QuarantineOnError,
+		timeout:           time.Duration(settings.Timeout) * time.Second,
+		wait:              time.Duration(settings.Wait) * time.Second,
+		jobs:              make(chan *scanJob, 1024),
+		usage:             usage,
+		pending:           make(map[string]*scanJob),
+		blocked:           make(map[string]blockedFile),
+	}
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	s.loadState()
+	return s, nil
+}
+
+// 把上传完成的文件加入扫描队列
+func (s *uploadScanner) submit(path, user string) *scanJob {
+	job := &scanJob{path: path, user: user, done: make(chan struct{})}
+	s.mu.Lock()
+	s.pending[path] = job
+	delete(s.blocked, path)
+	s.saveState()
+	s.mu.Unlock()
+	s.changed(path)
+
+	select {
+	case s.jobs <- job:
+	default:
+		// 队列已满时不阻塞上传请求，由单独的goroutine等待
+		go func() { s.jobs <- job }()
+	}
+	return job
+}
+
+// 文件本身或目录中是否有等待扫描的文件
+func (s *uploadScanner) scanning(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path := range s.pending {
+		if nameWithin(path, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 文件的扫描状态：等待扫描时为 "pending"，应当隔离但隔离失败时为扫描结果（infected 或 error），否则为空；
+// 不为空时文件不能下载
+func (s *uploadScanner) status(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[path]; ok {
+		return "pending"
+	}
+	if blocked, ok := s.blocked[path]; ok {
+		info, err := s.store.Stat(path)
+		if err == nil && info.Size() == blocked.size && info.ModTime().Equal(blocked.modTime) {
+			return blocked.outcome.Status
+		}
+		delete(s.blocked, path)
+		s.saveState()
+	}
+	return ""
+}
+
+// 等待这些文件的扫描结果，最多等待配置的时间，之后未完成的仍为 pending
+func (s *uploadScanner) await(jobs []*scanJob) {
+	deadline := time.NewTimer(s.wait)
+	defer deadline.Stop()
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+		case <-deadline.C:
+			return
+		}
+	}
+}
+
+func (s *uploadScanner) changed(path string) {
+	if s.onChange != nil {
+		s.onChange(path)
+	}
+}
+
+func (s *uploadScanner) worker() {
+	for job := range s.jobs {
+		s.run(job)
+	}
+}
+
+// 扫描一个文件并按结果处理
+func (s *uploadScanner) run(job *scanJob) {
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	outcome, err := s.engine.scan(ctx, s.store, job.path)
+	if err != nil {
+		log.Printf("病毒扫描失败: %v (文件: %s)", err, job.path)
+		outcome = scanOutcome{Status: "error", Threat: err.Error()}
+	}
+	var quarantineErr error
+	if outcome.Status == "infected" || (outcome.Status == "error" && s.quarantineOnError) {
+		quarantineErr = s.quarantine(job, outcome)
+		switch {
+		case errors.Is(quarantineErr, errQuarantineRecord):
+			// 文件已经移到隔离目录，只是缺少记录，不需要继续拦截
+			log.Printf("文件已隔离，但%v (文件: %s, 扫描结果: %s %s, 上传者: %s)", quarantineErr, job.path, outcome.Status, outcome.Threat, job.user)
+			metrics.quarantineRecordFailed()
+			quarantineErr = nil
+		case quarantineErr != nil:
+			// 文件仍在原处，记下扫描结果继续拦截下载，等待管理员处理
+			log.Printf("隔离文件失败，文件保持不可下载: %v (文件: %s, 扫描结果: %s %s)", quarantineErr, job.path, outcome.Status, outcome.Threat)
+			metrics.quarantineFailed()
+		}
+	}
+	if outcome.Status == "infected" && quarantineErr == nil {
+		log.Printf("发现威胁并已隔离: %s (文件: %s, 上传者: %s)", outcome.Threat, job.path, job.user)
+	}
+	metrics.scanned(outcome.Status)
+
+	s.mu.Lock()
+	if s.pending[job.path] == job {
+		delete(s.pending, job.path)
+		if quarantineErr != nil {
+			s.block(job.path, outcome)
+		}
+		s.saveState()
+	}
+	s.mu.Unlock()
+	job.outcome = outcome
+	close(job.done)
+	s.changed(job.path)
+}
+
+// 拦截隔离失败的文件；文件已经不存在时不需要拦截。调用时需持有锁
+func (s *uploadScanner) block(path string, outcome scanOutcome) {
+	info, err := s.store.Stat(path)
+	if err != nil {
+		return
+	}
+	s.blocked[path] = blockedFile{outcome: outcome, size: info.Size(), modTime: info.ModTime()}
+}
+
+// 在文件列表中标出扫描状态，dir 是列表所在目录在存储中的名称
+func markScanStatus(files []FileInfo, dir string, status func(name string) string) {
+	for i := range files {
+		if !files[i].IsDir {
+			files[i].Scan = status(path.Join(dir, files[i].Name))
+		}
+	}
+}
+
+// 隔离记录，与隔离的文件放在一起
+type quarantineRecord struct {
+	Path   string    `json:"path"`   // 原来在存储中的名称
+	User   string    `json:"user"`   // 上传者
+	Status string    `json:"status"` // infected 或 error
+	Threat string    `json:"threat"`
+	Time   time.Time `json:"time"`
+}
+
+// 文件已经移到隔离目录，但隔离记录写入失败
+var errQuarantineRecord = errors.New("写入隔离记录失败")
+
+// 把文件移到隔离目录，并写入隔离记录；文件移走之后写记录失败时返回包装了 errQuarantineRecord 的错误
+func (s *uploadScanner) quarantine(job *scanJob, outcome scanOutcome) error {
+	name := time.Now().Format("20060102-150405.000000000") + "-" + path.Base(job.path)
+	dest := filepath.Join(s.quarantineDir, name)
+	if err := moveToDisk(s.store, job.path, dest); err != nil {
+		return err
+	}
+	s.usage.recordRemove(job.path)
+
+	record, err := json.MarshalIndent(quarantineRecord{
+		Path:   job.path,
+		User:   job.user,
+		Status: outcome.Status,
+		Threat: outcome.Threat,
+		Time:   time.Now(),
+	}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(dest+".json", record, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errQuarantineRecord, err)
+	}
+	return nil
+}
+
+// 等待扫描和被拦截的文件保存在隔离目录中，重启后继续扫描和拦截
+const scanStateFile = ".scan-state.json"
+
+type scanState struct {
+	Pending []pendingScan `json:"pending"`
+	Blocked []blockedScan `json:"blocked"`
+}
+
+type pendingScan struct {
+	Path string `json:"path"`
+	User string `json:"user"`
+}
+
+type blockedScan struct {
+	Path    string    `json:"path"`
+	Status  string    `json:"status"`
+	Threat  string    `json:"threat"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// 写入扫描状态；调用时需持有锁
+func (s *uploadScanner) saveState() {
+	var state scanState
+	for _, job := range s.pending {
+		state.Pending = append(state.Pending, pendingScan{Path: job.path, User: job.user})
+	}
+	for name, blocked := range s.blocked {
+		state.Blocked = append(state.Blocked, blockedScan{
+			Path:    name,
+			Status:  blocked.outcome.Status,
+			Threat:  blocked.outcome.Threat,
+			Size:    blocked.size,
+			ModTime: blocked.modTime,
+		})
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		// 先写临时文件再重命名，中途出错不会留下不完整的状态
+		tmp := filepath.Join(s.quarantineDir, scanStateFile+".tmp")
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, filepath.Join(s.quarantineDir, scanStateFile))
+		}
+	}
+	if err != nil {
+		log.Printf("保存扫描状态失败: %v", err)
+	}
+}
+
+// 读取上次运行时保存的扫描状态：没有扫描完的文件重新扫描，被拦截的文件继续拦截
+func (s *uploadScanner) loadState() {
+	data, err := os.ReadFile(filepath.Join(s.quarantineDir, scanStateFile))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("读取扫描状态失败: %v", err)
+		}
+		return
+	}
+	var state scanState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("扫描状态文件无效: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	for _, entry := range state.Blocked {
+		s.blocked[entry.Path] = blockedFile{
+			outcome: scanOutcome{Status: entry.Status, Threat: entry.Threat},
+			size:    entry.Size,
+			modTime: entry.ModTime,
+		}
+	}
+	s.mu.Unlock()
+	for _, entry := range state.Pending {
+		if _, err := s.store.Stat(entry.Path); err != nil {
+			continue
+		}
+		s.submit(entry.Path, entry.User)
+	}
+	if len(state.Pending) > 0 {
+		log.Printf("继续扫描上次未完成的 %d 个文件", len(state.Pending))
+	}
+}
+
+// 把存储中的文件移到磁盘上 - 本地存储时先尝试直接重命名，不在同一文件系统或不是本地存储时复制后删除原文件
+func moveToDisk(store storage, name, dest string) error {
+	if src, ok := storageLocalPath(store, name); ok {
+		if err := os.Rename(src, dest); err == nil {
+			return nil
+		}
+	}
+	in, err := store.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	in.Close()
+	return store.Remove(name)
+}
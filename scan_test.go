@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 模拟clamd的 INSTREAM 命令：内容中含 EICAR 时报告威胁，含 BROKEN 时返回错误
+func startFakeClamd(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeClamd(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveFakeClamd(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+	var data bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, reader, int64(size)); err != nil {
+			return
+		}
+	}
+	switch {
+	case bytes.Contains(data.Bytes(), []byte("EICAR")):
+		conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+	case bytes.Contains(data.Bytes(), []byte("BROKEN")):
+		conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+	default:
+		conn.Write([]byte("stream: OK\x00"))
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    scanOutcome
+		wantErr bool
+	}{
+		{"stream: OK", scanOutcome{Status: "clean"}, false},
+		{"stream: Eicar-Test-Signature FOUND", scanOutcome{Status: "infected", Threat: "Eicar-Test-Signature"}, false},
+		{"stream: Win.Test.Multi Word FOUND", scanOutcome{Status: "infected", Threat: "Win.Test.Multi Word"}, false},
+		{"INSTREAM size limit exceeded. ERROR", scanOutcome{}, true},
+		{"", scanOutcome{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseClamdReply(tt.reply)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseClamdReply(%q) error = %v, wantErr %v", tt.reply, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseClamdReply(%q) = %+v, want %+v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestClamdEngine(t *testing.T) {
+	addr := startFakeClamd(t)
+	engine, err := newClamdEngine("tcp:" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemStorage()
+	// 超过一块的内容，确认分块发送
+	writeStoreFile(t, store, "clean.bin", strings.Repeat("x", 3*clamdChunkSize+1))
+	writeStoreFile(t, store, "eicar.txt", "X5O!P%@AP EICAR test")
+	writeStoreFile(t, store, "broken.txt", "BROKEN")
+
+	tests := []struct {
+		name    string
+		want    scanOutcome
+		wantErr bool
+	}{
+		{"clean.bin", scanOutcome{Status: "clean"}, false},
+		{"eicar.txt", scanOutcome{Status: "infected", Threat: "Eicar-Test-Signature"}, false},
+		{"broken.txt", scanOutcome{}, true},
+		{"missing.txt", scanOutcome{}, true},
+	}
+	for _, tt := range tests {
+		got, err := engine.scan(context.Background(), store, tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("scan(%s) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("scan(%s) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewClamdEngine(t *testing.T) {
+	tests := []struct {
+		addr, network, address string
+	}{
+		{"unix:/run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+		{"/run/clamav/clamd.ctl", "unix", "/run/clamav/clamd.ctl"},
+		{"tcp:127.0.0.1:3310", "tcp", "127.0.0.1:3310"},
+		{"127.0.0.1:3310", "tcp", "127.0.0.1:3310"},
+	}
+	for _, tt := range tests {
+		engine, err := newClamdEngine(tt.addr)
+		if err != nil || engine.network != tt.network || engine.address != tt.address {
+			t.Errorf("newClamdEngine(%q) = %+v, %v", tt.addr, engine, err)
+		}
+	}
+	if _, err := newClamdEngine(""); err == nil {
+		t.Error("empty clamd address was accepted")
+	}
+}
+
+func TestCommandEngine(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	// 与clamscan一致：0 干净，1 发现威胁，其他出错
+	engine := &commandEngine{args: []string{"/bin/sh", "-c", `grep -q EICAR "$1" && { echo Eicar-Test; exit 1; }; grep -q BROKEN "$1" && exit 2; exit 0`, "scan", "{file}"}}
+	store := newLocalStorage(t.TempDir())
+	writeStoreFile(t, store, "clean.txt", "hello")
+	writeStoreFile(t, store, "eicar.txt", "EICAR")
+	writeStoreFile(t, store, "broken.txt", "BROKEN")
+
+	if got, err := engine.scan(context.Background(), store, "clean.txt"); err != nil || got.Status != "clean" {
+		t.Errorf("clean: %+v, %v", got, err)
+	}
+	if got, err := engine.scan(context.Background(), store, "eicar.txt"); err != nil || got != (scanOutcome{Status: "infected", Threat: "Eicar-Test"}) {
+		t.Errorf("infected: %+v, %v", got, err)
+	}
+	if _, err := engine.scan(context.Background(), store, "broken.txt"); err == nil {
+		t.Error("exit status 2 was not reported as an error")
+	}
+	if _, err := engine.scan(context.Background(), newMemStorage(), "clean.txt"); err == nil {
+		t.Error("command engine accepted a storage without local files")
+	}
+}
+
+// 用模拟的clamd创建扫描器，返回扫描器和隔离目录
+func newTestScanner(t *testing.T, store storage, quarantineOnError bool) (*uploadScanner, string) {
+	t.Helper()
+	usage, err := newUsageTracker(store, nil, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+	scanner, err := newUploadScanner(ScanSettings{
+		Mode:              "clamd",
+		Clamd:             startFakeClamd(t),
+		QuarantineDir:     quarantineDir,
+		QuarantineOnError: quarantineOnError,
+		Timeout:           5,
+		Wait:              5,
+		Workers:           1,
+	}, store, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scanner, quarantineDir
+}
+
+// 提交扫描并等待结果
+func scanAndWait(t *testing.T, scanner *uploadScanner, name string) scanOutcome {
+	t.Helper()
+	job := scanner.submit(name, "tester")
+	select {
+	case <-job.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("scan of %s did not finish", name)
+	}
+	return job.outcome
+}
+
+func TestUploadScannerClean(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "clean.txt", "hello")
+	scanner, _ := newTestScanner(t, store, false)
+
+	if outcome := scanAndWait(t, scanner, "clean.txt"); outcome.Status != "clean" {
+		t.Fatalf("outcome = %+v, want clean", outcome)
+	}
+	if status := scanner.status("clean.txt"); status != "" {
+		t.Errorf("status = %q, want empty", status)
+	}
+	if _, err := store.Stat("clean.txt"); err != nil {
+		t.Errorf("clean file was removed: %v", err)
+	}
+}
+
+func TestUploadScannerQuarantine(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "eicar.txt", "EICAR")
+	scanner, quarantineDir := newTestScanner(t, store, false)
+
+	outcome := scanAndWait(t, scanner, "eicar.txt")
+	if outcome.Status != "infected" || outcome.Threat != "Eicar-Test-Signature" {
+		t.Fatalf("outcome = %+v, want infected", outcome)
+	}
+	if _, err := store.Stat("eicar.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("infected file still in storage: %v", err)
+	}
+	if status := scanner.status("eicar.txt"); status != "" {
+		t.Errorf("status = %q, want empty after quarantine", status)
+	}
+
+	// 隔离目录中是文件内容和隔离记录
+	records, _ := filepath.Glob(filepath.Join(quarantineDir, "*-eicar.txt.json"))
+	if len(records) != 1 {
+		t.Fatalf("quarantine records = %v, want 1", records)
+	}
+	data, err := os.ReadFile(strings.TrimSuffix(records[0], ".json"))
+	if err != nil || string(data) != "EICAR" {
+		t.Errorf("quarantined content = %q, %v", data, err)
+	}
+	var record quarantineRecord
+	raw, _ := os.ReadFile(records[0])
+	if err := json.Unmarshal(raw, &record); err != nil || record.Path != "eicar.txt" || record.Threat != "Eicar-Test-Signature" || record.User != "tester" {
+		t.Errorf("quarantine record = %+v, %v", record, err)
+	}
+}
+
+func TestUploadScannerError(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "a.txt", "BROKEN")
+	writeStoreFile(t, store, "b.txt", "BROKEN")
+
+	// 默认扫描出错时保留文件
+	scanner, _ := newTestScanner(t, store, false)
+	if outcome := scanAndWait(t, scanner, "a.txt"); outcome.Status != "error" {
+		t.Fatalf("outcome = %+v, want error", outcome)
+	}
+	if _, err := store.Stat("a.txt"); err != nil {
+		t.Errorf("file was removed after scan error: %v", err)
+	}
+
+	// quarantine_on_error 时同样隔离
+	scanner, _ = newTestScanner(t, store, true)
+	if outcome := scanAndWait(t, scanner, "b.txt"); outcome.Status != "error" {
+		t.Fatalf("outcome = %+v, want error", outcome)
+	}
+	if _, err := store.Stat("b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file was not quarantined after scan error: %v", err)
+	}
+}
+
+func TestUploadScannerQuarantineFailure(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "eicar.txt", "EICAR")
+	settings := defaultSettings()
+	settings.Trash.Disabled = true
+	settings.Versions.Disabled = true
+	settings.Duplicates.Disabled = true
+	config := newTestConfig(t, settings, store)
+	scanner, quarantineDir := newTestScanner(t, store, false)
+	config.scanner = scanner
+
+	// 隔离目录不可用时文件无法移走
+	if err := os.RemoveAll(quarantineDir); err != nil {
+		t.Fatal(err)
+	}
+	if outcome := scanAndWait(t, scanner, "eicar.txt"); outcome.Status != "infected" {
+		t.Fatalf("outcome = %+v, want infected", outcome)
+	}
+	if _, err := store.Stat("eicar.txt"); err != nil {
+		t.Fatalf("file should stay in place when quarantine fails: %v", err)
+	}
+	if status := scanner.status("eicar.txt"); status != "infected" {
+		t.Errorf("status = %q, want infected", status)
+	}
+	w := serve(config, httptest.NewRequest(http.MethodGet, "/download/eicar.txt", nil))
+	if w.Code != http.StatusForbidden || w.Header().Get("X-Error-Code") != "file_blocked" {
+		t.Errorf("download: status = %d, code = %q, want 403 file_blocked", w.Code, w.Header().Get("X-Error-Code"))
+	}
+
+	// 文件被替换后不再拦截
+	writeStoreFile(t, store, "eicar.txt", "replaced content")
+	if status := scanner.status("eicar.txt"); status != "" {
+		t.Errorf("status after replace = %q, want empty", status)
+	}
+}
+
+func TestUploadScannerRecordFailure(t *testing.T) {
+	// 隔离后的文件名正好达到长度上限，加上 .json 的记录文件无法创建
+	name := strings.Repeat("a", 255-len("20060102-150405.000000000-")-len(".txt")) + ".txt"
+	store := newMemStorage()
+	writeStoreFile(t, store, name, "EICAR")
+	scanner, quarantineDir := newTestScanner(t, store, false)
+	metrics.mu.Lock()
+	before := metrics.recordFails
+	metrics.mu.Unlock()
+
+	if outcome := scanAndWait(t, scanner, name); outcome.Status != "infected" {
+		t.Fatalf("outcome = %+v, want infected", outcome)
+	}
+	// 文件已经隔离，不再拦截，单独计入记录写入失败
+	if _, err := store.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("infected file still in storage: %v", err)
+	}
+	if status := scanner.status(name); status != "" {
+		t.Errorf("status = %q, want empty", status)
+	}
+	if files, _ := filepath.Glob(filepath.Join(quarantineDir, "*-"+name)); len(files) != 1 {
+		t.Errorf("quarantined files = %v, want 1", files)
+	}
+	metrics.mu.Lock()
+	after := metrics.recordFails
+	metrics.mu.Unlock()
+	if after != before+1 {
+		t.Errorf("record failures = %d, want %d", after, before+1)
+	}
+}
+
+func TestUploadScannerState(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "clean.txt", "hello")
+	writeStoreFile(t, store, "eicar.txt", "EICAR")
+	usage, _ := newUsageTracker(store, nil, "", time.Hour)
+	settings := ScanSettings{Mode: "clamd", Clamd: startFakeClamd(t), QuarantineDir: t.TempDir(), Timeout: 5, Wait: 5, Workers: 1}
+	scanner, err := newUploadScanner(settings, store, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟重启前还在等待扫描的文件和隔离失败被拦截的文件
+	scanner.mu.Lock()
+	scanner.pending["clean.txt"] = &scanJob{path: "clean.txt", user: "tester", done: make(chan struct{})}
+	scanner.block("eicar.txt", scanOutcome{Status: "infected", Threat: "Eicar-Test-Signature"})
+	scanner.saveState()
+	scanner.mu.Unlock()
+
+	restarted, err := newUploadScanner(settings, store, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := restarted.status("eicar.txt"); status != "infected" {
+		t.Errorf("blocked file after restart: status = %q, want infected", status)
+	}
+	// 没有扫描完的文件重新扫描
+	deadline := time.Now().Add(5 * time.Second)
+	for restarted.status("clean.txt") == "pending" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := restarted.status("clean.txt"); status != "" {
+		t.Errorf("pending file after restart: status = %q, want scanned", status)
+	}
+
+	// 扫描完成后不再保存为等待扫描
+	data, err := os.ReadFile(filepath.Join(settings.QuarantineDir, scanStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state scanState
+	if err := json.Unmarshal(data, &state); err != nil || len(state.Pending) != 0 || len(state.Blocked) != 1 {
+		t.Errorf("saved state = %s, %v", data, err)
+	}
+}
+
+func TestNewUploadScannerSettings(t *testing.T) {
+	root := t.TempDir()
+	var store storage = newLocalStorage(root)
+	usage, _ := newUsageTracker(store, nil, "", time.Hour)
+	tests := []struct {
+		name     string
+		settings ScanSettings
+		wantErr  bool
+	}{
+		{"unknown mode", ScanSettings{Mode: "magic", QuarantineDir: t.TempDir()}, true},
+		{"command without arguments", ScanSettings{Mode: "command", QuarantineDir: t.TempDir()}, true},
+		{"no quarantine directory", ScanSettings{Mode: "clamd", Clamd: "127.0.0.1:3310"}, true},
+		{"quarantine inside the share", ScanSettings{Mode: "clamd", Clamd: "127.0.0.1:3310", QuarantineDir: filepath.Join(root, "q")}, true},
+		{"command on memory storage", ScanSettings{Mode: "command", Command: []string{"clamscan"}, QuarantineDir: t.TempDir()}, true},
+		{"valid", ScanSettings{Mode: "command", Command: []string{"clamscan"}, QuarantineDir: t.TempDir()}, false},
+	}
+	for _, tt := range tests {
+		s := store
+		if strings.Contains(tt.name, "memory") {
+			s = newMemStorage()
+		}
+		if _, err := newUploadScanner(tt.settings, s, usage); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if s, err := newUploadScanner(ScanSettings{Mode: "off"}, store, usage); s != nil || err != nil {
+		t.Errorf("off mode = %v, %v, want nil scanner", s, err)
+	}
+}
+
+func TestUploadScanResults(t *testing.T) {
+	settings := defaultSettings()
+	settings.Scan = ScanSettings{Mode: "clamd", Clamd: startFakeClamd(t), QuarantineDir: filepath.Join(t.TempDir(), "q"), Timeout: 5, Wait: 5, Workers: 1}
+	config := newTestConfig(t, settings, nil)
+
+	w := serve(config, newUploadRequest(t, "/upload/", map[string]string{"clean.txt": "hello", "eicar.txt": "EICAR"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	result, files := decodeUploadResult(t, w)
+	if result.Succeeded != 1 || result.Failed != 1 {
+		t.Errorf("result = %+v", result)
+	}
+	if f := files["clean.txt"]; f.Status != "ok" || f.Scan != "clean" {
+		t.Errorf("clean file = %+v", f)
+	}
+	if f := files["eicar.txt"]; f.Status != "failed" || f.Scan != "infected" || f.Error != "file_infected" || f.Threat != "Eicar-Test-Signature" {
+		t.Errorf("infected file = %+v", f)
+	}
+	if _, err := config.store.Stat("eicar.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("infected upload was not quarantined")
+	}
+}
+
+func TestDownloadPendingScan(t *testing.T) {
+	settings := defaultSettings()
+	settings.Scan = ScanSettings{Mode: "command", Command: []string{"true"}, QuarantineDir: filepath.Join(t.TempDir(), "q")}
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "new.txt", "new")
+
+	// 直接登记为等待扫描，不放入队列
+	config.scanner.mu.Lock()
+	config.scanner.pending["new.txt"] = &scanJob{path: "new.txt", done: make(chan struct{})}
+	config.scanner.mu.Unlock()
+
+	w := serve(config, httptest.NewRequest(http.MethodGet, "/download/new.txt", nil))
+	if w.Code != http.StatusLocked || w.Header().Get("X-Error-Code") != "scan_pending" {
+		t.Errorf("download: status = %d, X-Error-Code = %q, want 423 scan_pending", w.Code, w.Header().Get("X-Error-Code"))
+	}
+	files := []FileInfo{{Name: "new.txt"}, {Name: "other.txt"}, {Name: "dir", IsDir: true}}
+	markScanStatus(files, ".", config.scanner.status)
+	if files[0].Scan != "pending" || files[1].Scan != "" || files[2].Scan != "" {
+		t.Errorf("marked files = %+v", files)
+	}
+}
+
+func TestUploadRejectsOverwriteWhileScanning(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "a.txt", "original")
+	config := newTestConfig(t, nil, store)
+	scanner, _ := newTestScanner(t, store, false)
+	config.scanner = scanner
+
+	// 模拟还在队列中的扫描
+	scanner.mu.Lock()
+	scanner.pending["a.txt"] = &scanJob{path: "a.txt", done: make(chan struct{})}
+	scanner.mu.Unlock()
+
+	w := serve(config, newUploadRequest(t, "/upload/", map[string]string{"a.txt": "replaced"}))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	var result uploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || len(result.Files) != 1 || result.Files[0].Error != "scan_in_progress" {
+		t.Errorf("result = %+v, %v", result, err)
+	}
+	if data, err := fs.ReadFile(store, "a.txt"); err != nil || string(data) != "original" {
+		t.Errorf("a.txt = %q, %v, want original content", data, err)
+	}
+}
+
+func TestVersionDownloadScanned(t *testing.T) {
+	store := newMemStorage()
+	// 启用扫描之前已有的文件，没有扫描过
+	writeStoreFile(t, store, "clean.txt", "old clean")
+	writeStoreFile(t, store, "eicar.txt", "old EICAR")
+	settings := defaultSettings()
+	settings.Trash.Disabled = true
+	settings.Duplicates.Disabled = true
+	config := newTestConfig(t, settings, store)
+	scanner, _ := newTestScanner(t, store, false)
+	config.scanner = scanner
+
+	download := func(name string) *httptest.ResponseRecorder {
+		w := serve(config, newUploadRequest(t, "/upload/", map[string]string{name: "new content"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("overwrite %s: status = %d: %s", name, w.Code, w.Body)
+		}
+		versions := config.versions.list(name)
+		if len(versions) != 1 {
+			t.Fatalf("%s: versions = %+v, want 1", name, versions)
+		}
+		return serve(config, httptest.NewRequest(http.MethodGet, "/versions/"+name+"?download="+versions[0].ID, nil))
+	}
+
+	if w := download("clean.txt"); w.Code != http.StatusOK || w.Body.String() != "old clean" {
+		t.Errorf("clean version: status = %d, body = %q", w.Code, w.Body)
+	}
+	if w := download("eicar.txt"); w.Code != http.StatusForbidden || w.Header().Get("X-Error-Code") != "file_blocked" {
+		t.Errorf("infected version: status = %d, code = %q, want 403 file_blocked", w.Code, w.Header().Get("X-Error-Code"))
+	}
+
+	// 扫描结果记入元数据
+	deadline := time.Now().Add(5 * time.Second)
+	for _, tt := range []struct{ name, want string }{{"clean.txt", "clean"}, {"eicar.txt", "infected"}} {
+		for config.versions.list(tt.name)[0].Scan != tt.want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := config.versions.list(tt.name)[0].Scan; got != tt.want {
+			t.Errorf("%s: version scan = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
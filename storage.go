@@ -0,0 +1,210 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+// 存储后端 - 处理器通过它读写共享目录中的文件。名称是共享目录中的相对路径，
+// 规则与 io/fs 相同：使用正斜杠，不以斜杠开头或结尾，根目录为 "."
+type storage interface {
+	fs.StatFS    // Open 打开文件或目录用于读取，Stat 获取条目信息
+	fs.ReadDirFS // ReadDir 列出目录中的条目，按名称排序
+
+	Lstat(name string) (fs.FileInfo, error)  // 获取条目信息，不跟随符号链接
+	Create(name string) (storageFile, error) // 创建文件用于写入，已存在时清空
+	Mkdir(name string) error                 // 创建目录，上级目录必须已存在
+	Rename(oldName, newName string) error    // 移动文件或目录，目标已存在的文件被替换
+	Remove(name string) error                // 删除文件或整个目录，不存在时不报错
+}
+
+// 写入中的文件
+type storageFile interface {
+	io.WriteCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// 只读存储不支持写入
+var errReadOnlyStorage = errors.New("存储为只读")
+
+// 根据配置创建存储后端
+func newStorage(settings StorageSettings, absShareDir string) (storage, error) {
+	switch settings.Type {
+	case "", "local":
+		if _, err := os.Stat(absShareDir); os.IsNotExist(err) {
+			return nil, fmt.Errorf("共享目录 %s 不存在", absShareDir)
+		}
+		return newLocalStorage(absShareDir), nil
+	case "memory":
+		return newMemStorage(), nil
+	case "zip":
+		reader, err := zip.OpenReader(settings.Path)
+		if err != nil {
+			return nil, fmt.Errorf("打开 %s 失败: %v", settings.Path, err)
+		}
+		return newFSStorage(reader), nil
+	default:
+		return nil, fmt.Errorf("无效的 storage.type: %s", settings.Type)
+	}
+}
+
+// 是否为本地磁盘上的存储：硬链接、系统目录监视（inotify）和外部扫描命令需要磁盘上的文件
+func isLocalStorage(store storage) bool {
+	_, ok := store.(*localStorage)
+	return ok
+}
+
+// 名称对应的本地路径，不是本地存储时返回false
+func storageLocalPath(store storage, name string) (string, bool) {
+	local, ok := store.(*localStorage)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(local.root, filepath.FromSlash(name)), true
+}
+
+// 创建目录及其所有上级目录，已存在时不报错
+func storageMkdirAll(store storage, name string) error {
+	if name == "." {
+		return nil
+	}
+	if info, err := store.Stat(name); err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if err := storageMkdirAll(store, path.Dir(name)); err != nil {
+		return err
+	}
+	if err := store.Mkdir(name); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// 把数据写入文件，已存在时替换
+func storageWriteFile(store storage, name string, data []byte) error {
+	file, err := store.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 条目是否存在；出错时返回错误，以免把无法访问的条目当作不存在
+func storageExists(store storage, name string) (bool, error) {
+	_, err := store.Lstat(name)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+// 是否为只读存储
+func isReadOnlyStorage(store storage) bool {
+	_, ok := store.(*fsStorage)
+	return ok
+}
+
+// 本地磁盘上的存储，名称对应共享目录中的文件
+type localStorage struct {
+	root string // 共享目录的绝对路径
+}
+
+func newLocalStorage(root string) *localStorage {
+	return &localStorage{root: root}
+}
+
+// 名称对应的本地路径，名称无效时返回错误
+func (s *localStorage) localPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name)), nil
+}
+
+func (s *localStorage) Open(name string) (fs.File, error) {
+	p, err := s.localPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *localStorage) Stat(name string) (fs.FileInfo, error) {
+	p, err := s.localPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (s *localStorage) Lstat(name string) (fs.FileInfo, error) {
+	p, err := s.localPath("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+func (s *localStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := s.localPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (s *localStorage) Create(name string) (storageFile, error) {
+	p, err := s.localPath("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+func (s *localStorage) Mkdir(name string) error {
+	p, err := s.localPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, 0755)
+}
+
+func (s *localStorage) Rename(oldName, newName string) error {
+	oldPath, err := s.localPath("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := s.localPath("rename", newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (s *localStorage) Remove(name string) error {
+	p, err := s.localPath("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.RemoveAll(p)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/fs"
+)
+
+// 只读存储 - 通过 io/fs.FS 提供文件（如zip文件、embed.FS），所有写入操作返回 errReadOnlyStorage
+type fsStorage struct {
+	fsys fs.FS
+}
+
+func newFSStorage(fsys fs.FS) *fsStorage {
+	return &fsStorage{fsys: fsys}
+}
+
+func (s *fsStorage) Open(name string) (fs.File, error) {
+	return s.fsys.Open(name)
+}
+
+func (s *fsStorage) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(s.fsys, name)
+}
+
+// io/fs 不区分符号链接，与 Stat 相同
+func (s *fsStorage) Lstat(name string) (fs.FileInfo, error) {
+	return fs.Stat(s.fsys, name)
+}
+
+func (s *fsStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(s.fsys, name)
+}
+
+func (s *fsStorage) Create(name string) (storageFile, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: errReadOnlyStorage}
+}
+
+func (s *fsStorage) Mkdir(name string) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: errReadOnlyStorage}
+}
+
+func (s *fsStorage) Rename(oldName, newName string) error {
+	return &fs.PathError{Op: "rename", Path: oldName, Err: errReadOnlyStorage}
+}
+
+func (s *fsStorage) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: errReadOnlyStorage}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内存中的存储 - 用于测试和临时分享，重启后清空
+type memStorage struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode // 名称 -> 条目，包含根目录 "."
+}
+
+// 内存中的文件或目录；文件内容只会追加或整体替换，已打开的读取者不受之后写入的影响
+type memNode struct {
+	data    []byte
+	modTime time.Time
+	dir     bool
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{nodes: map[string]*memNode{".": {dir: true, modTime: time.Now()}}}
+}
+
+// 条目信息
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
+func (fi *memFileInfo) Sys() any           { return nil }
+
+func (fi *memFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// 生成条目信息，调用时需持有锁
+func (n *memNode) info(name string) *memFileInfo {
+	return &memFileInfo{name: path.Base(name), size: int64(len(n.data)), modTime: n.modTime, dir: n.dir}
+}
+
+// 查找条目，调用时需持有锁
+func (s *memStorage) lookup(op, name string) (*memNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node, ok := s.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+// 检查新条目的名称并确认上级目录存在，调用时需持有锁
+func (s *memStorage) checkParent(op, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	parent, ok := s.nodes[path.Dir(name)]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.dir {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// 目录中的条目，按名称排序，调用时需持有锁
+func (s *memStorage) children(name string) []fs.DirEntry {
+	var entries []fs.DirEntry
+	for key, node := range s.nodes {
+		if key != "." && path.Dir(key) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(node.info(key)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+func (s *memStorage) Open(name string) (fs.File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	node, err := s.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.dir {
+		return &memDir{info: node.info(name), entries: s.children(name)}, nil
+	}
+	return &memReader{info: node.info(name), Reader: bytes.NewReader(node.data)}, nil
+}
+
+func (s *memStorage) Stat(name string) (fs.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	node, err := s.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+// 内存存储中没有符号链接，与 Stat 相同
+func (s *memStorage) Lstat(name string) (fs.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s *memStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	node, err := s.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return s.children(name), nil
+}
+
+func (s *memStorage) Create(name string) (storageFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkParent("create", name); err != nil {
+		return nil, err
+	}
+	if node, ok := s.nodes[name]; ok && node.dir {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	node := &memNode{modTime: time.Now()}
+	s.nodes[name] = node
+	return &memWriter{s: s, name: name, node: node}, nil
+}
+
+func (s *memStorage) Mkdir(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	if _, ok := s.nodes[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	s.nodes[name] = &memNode{dir: true, modTime: time.Now()}
+	return nil
+}
+
+func (s *memStorage) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, err := s.lookup("rename", oldName)
+	if err != nil {
+		return err
+	}
+	if err := s.checkParent("rename", newName); err != nil {
+		return err
+	}
+	if oldName == "." || newName == oldName || strings.HasPrefix(newName, oldName+"/") {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrInvalid}
+	}
+	if target, ok := s.nodes[newName]; ok && (target.dir || node.dir) {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrExist}
+	}
+
+	// 目录连同其中的所有条目一起移动
+	s.nodes[newName] = node
+	delete(s.nodes, oldName)
+	if node.dir {
+		for key, child := range s.nodes {
+			if rest, ok := strings.CutPrefix(key, oldName+"/"); ok {
+				s.nodes[newName+"/"+rest] = child
+				delete(s.nodes, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	delete(s.nodes, name)
+	for key := range s.nodes {
+		if strings.HasPrefix(key, name+"/") {
+			delete(s.nodes, key)
+		}
+	}
+	return nil
+}
+
+// 打开读取的文件
+type memReader struct {
+	*bytes.Reader
+	info *memFileInfo
+}
+
+func (f *memReader) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memReader) Close() error               { return nil }
+
+// 打开读取的目录，条目为打开时的快照
+type memDir struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// 写入中的文件，内容直接追加到条目中
+type memWriter struct {
+	s      *memStorage
+	name   string
+	node   *memNode
+	closed bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	w.node.data = append(w.node.data, p...)
+	w.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (w *memWriter) Stat() (fs.FileInfo, error) {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+	return w.node.info(w.name), nil
+}
+
+func (w *memWriter) Close() error {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	w.closed = true
+	return nil
+}
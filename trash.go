@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 回收站目录名，位于共享目录的根目录下，不能浏览、下载或上传
+const trashDirName = ".trash"
+
+// 共享目录根目录下的内部目录，对浏览、下载和上传不可见
+var internalDirs = map[string]bool{
+	trashDirName:    true,
+	versionsDirName: true,
+}
+
+// 路径（共享目录中的相对路径，正斜杠）是否位于内部目录中
+func isInternalPath(cleanedURLPath string) bool {
+	first, _, _ := strings.Cut(strings.TrimPrefix(cleanedURLPath, "/"), "/")
+	return internalDirs[first]
+}
+
+// 回收站和历史版本中一项的ID是否有效：ID由时间和随机后缀组成，只包含数字、字母和 "-"，
+// 拼接存储中的名称之前检查，防止拼出所在目录之外的路径
+func validItemID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// 回收站中的一项，元数据保存在 .trash/<id>.json，内容保存在 .trash/<id>
+type trashItem struct {
+	ID      string    `json:"id"`
+	Path    string    `json:"path"`   // 原来在共享目录中的相对路径（正斜杠）
+	Size    int64     `json:"size"`   // 文件大小，目录为其中所有文件的总大小
+	IsDir   bool      `json:"is_dir"` // 是否为目录
+	Deleted time.Time `json:"deleted"`
+	User    string    `json:"user"`   // 删除或覆盖该文件的用户
+	Reason  string    `json:"reason"` // delete 或 overwrite
+}
+
+// 回收站操作的错误
+var (
+	errTrashNotFound  = errors.New("trash_not_found")
+	errTrashForbidden = errors.New("forbidden_path") // 恢复的位置被隐藏规则或符号链接策略禁止
+)
+
+// 回收站 - 删除和被覆盖的文件移到这里，可以恢复或彻底删除，过期或超出大小上限时自动清理
+type trashBin struct {
+	mu      sync.Mutex
+	store   storage
+	maxAge  time.Duration
+	maxSize int64
+	usage   *usageTracker
+	paths   *pathPolicy
+}
+
+// 根据配置创建回收站，关闭时返回nil
+func newTrashBin(settings TrashSettings, store storage, usage *usageTracker, paths *pathPolicy) (*trashBin, error) {
+	if settings.Disabled || isReadOnlyStorage(store) {
+		return nil, nil
+	}
+	t := &trashBin{
+		store:   store,
+		maxAge:  time.Duration(settings.ExpireDays) * 24 * time.Hour,
+		maxSize: settings.MaxSize,
+		usage:   usage,
+		paths:   paths,
+	}
+	if err := storageMkdirAll(store, trashDirName); err != nil {
+		return nil, err
+	}
+	go t.expireLoop()
+	return t, nil
+}
+
+// 把文件或目录移到回收站，name 是它在存储中的名称
+func (t *trashBin) put(name, user, reason string) (trashItem, error) {
+	info, err := t.store.Lstat(name)
+	if err != nil {
+		return trashItem{}, err
+	}
+	item := trashItem{
+		Path:    name,
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		Deleted: time.Now(),
+		User:    user,
+		Reason:  reason,
+	}
+	if info.IsDir() {
+		usage, err := computeDirUsage(t.store, name, nil)
+		if err != nil {
+			return trashItem{}, err
+		}
+		item.Size = usage.Size
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return trashItem{}, err
+	}
+	item.ID = item.Deleted.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.writeMeta(item); err != nil {
+		return trashItem{}, err
+	}
+	if err := t.store.Rename(name, t.contentPath(item.ID)); err != nil {
+		t.store.Remove(t.metaPath(item.ID))
+		return trashItem{}, err
+	}
+	t.usage.recordRemove(item.Path)
+	// 刚放入的项不参与清理，即使它本身超出大小上限，否则删除或覆盖的文件会直接消失
+	t.expire(time.Now(), item.ID)
+	return item, nil
+}
+
+func (t *trashBin) metaPath(id string) string {
+	return path.Join(trashDirName, id+".json")
+}
+
+func (t *trashBin) contentPath(id string) string {
+	return path.Join(trashDirName, id)
+}
+
+// 写入元数据；调用时需持有锁
+func (t *trashBin) writeMeta(item trashItem) error {
+	data, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storageWriteFile(t.store, t.metaPath(item.ID), data)
+}
+
+// 回收站中的所有项，从新到旧；调用时需持有锁
+func (t *trashBin) items() ([]trashItem, error) {
+	matches, err := fs.Glob(t.store, path.Join(trashDirName, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]trashItem, 0, len(matches))
+	for _, match := range matches {
+		data, err := fs.ReadFile(t.store, match)
+		if err != nil {
+			continue
+		}
+		var item trashItem
+		if err := json.Unmarshal(data, &item); err != nil || item.ID == "" {
+			log.Printf("回收站元数据无效: %s", match)
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Deleted.After(items[j].Deleted) })
+	return items, nil
+}
+
+// 列出回收站中的所有项，从新到旧
+func (t *trashBin) list() ([]trashItem, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.items()
+}
+
+// 查找一项；调用时需持有锁
+func (t *trashBin) find(id string) (trashItem, error) {
+	if !validItemID(id) {
+		return trashItem{}, errTrashNotFound
+	}
+	data, err := fs.ReadFile(t.store, t.metaPath(id))
+	if err != nil {
+		return trashItem{}, errTrashNotFound
+	}
+	var item trashItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return trashItem{}, err
+	}
+	return item, nil
+}
+
+// 恢复到原来的位置，原位置已有同名文件时在名称后加上序号；返回恢复后的相对路径
+func (t *trashBin) restore(id string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	item, err := t.find(id)
+	if err != nil {
+		return "", err
+	}
+
+	// 元数据中的路径同样经过清理，不能指向共享目录之外或内部目录
+	original := strings.TrimPrefix(path.Clean("/"+item.Path), "/")
+	if original == "" || isInternalPath(original) {
+		return "", fmt.Errorf("无效的恢复路径: %s", item.Path)
+	}
+	// 与上传一样按隐藏规则和符号链接策略检查恢复的位置，上级目录是符号链接时不能写到它指向的地方
+	target := original
+	if err := t.checkTarget(target); err != nil {
+		return "", err
+	}
+	if err := storageMkdirAll(t.store, path.Dir(target)); err != nil {
+		return "", err
+	}
+	ext := path.Ext(original)
+	for i := 1; ; i++ {
+		_, err := t.store.Lstat(target)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		target = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(original, ext), i, ext)
+		if err := t.checkTarget(target); err != nil {
+			return "", err
+		}
+	}
+	if err := t.store.Rename(t.contentPath(item.ID), target); err != nil {
+		return "", err
+	}
+	t.store.Remove(t.metaPath(item.ID))
+	return target, nil
+}
+
+// 按路径策略检查恢复的位置（存储中的名称）
+func (t *trashBin) checkTarget(name string) error {
+	if _, _, err := t.paths.validate((&url.URL{Path: name}).EscapedPath()); err != nil {
+		return fmt.Errorf("%w: %v (%s)", errTrashForbidden, err, name)
+	}
+	return nil
+}
+
+// 把刚被覆盖的文件放回原处，用于覆盖它的上传失败时
+func (t *trashBin) putBack(id, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.store.Rename(t.contentPath(id), name); err != nil {
+		log.Printf("恢复被覆盖的文件失败: %v (文件: %s)", err, name)
+		return
+	}
+	t.store.Remove(t.metaPath(id))
+}
+
+// 彻底删除一项
+func (t *trashBin) purge(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	item, err := t.find(id)
+	if err != nil {
+		return err
+	}
+	return t.remove(item)
+}
+
+// 清空回收站
+func (t *trashBin) purgeAll() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	items, err := t.items()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := t.remove(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 删除一项的内容和元数据；调用时需持有锁
+func (t *trashBin) remove(item trashItem) error {
+	if err := t.store.Remove(t.contentPath(item.ID)); err != nil {
+		return err
+	}
+	return t.store.Remove(t.metaPath(item.ID))
+}
+
+// 回收站中所有项的总大小
+func totalTrashSize(items []trashItem) int64 {
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	return total
+}
+
+// 删除过期的项，总大小超出上限时从最旧的开始删除，keep 指定的项除外；调用时需持有锁
+func (t *trashBin) expire(now time.Time, keep string) {
+	items, err := t.items()
+	if err != nil {
+		log.Printf("读取回收站失败: %v", err)
+		return
+	}
+	total := totalTrashSize(items)
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.ID == keep {
+			continue
+		}
+		expired := t.maxAge > 0 && now.Sub(item.Deleted) > t.maxAge
+		if !expired && (t.maxSize <= 0 || total <= t.maxSize) {
+			continue
+		}
+		if err := t.remove(item); err != nil {
+			log.Printf("清理回收站失败: %v (项: %s)", err, item.ID)
+			continue
+		}
+		total -= item.Size
+		log.Printf("回收站自动清理: %s (%s)", item.Path, item.ID)
+	}
+}
+
+// 每小时清理一次过期的项
+func (t *trashBin) expireLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		t.mu.Lock()
+		t.expire(now, "")
+		t.mu.Unlock()
+	}
+}
+
+// 回收站页面中的一项
+type trashView struct {
+	trashItem
+	SizeText    string
+	DeletedText string
+}
+
+// 处理回收站请求：
+// GET /trash/ 列出回收站，POST /trash/<id>/restore 恢复，POST /trash/<id>/purge 或 DELETE /trash/<id> 彻底删除，
+// POST /trash/purge 或 DELETE /trash/ 清空回收站；页面提交的表单完成后返回回收站页面，其他请求返回JSON
+func handleTrash(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	trash := config.trash
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/trash/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	if r.Method == http.MethodPost && action == "" && id == "purge" {
+		id, action = "", "purge"
+	}
+	if r.Method == http.MethodDelete && action == "" {
+		action = "purge"
+	}
+
+	switch {
+	case r.Method == http.MethodGet && rest == "":
+		items, err := trash.list()
+		if err != nil {
+			log.Printf("读取回收站失败: %v", err)
+			httpError(w, r, http.StatusInternalServerError, "internal")
+			return
+		}
+		if errorFormat(r) == "json" {
+			writeJSON(w, http.StatusOK, items)
+			return
+		}
+		renderTrashPage(w, r, config, items)
+
+	case (r.Method == http.MethodPost || r.Method == http.MethodDelete) && (action == "restore" || action == "purge"):
+		var restored string
+		var err error
+		switch {
+		case action == "restore":
+			restored, err = trash.restore(id)
+		case id == "":
+			err = trash.purgeAll()
+		default:
+			err = trash.purge(id)
+		}
+		if errors.Is(err, errTrashNotFound) {
+			httpError(w, r, http.StatusNotFound, "trash_not_found")
+			return
+		}
+		if errors.Is(err, errTrashForbidden) {
+			log.Printf("拒绝恢复回收站中的项: %v (项: %s)", err, id)
+			httpError(w, r, http.StatusForbidden, "forbidden_path")
+			return
+		}
+		if err != nil {
+			log.Printf("回收站操作失败: %v (操作: %s, 项: %s)", err, action, id)
+			httpError(w, r, http.StatusInternalServerError, "trash_failed")
+			return
+		}
+		log.Printf("回收站操作: %s %s (客户端: %s)", action, id, clientIP(r))
+
+		if errorFormat(r) == "json" {
+			writeJSON(w, http.StatusOK, map[string]string{"id": id, "action": action, "path": restored})
+			return
+		}
+		http.Redirect(w, r, config.basePath+"/trash/"+adminTokenQuery(r), http.StatusSeeOther)
+
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, "unsupported_method")
+	}
+}
+
+// 渲染回收站页面
+func renderTrashPage(w http.ResponseWriter, r *http.Request, config *ServerConfig, items []trashItem) {
+	views := make([]trashView, 0, len(items))
+	for _, item := range items {
+		views = append(views, trashView{
+			trashItem:   item,
+			SizeText:    humanizeSize(item.Size),
+			DeletedText: item.Deleted.Format("2006-01-02 15:04:05"),
+		})
+	}
+	data := struct {
+		Lang       string
+		Theme      string
+		BasePath   string
+		TokenQuery string
+		Items      []trashView
+		TotalSize  string
+		ExpireDays int
+		MaxSize    string
+		Duplicates bool
+	}{
+		Lang:       requestLanguage(r),
+		Theme:      requestTheme(r),
+		BasePath:   config.basePath,
+		TokenQuery: adminTokenQuery(r),
+		Items:      views,
+		TotalSize:  humanizeSize(totalTrashSize(items)),
+		ExpireDays: config.settings.Trash.ExpireDays,
+		Duplicates: config.duplicates != nil,
+	}
+	if config.trash.maxSize > 0 {
+		data.MaxSize = humanizeSize(config.trash.maxSize)
+	}
+
+	var buf bytes.Buffer
+	if err := config.assets.templates.ExecuteTemplate(&buf, "trash.html", data); err != nil {
+		log.Printf("模板执行错误: %v", err)
+		httpError(w, r, http.StatusInternalServerError, "template_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
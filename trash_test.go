@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 在内存存储上创建回收站
+func newTestTrash(t *testing.T, settings TrashSettings) (*trashBin, storage) {
+	t.Helper()
+	store := newMemStorage()
+	usage, err := newUsageTracker(store, nil, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := newPathPolicy(FileSettings{}, store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trash, err := newTrashBin(settings, store, usage, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trash, store
+}
+
+// 启用回收站的测试配置
+func newTrashTestConfig(t *testing.T, store storage, files FileSettings, maxSize int64) *ServerConfig {
+	t.Helper()
+	settings := defaultSettings()
+	settings.Versions.Disabled = true
+	settings.Duplicates.Disabled = true
+	settings.Trash.MaxSize = maxSize
+	settings.Files = files
+	return newTestConfig(t, settings, store)
+}
+
+func TestIsInternalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{".trash", true},
+		{"/.trash/x", true},
+		{".trash/a/b", true},
+		{"docs/.trash", false},
+		{".trashcan", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isInternalPath(tt.path); got != tt.want {
+			t.Errorf("isInternalPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTrashPutRestore(t *testing.T) {
+	trash, store := newTestTrash(t, TrashSettings{})
+	writeStoreFile(t, store, "docs/a.txt", "old")
+
+	item, err := trash.put("docs/a.txt", "tester", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Path != "docs/a.txt" || item.Size != 3 || item.User != "tester" || item.Reason != "delete" {
+		t.Errorf("item = %+v", item)
+	}
+	if _, err := store.Stat("docs/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file is still in place: %v", err)
+	}
+	if items, _ := trash.list(); len(items) != 1 || items[0].ID != item.ID {
+		t.Errorf("list = %+v", items)
+	}
+
+	// 原位置已有同名文件时恢复为带序号的名称
+	writeStoreFile(t, store, "docs/a.txt", "new")
+	restored, err := trash.restore(item.ID)
+	if err != nil || restored != "docs/a (1).txt" {
+		t.Fatalf("restore = %q, %v, want docs/a (1).txt", restored, err)
+	}
+	if data, _ := fs.ReadFile(store, "docs/a (1).txt"); string(data) != "old" {
+		t.Errorf("restored content = %q, want old", data)
+	}
+	if items, _ := trash.list(); len(items) != 0 {
+		t.Errorf("item is still in the trash: %+v", items)
+	}
+}
+
+func TestTrashPutDirectory(t *testing.T) {
+	trash, store := newTestTrash(t, TrashSettings{})
+	writeStoreFile(t, store, "dir/a.txt", "aa")
+	writeStoreFile(t, store, "dir/sub/b.txt", "bbb")
+
+	item, err := trash.put("dir", "tester", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.IsDir || item.Size != 5 || item.Path != "dir" {
+		t.Errorf("item = %+v, want directory of 5 bytes", item)
+	}
+	if restored, err := trash.restore(item.ID); err != nil || restored != "dir" {
+		t.Fatalf("restore = %q, %v", restored, err)
+	}
+	if data, _ := fs.ReadFile(store, "dir/sub/b.txt"); string(data) != "bbb" {
+		t.Errorf("restored content = %q", data)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	trash, store := newTestTrash(t, TrashSettings{})
+	writeStoreFile(t, store, "a.txt", "a")
+	writeStoreFile(t, store, "b.txt", "b")
+	a, _ := trash.put("a.txt", "tester", "delete")
+	trash.put("b.txt", "tester", "delete")
+
+	if err := trash.purge(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.purge(a.ID); !errors.Is(err, errTrashNotFound) {
+		t.Errorf("second purge: err = %v, want errTrashNotFound", err)
+	}
+	if err := trash.purgeAll(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := fs.ReadDir(store, trashDirName)
+	if len(entries) != 0 {
+		t.Errorf("trash directory is not empty: %v", entries)
+	}
+}
+
+func TestTrashFindRejectsInvalidIDs(t *testing.T) {
+	trash, _ := newTestTrash(t, TrashSettings{})
+	for _, id := range []string{"", "..", "../x", `a\b`, "a.json", "missing"} {
+		if _, err := trash.find(id); !errors.Is(err, errTrashNotFound) {
+			t.Errorf("find(%q): err = %v, want errTrashNotFound", id, err)
+		}
+	}
+}
+
+func TestTrashExpire(t *testing.T) {
+	trash, store := newTestTrash(t, TrashSettings{ExpireDays: 1})
+	writeStoreFile(t, store, "a.txt", "a")
+	item, err := trash.put("a.txt", "tester", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trash.mu.Lock()
+	trash.expire(time.Now().Add(time.Hour), "")
+	trash.mu.Unlock()
+	if _, err := trash.find(item.ID); err != nil {
+		t.Fatalf("item expired too early: %v", err)
+	}
+
+	trash.mu.Lock()
+	trash.expire(time.Now().Add(25*time.Hour), "")
+	trash.mu.Unlock()
+	if _, err := trash.find(item.ID); !errors.Is(err, errTrashNotFound) {
+		t.Errorf("expired item was kept: %v", err)
+	}
+}
+
+func TestNewTrashBinDisabled(t *testing.T) {
+	if trash, err := newTrashBin(TrashSettings{Disabled: true}, newMemStorage(), nil, nil); trash != nil || err != nil {
+		t.Errorf("disabled trash = %v, %v, want nil", trash, err)
+	}
+}
+
+func TestTrashPutKeepsNewItem(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "old.txt", "0123456789")
+	writeStoreFile(t, store, "big.txt", "0123456789")
+	config := newTrashTestConfig(t, store, FileSettings{}, 5)
+
+	// 超出大小上限时清理旧的项，刚放入的项即使本身超出上限也保留
+	old, err := config.trash.put("old.txt", "tester", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := config.trash.put("big.txt", "tester", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.trash.find(item.ID); err != nil {
+		t.Errorf("new item was purged: %v", err)
+	}
+	if _, err := config.trash.find(old.ID); !errors.Is(err, errTrashNotFound) {
+		t.Errorf("old item was not purged: %v", err)
+	}
+}
+
+func TestTrashRestoreChecksPathPolicy(t *testing.T) {
+	store := newMemStorage()
+	store.Mkdir("docs")
+	writeStoreFile(t, store, "docs/a.tmp", "a")
+	config := newTrashTestConfig(t, store, FileSettings{Hidden: []string{"*.tmp"}}, 0)
+
+	// 放入回收站之后才加上的隐藏规则同样适用于恢复
+	item, err := config.trash.put("docs/a.tmp", "tester", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.trash.restore(item.ID); !errors.Is(err, errTrashForbidden) {
+		t.Errorf("restore to hidden path: err = %v, want errTrashForbidden", err)
+	}
+	if _, err := config.trash.find(item.ID); err != nil {
+		t.Errorf("item left the trash after a rejected restore: %v", err)
+	}
+}
+
+func TestTrashRestoreThroughSymlink(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	store := newLocalStorage(root)
+	store.Mkdir("docs")
+	writeStoreFile(t, store, "docs/a.txt", "a")
+	config := newTrashTestConfig(t, store, FileSettings{Symlinks: "within_root"}, 0)
+
+	item, err := config.trash.put("docs/a.txt", "tester", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 原来的目录被换成指向共享目录之外的符号链接
+	if err := os.Remove(filepath.Join(root, "docs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "docs")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	if _, err := config.trash.restore(item.ID); !errors.Is(err, errTrashForbidden) {
+		t.Errorf("restore through symlink: err = %v, want errTrashForbidden", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file was restored outside the share: %v", err)
+	}
+}
+
+func TestValidItemID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"20240102-030405-0a1b2c3d", true},
+		{"", false},
+		{"..", false},
+		{"../x", false},
+		{`a\b`, false},
+		{"a.json", false},
+		{"a b", false},
+	}
+	for _, tt := range tests {
+		if got := validItemID(tt.id); got != tt.want {
+			t.Errorf("validItemID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// 单个文件的上传结果
+type uploadFileResult struct {
+	Name       string `json:"name"`                  // 客户端提交的文件名
+	StoredName string `json:"stored_name,omitempty"` // 保存在服务器上的文件名
+	Path       string `json:"path,omitempty"`        // 下载URL
+	Size       int64  `json:"size"`                  // 写入的字节数
+	Status     string `json:"status"`                // ok 或 failed
+	Error      string `json:"error,omitempty"`       // 失败时的错误码
+	Message    string `json:"message,omitempty"`     // 失败原因（本地化）
+	Scan       string `json:"scan,omitempty"`        // 病毒扫描结果：clean、pending、infected 或 error，未启用扫描时为空
+	Threat     string `json:"threat,omitempty"`      // 发现的威胁名称
+	Checksum   string `json:"checksum,omitempty"`    // 校验通过的校验和（<算法>:<十六进制>），未提交校验和时为空
+
+	scan *scanJob // 等待中的扫描
+}
+
+// 一次上传请求的结果
+type uploadResult struct {
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Message   string             `json:"message"` // 本地化的汇总信息
+	Files     []uploadFileResult `json:"files"`
+}
+
+// 记录上传成功的文件
+func (res *uploadResult) succeed(name, storedName, downloadURL string, size int64) {
+	res.Succeeded++
+	res.Files = append(res.Files, uploadFileResult{Name: name, StoredName: storedName, Path: downloadURL, Size: size, Status: "ok"})
+}
+
+// 记录上传失败的文件，args 为失败原因中的参数（如被拒绝的扩展名）
+func (res *uploadResult) fail(lang, name, code string, args ...interface{}) {
+	res.Failed++
+	res.Files = append(res.Files, uploadFileResult{Name: name, Status: "failed", Error: code, Message: translate(lang, "error."+code, args...)})
+}
+
+// 把扫描结果记入上传结果，发现威胁的文件改为失败
+func (res *uploadResult) applyScans(lang string) {
+	for i := range res.Files {
+		file := &res.Files[i]
+		if file.scan == nil {
+			continue
+		}
+		select {
+		case <-file.scan.done:
+		default:
+			file.Scan = "pending"
+			continue
+		}
+		outcome := file.scan.outcome
+		file.Scan = outcome.Status
+		if outcome.Status == "infected" {
+			res.Succeeded--
+			res.Failed++
+			file.Status = "failed"
+			file.StoredName = ""
+			file.Path = ""
+			file.Threat = outcome.Threat
+			file.Error = "file_infected"
+			file.Message = translate(lang, "error.file_infected", outcome.Threat)
+		}
+	}
+}
+
+// 是否为可以直接保存的文件名：非空、不是 "." 或 ".."、不含路径分隔符
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// 上传文件的相对路径无效
+var errInvalidUploadPath = errors.New("无效的上传路径")
+
+// 确定上传文件的保存位置 - relPath 是文件在上传的文件夹中的相对路径（如 "photos/2024/a.jpg"），
+// 为空时直接保存到目标目录；逐级校验路径并创建缺少的子目录，返回共享目录中的URL相对路径和存储中的名称
+func prepareUploadPath(paths *pathPolicy, store storage, targetURLPath, relPath, name string) (string, string, error) {
+	components := []string{name}
+	if relPath != "" {
+		components = strings.Split(strings.ReplaceAll(relPath, `\`, "/"), "/")
+	}
+	for _, component := range components {
+		if !validFileName(component) {
+			return "", "", errInvalidUploadPath
+		}
+	}
+
+	// 逐级校验，每一级都必须位于共享目录内且不是隐藏的路径；中间的各级需要是目录，不存在时创建
+	current := targetURLPath
+	for i, component := range components {
+		cleaned, storeName, err := paths.validate(path.Join(current, component))
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", errInvalidUploadPath, err)
+		}
+		current = cleaned
+		if i == len(components)-1 {
+			return cleaned, storeName, nil
+		}
+		info, err := store.Stat(storeName)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if err := store.Mkdir(storeName); err != nil && !errors.Is(err, fs.ErrExist) {
+				return "", "", err
+			}
+		case err != nil:
+			return "", "", err
+		case !info.IsDir():
+			return "", "", fmt.Errorf("%w: %s 不是目录", errInvalidUploadPath, cleaned)
+		}
+	}
+	return "", "", errInvalidUploadPath
+}
+
+// 共享目录中的相对路径对应的下载URL（不含URL前缀）
+func downloadPath(relativePath string) string {
+	return (&url.URL{Path: "/download/" + relativePath}).EscapedPath()
+}
+
+// 处理文件上传请求
+func handleFileUpload(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	// 提取上传的目标相对路径 (URL Path থেকে /upload/ বাদ দিয়ে)
+	urlTargetPath := strings.TrimPrefix(r.URL.Path, "/upload/")
+
+	// 验证目标路径是否有效，并获取目标目录在存储中的名称
+	cleanedTargetPath, targetDir, err := config.paths.validate(urlTargetPath)
+	if err != nil {
+		log.Printf("上传路径验证失败: %v (原始路径: %s)", err, urlTargetPath)
+		metrics.uploadFailed("invalid_path")
+		httpError(w, r, http.StatusBadRequest, "invalid_upload_target")
+		return
+	}
+
+	// 确保目标路径是一个目录
+	fileInfo, err := config.store.Stat(targetDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			httpError(w, r, http.StatusNotFound, "upload_target_not_found")
+		} else {
+			log.Printf("获取上传目录信息错误: %v (路径: %s)", err, targetDir)
+			httpError(w, r, http.StatusInternalServerError, "internal")
+		}
+		return
+	}
+	if !fileInfo.IsDir() {
+		httpError(w, r, http.StatusBadRequest, "upload_target_not_dir")
+		return
+	}
+
+	// 如果是GET请求，重定向到对应的目录浏览页面
+	if r.Method == http.MethodGet {
+		// 重定向到浏览路径，而不是上传路径
+		redirectPath := config.basePath + "/" + urlTargetPath
+		if !strings.HasSuffix(redirectPath, "/") {
+			redirectPath += "/"
+		}
+		http.Redirect(w, r, redirectPath, http.StatusSeeOther)
+		return
+	}
+
+	// 处理POST请求
+	if r.Method != http.MethodPost {
+		httpError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if isReadOnlyStorage(config.store) {
+		metrics.uploadFailed("read_only")
+		httpError(w, r, http.StatusForbidden, "read_only_storage")
+		return
+	}
+
+	limits := config.settings.Upload
+
+	// 声明的大小已经超过单次请求的上限时直接拒绝，不必读取请求体
+	if limits.MaxRequestSize > 0 && r.ContentLength > limits.MaxRequestSize {
+		metrics.uploadFailed("request_too_large")
+		httpError(w, r, http.StatusRequestEntityTooLarge, "request_too_large")
+		return
+	}
+
+	// 检查磁盘可用空间和配额：声明的大小已经超出剩余额度时直接拒绝
+	user := uploadUser(r, config)
+	budget := config.quota.budget(user, targetDir)
+	if budget.remaining >= 0 && (budget.remaining == 0 || r.ContentLength > budget.remaining) {
+		metrics.uploadFailed(budget.code)
+		log.Printf("上传超出额度: %s (用户: %s, 剩余: %d 字节, 请求: %d 字节)", budget.code, user, budget.remaining, r.ContentLength)
+		httpError(w, r, budget.status, budget.code)
+		return
+	}
+
+	defer metrics.startTransfer("upload")()
+
+	// 按配置限制单次请求的大小和上传带宽
+	body := config.limiter.throttleReader(r.Body, r)
+	if limits.MaxRequestSize > 0 {
+		body = http.MaxBytesReader(w, body, limits.MaxRequestSize)
+	}
+	r.Body = body
+
+	// 逐个读取表单的各部分，文件内容直接写入目标位置，不经过临时文件
+	reader, err := r.MultipartReader()
+	if err != nil {
+		metrics.uploadFailed("parse")
+		log.Printf("解析上传表单失败: %v", err)
+		httpError(w, r, http.StatusBadRequest, "invalid_upload_form")
+		return
+	}
+
+	lang := requestLanguage(r)
+	result := &uploadResult{Files: []uploadFileResult{}}
+	status := http.StatusOK
+	failStatus := 0 // 因额度不足或原文件正在扫描而失败时的状态码
+
+	// 上传文件夹时，每个文件之前有一个 relpath 字段，给出该文件的相对路径；
+	// 需要校验的文件之前有一个 checksum 字段，给出预期的校验和
+	relPath, checksum := "", ""
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				metrics.uploadFailed("request_too_large")
+				status = http.StatusRequestEntityTooLarge
+			} else {
+				metrics.uploadFailed("parse")
+				log.Printf("读取上传表单失败: %v", err)
+			}
+			if len(result.Files) == 0 {
+				if status == http.StatusRequestEntityTooLarge {
+					httpError(w, r, status, "request_too_large")
+				} else {
+					httpError(w, r, http.StatusBadRequest, "invalid_upload_form")
+				}
+				return
+			}
+			break
+		}
+
+		switch part.FormName() {
+		case "relpath":
+			value, err := io.ReadAll(io.LimitReader(part, 4096))
+			if err != nil {
+				metrics.uploadFailed("parse")
+				httpError(w, r, http.StatusBadRequest, "invalid_upload_form")
+				return
+			}
+			relPath = string(value)
+		case "checksum":
+			value, err := io.ReadAll(io.LimitReader(part, 4096))
+			if err != nil {
+				metrics.uploadFailed("parse")
+				httpError(w, r, http.StatusBadRequest, "invalid_upload_form")
+				return
+			}
+			checksum = string(value)
+		case "file":
+			more, fileStatus := saveUploadedFile(config, user, cleanedTargetPath, relPath, checksum, part, result, lang)
+			if !more {
+				// 请求体超出上限，无法继续读取后面的文件
+				status = http.StatusRequestEntityTooLarge
+			}
+			if fileStatus != 0 {
+				failStatus = fileStatus
+			}
+			relPath, checksum = "", ""
+		}
+		part.Close()
+		if status == http.StatusRequestEntityTooLarge {
+			break
+		}
+	}
+
+	if len(result.Files) == 0 {
+		metrics.uploadFailed("no_file")
+		httpError(w, r, http.StatusBadRequest, "no_upload_file")
+		return
+	}
+
+	// 启用病毒扫描时等待扫描结果（最多等待配置的时间），记入每个文件的结果
+	if config.scanner != nil {
+		var jobs []*scanJob
+		for _, file := range result.Files {
+			if file.scan != nil {
+				jobs = append(jobs, file.scan)
+			}
+		}
+		config.scanner.await(jobs)
+		result.applyScans(lang)
+	}
+
+	// 返回每个文件的结果，全部失败时返回422（请求体超出上限时返回413，额度不足时返回507或413，原文件正在扫描时返回409）
+	result.Message = translate(lang, "upload.summary", result.Succeeded, result.Failed)
+	if result.Succeeded == 0 && status == http.StatusOK {
+		status = http.StatusUnprocessableEntity
+		if failStatus != 0 {
+			status = failStatus
+		}
+	}
+	writeJSON(w, status, result)
+}
+
+// 保存一个上传的文件，结果记录到 result 中；请求体超出上限、无法继续读取时返回false，
+// 因磁盘空间或配额不足、或要覆盖的文件正在扫描而失败时同时返回对应的状态码
+func saveUploadedFile(config *ServerConfig, user, targetURLPath, relPath, checksum string, part *multipart.Part, result *uploadResult, lang string) (bool, int) {
+	// 文件名只能是单独的一级名称（multipart已去掉目录部分，这里排除 "." 和 ".."）
+	name := part.FileName()
+	displayName := name
+	if relPath != "" {
+		displayName = relPath
+	}
+
+	// 检查文件名长度和扩展名
+	if code, args := config.filter.checkName(displayName); code != "" {
+		metrics.uploadFailed("rejected")
+		log.Printf("拒绝上传: %s (文件: %s)", code, displayName)
+		result.fail(lang, displayName, code, args...)
+		return true, 0
+	}
+
+	// 提交了校验和时，写入的同时计算校验和，不匹配时拒绝保存
+	var hasher hash.Hash
+	var checksumAlgo string
+	var expected []byte
+	if checksum != "" {
+		var err error
+		checksumAlgo, expected, err = parseChecksum(checksum)
+		if err != nil {
+			metrics.uploadFailed("checksum")
+			log.Printf("上传的校验和无效: %s (文件: %s)", checksum, displayName)
+			result.fail(lang, displayName, err.Error())
+			return true, 0
+		}
+		hasher = hashAlgorithms[checksumAlgo].new()
+	}
+
+	// 读取文件开头检测内容类型，之后与剩余部分一起写入
+	var src io.Reader = part
+	if config.filter.sniffs() {
+		head := make([]byte, sniffLength)
+		n, err := io.ReadFull(part, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				metrics.uploadFailed("request_too_large")
+				result.fail(lang, displayName, "request_too_large")
+				return false, 0
+			}
+			metrics.uploadFailed("copy")
+			log.Printf("读取上传文件失败: %v (文件: %s)", err, displayName)
+			result.fail(lang, displayName, "upload_write_failed")
+			return true, 0
+		}
+		head = head[:n]
+		if code, args := config.filter.checkContent(head); code != "" {
+			metrics.uploadFailed("rejected")
+			log.Printf("拒绝上传: %s %v (文件: %s)", code, args, displayName)
+			result.fail(lang, displayName, code, args...)
+			return true, 0
+		}
+		src = io.MultiReader(bytes.NewReader(head), part)
+	}
+
+	// 确定保存位置，上传文件夹时创建子目录
+	storedPath, destName, err := prepareUploadPath(config.paths, config.store, targetURLPath, relPath, name)
+	if err != nil {
+		if errors.Is(err, errInvalidUploadPath) {
+			metrics.uploadFailed("invalid_name")
+			log.Printf("上传路径无效: %v (文件: %s)", err, displayName)
+			result.fail(lang, displayName, "invalid_file_name")
+		} else {
+			metrics.uploadFailed("mkdir")
+			log.Printf("创建上传子目录失败: %v (文件: %s)", err, displayName)
+			result.fail(lang, displayName, "upload_mkdir_failed")
+		}
+		return true, 0
+	}
+
+	// 先写入同一目录中的临时文件，完整写入并通过校验后再替换目标文件，
+	// 失败时原来的文件保持不变；替换只改变目录项，不会改写与原文件共享内容的硬链接
+	tempName, err := uploadTempName(destName)
+	if err != nil {
+		metrics.uploadFailed("create")
+		log.Printf("生成临时文件名失败: %v (路径: %s)", err, destName)
+		result.fail(lang, displayName, "upload_create_failed")
+		return true, 0
+	}
+	dest, err := config.store.Create(tempName)
+	if err != nil {
+		metrics.uploadFailed("create")
+		log.Printf("创建文件失败: %v (路径: %s)", err, tempName)
+		result.fail(lang, displayName, "upload_create_failed")
+		return true, 0
+	}
+
+	// 复制文件内容，超过单个文件的上限时停止
+	maxFileSize := config.settings.Upload.MaxFileSize
+	if maxFileSize > 0 {
+		src = io.LimitReader(src, maxFileSize+1)
+	}
+	// 磁盘额度按文件所在目录重新计算（文件夹中的文件可能位于不同的配额目录），
+	// 配额随写入逐步预留，计入用量之后释放，同时进行的上传不会一起超出配额
+	reservation := config.quota.reserve(user, path.Dir(destName))
+	defer reservation.release()
+	budget := &budgetWriter{w: dest, disk: config.quota.diskBudget(path.Dir(destName)), reservation: reservation}
+	var out io.Writer = budget
+	if hasher != nil {
+		out = io.MultiWriter(out, hasher)
+	}
+	written, err := io.Copy(out, src)
+	var info fs.FileInfo
+	if err == nil {
+		info, err = dest.Stat()
+	}
+	// 只在这里关闭一次；关闭失败说明内容可能没有完整写入
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && maxFileSize > 0 && written > maxFileSize {
+		metrics.uploadFailed("file_too_large")
+		log.Printf("上传文件超过大小上限: %s (上限: %d 字节)", displayName, maxFileSize)
+		result.fail(lang, displayName, "file_too_large")
+		config.store.Remove(tempName)
+		return true, 0
+	}
+	if err != nil {
+		// 删除部分写入的临时文件
+		config.store.Remove(tempName)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			metrics.uploadFailed("request_too_large")
+			result.fail(lang, displayName, "request_too_large")
+			return false, 0
+		}
+		if errors.Is(err, errBudgetExceeded) {
+			exceeded := budget.exceeded
+			metrics.uploadFailed(exceeded.code)
+			log.Printf("上传超出额度: %s (文件: %s, 用户: %s)", exceeded.code, displayName, user)
+			result.fail(lang, displayName, exceeded.code)
+			return true, exceeded.status
+		}
+		metrics.uploadFailed("copy")
+		log.Printf("写入文件失败: %v (路径: %s)", err, destName)
+		result.fail(lang, displayName, "upload_write_failed")
+		return true, 0
+	}
+	var verified string
+	var actual []byte
+	if hasher != nil {
+		actual = hasher.Sum(nil)
+		if !bytes.Equal(actual, expected) {
+			config.store.Remove(tempName)
+			metrics.uploadFailed("checksum")
+			log.Printf("上传文件的校验和不匹配: %s (%s, 预期: %x, 实际: %x)", displayName, checksumAlgo, expected, actual)
+			result.fail(lang, displayName, "checksum_mismatch", checksumAlgo, hex.EncodeToString(actual))
+			return true, 0
+		}
+		verified = checksumAlgo + ":" + hex.EncodeToString(actual)
+	}
+
+	// 要覆盖的文件还在等待扫描时拒绝上传：原来的内容会被保存为历史版本或移到回收站，不能跳过扫描
+	if config.scanner != nil && config.scanner.status(destName) == "pending" {
+		config.store.Remove(tempName)
+		metrics.uploadFailed("scan_in_progress")
+		log.Printf("要覆盖的文件正在扫描，拒绝上传: %s (路径: %s)", displayName, destName)
+		result.fail(lang, displayName, "scan_in_progress")
+		return true, http.StatusConflict
+	}
+
+	// 替换已有文件时，启用历史版本则把原来的文件保存为历史版本，否则启用回收站时移到回收站，
+	// 替换失败时再放回原处；保存为历史版本或没有回收站时记下原来的大小，用于统计用量
+	var previousSize int64
+	putBack := func() {}
+	if existing, err := config.store.Stat(destName); err == nil && !existing.IsDir() {
+		switch {
+		case config.versions != nil:
+			version, err := config.versions.save(destName, user)
+			if err != nil {
+				config.store.Remove(tempName)
+				metrics.uploadFailed("version")
+				log.Printf("保存历史版本失败: %v (路径: %s)", err, destName)
+				result.fail(lang, displayName, "upload_create_failed")
+				return true, 0
+			}
+			putBack = func() { config.versions.putBack(destName, version.ID) }
+			previousSize = existing.Size()
+		case config.trash != nil:
+			item, err := config.trash.put(destName, user, "overwrite")
+			if err != nil {
+				config.store.Remove(tempName)
+				metrics.uploadFailed("trash")
+				log.Printf("移到回收站失败: %v (路径: %s)", err, destName)
+				result.fail(lang, displayName, "upload_create_failed")
+				return true, 0
+			}
+			putBack = func() { config.trash.putBack(item.ID, destName) }
+		default:
+			previousSize = existing.Size()
+		}
+	}
+	if err := config.store.Rename(tempName, destName); err != nil {
+		config.store.Remove(tempName)
+		putBack()
+		metrics.uploadFailed("create")
+		log.Printf("替换文件失败: %v (路径: %s)", err, destName)
+		result.fail(lang, displayName, "upload_create_failed")
+		return true, 0
+	}
+	if actual != nil {
+		config.hashes.store(destName, info, checksumAlgo, actual)
+	}
+	config.usage.recordUpload(user, destName, written, previousSize)
+
+	// 标记为成功，启用病毒扫描时放入扫描队列
+	storedName := strings.ReplaceAll(displayName, `\`, "/")
+	result.succeed(displayName, storedName, config.basePath+downloadPath(storedPath), written)
+	result.Files[len(result.Files)-1].Checksum = verified
+	log.Printf("文件上传成功: %s -> %s", displayName, destName)
+	if config.scanner != nil {
+		result.Files[len(result.Files)-1].scan = config.scanner.submit(destName, user)
+	}
+	return true, 0
+}
+
+// 上传中的临时文件的名称前缀
+const uploadTempPrefix = ".fileserver-upload-"
+
+// 上传时使用的临时文件名，与目标文件位于同一目录，以便直接重命名替换
+func uploadTempName(destName string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(destName), uploadTempPrefix+hex.EncodeToString(suffix)), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// 上传文件的类型限制 - 按扩展名和检测到的内容类型，拒绝优先，允许列表为空表示全部允许
+type uploadFilter struct {
+	allowExts     map[string]bool
+	denyExts      map[string]bool
+	allowTypes    []string // MIME类型，可以是 "image/*" 这样的通配
+	denyTypes     []string
+	maxNameLength int // 文件名（每一级）的最大字符数，0表示不限制
+}
+
+// 检测内容类型时读取的字节数，与 http.DetectContentType 一致
+const sniffLength = 512
+
+// 根据上传配置创建类型限制
+func newUploadFilter(settings UploadSettings) (*uploadFilter, error) {
+	f := &uploadFilter{
+		allowExts:     normalizeExtensions(settings.AllowExtensions),
+		denyExts:      normalizeExtensions(settings.DenyExtensions),
+		maxNameLength: settings.MaxNameLength,
+	}
+	var err error
+	if f.allowTypes, err = normalizeTypes(settings.AllowTypes); err != nil {
+		return nil, err
+	}
+	if f.denyTypes, err = normalizeTypes(settings.DenyTypes); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// 扩展名统一为小写并带上 "."
+func normalizeExtensions(exts []string) map[string]bool {
+	result := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		result[ext] = true
+	}
+	return result
+}
+
+// 校验并统一MIME类型的写法
+func normalizeTypes(types []string) ([]string, error) {
+	result := make([]string, 0, len(types))
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !strings.Contains(t, "/") {
+			return nil, fmt.Errorf("无效的内容类型: %q", t)
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// 是否需要检测文件内容
+func (f *uploadFilter) sniffs() bool {
+	return len(f.allowTypes) > 0 || len(f.denyTypes) > 0
+}
+
+// 文件的扩展名 - 忽略末尾的 "." 和空格（Windows 会去掉它们，"a.exe." 实际是 "a.exe"）
+func fileExtension(name string) string {
+	return strings.ToLower(path.Ext(strings.TrimRight(name, ". ")))
+}
+
+// 检查文件名：displayName 是客户端提交的名称，上传文件夹时为相对路径；
+// 不允许时返回错误码和消息参数
+func (f *uploadFilter) checkName(displayName string) (string, []interface{}) {
+	components := strings.Split(strings.ReplaceAll(displayName, `\`, "/"), "/")
+	if f.maxNameLength > 0 {
+		for _, component := range components {
+			if utf8.RuneCountInString(component) > f.maxNameLength {
+				return "file_name_too_long", []interface{}{f.maxNameLength}
+			}
+		}
+	}
+
+	ext := fileExtension(components[len(components)-1])
+	if f.denyExts[ext] || (len(f.allowExts) > 0 && !f.allowExts[ext]) {
+		if ext == "" {
+			return "file_extension_missing", nil
+		}
+		return "file_type_not_allowed", []interface{}{ext}
+	}
+	return "", nil
+}
+
+// 检查文件开头的内容，不允许时返回错误码和消息参数
+func (f *uploadFilter) checkContent(head []byte) (string, []interface{}) {
+	contentType := sniffContentType(head)
+	if matchesType(f.denyTypes, contentType) || (len(f.allowTypes) > 0 && !matchesType(f.allowTypes, contentType)) {
+		return "content_type_not_allowed", []interface{}{contentType}
+	}
+	return "", nil
+}
+
+// 可执行文件的特征 - http.DetectContentType 不识别这些格式，统一检测为 application/octet-stream
+var executableSignatures = []struct {
+	magic       []byte
+	contentType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{[]byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("#!"), "text/x-shellscript"},
+}
+
+// 检测内容类型（不含参数），在标准库的基础上识别可执行文件和脚本
+func sniffContentType(head []byte) string {
+	for _, sig := range executableSignatures {
+		if bytes.HasPrefix(head, sig.magic) {
+			return sig.contentType
+		}
+	}
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// 内容类型是否匹配列表中的某一项，"image/*" 匹配所有图片
+func matchesType(patterns []string, contentType string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return true
+			}
+		} else if pattern == contentType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUploadFilterCheckName(t *testing.T) {
+	f, err := newUploadFilter(UploadSettings{
+		AllowExtensions: []string{"txt", ".JPG", "  "},
+		DenyExtensions:  []string{".jpg"},
+		MaxNameLength:   8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, code string
+	}{
+		{"a.txt", ""},
+		{"A.TXT", ""},
+		{"pic.jpg", "file_type_not_allowed"},
+		{"run.sh", "file_type_not_allowed"},
+		{"a.txt. ", ""},
+		{"README", "file_extension_missing"},
+		{"dir/a.txt", ""},
+		{`folder\a.txt`, ""},
+		{"toolongname/a.txt", "file_name_too_long"},
+		{"文件名很长的文件.txt", "file_name_too_long"},
+		{"八个字符的名字.txt", "file_name_too_long"},
+		{"六字名字.txt", ""},
+	}
+	for _, tt := range tests {
+		if code, _ := f.checkName(tt.name); code != tt.code {
+			t.Errorf("checkName(%q) = %q, want %q", tt.name, code, tt.code)
+		}
+	}
+
+	// 没有限制时全部允许
+	open, _ := newUploadFilter(UploadSettings{})
+	if code, _ := open.checkName("anything.exe"); code != "" {
+		t.Errorf("unrestricted filter rejected a file: %s", code)
+	}
+	if open.sniffs() {
+		t.Error("filter without type rules sniffs content")
+	}
+}
+
+func TestUploadFilterCheckContent(t *testing.T) {
+	f, err := newUploadFilter(UploadSettings{
+		AllowTypes: []string{"image/*", "text/plain", "text/x-shellscript"},
+		DenyTypes:  []string{"text/x-shellscript"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		head string
+		ok   bool
+	}{
+		{"png", "\x89PNG\r\n\x1a\n", true},
+		{"gif", "GIF89a", true},
+		{"text", "hello world", true},
+		{"script denied", "#!/bin/sh\nrm -rf /", false},
+		{"windows executable", "MZ\x90\x00", false},
+		{"elf", "\x7fELF\x02\x01", false},
+		{"html", "<html><body>", false},
+	}
+	for _, tt := range tests {
+		code, _ := f.checkContent([]byte(tt.head))
+		if (code == "") != tt.ok {
+			t.Errorf("%s: checkContent = %q, want allowed %v", tt.name, code, tt.ok)
+		}
+	}
+
+	if _, err := newUploadFilter(UploadSettings{DenyTypes: []string{"exe"}}); err == nil {
+		t.Error("content type without a slash was accepted")
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	tests := map[string]string{
+		"MZ\x90\x00":       "application/x-msdownload",
+		"\x7fELF":          "application/x-executable",
+		"\xcf\xfa\xed\xfe": "application/x-mach-binary",
+		"#!/usr/bin/env":   "text/x-shellscript",
+		"plain":            "text/plain",
+		"%PDF-1.7":         "application/pdf",
+	}
+	for head, want := range tests {
+		if got := sniffContentType([]byte(head)); got != want {
+			t.Errorf("sniffContentType(%q) = %q, want %q", head, got, want)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 目录的递归用量
+type dirUsage struct {
+	Size     int64     // 所有文件的总大小
+	Files    int       // 文件数
+	Dirs     int       // 子目录数
+	Computed time.Time // 统计时间
+}
+
+// 上传文件的归属，用于按用户统计用量
+type fileOwner struct {
+	User string `json:"user"`
+	Size int64  `json:"size"`
+}
+
+// 用量统计 - 缓存目录的递归大小并在后台定期重新统计，记录每个上传文件的上传者
+type usageTracker struct {
+	mu       sync.Mutex
+	store    storage
+	paths    *pathPolicy          // 隐藏文件策略，隐藏的文件不计入列表和用量页面的统计
+	dirs     map[string]dirUsage  // 目录在存储中的名称 -> 用量
+	tree     map[string]dirUsage  // 共享目录中所有目录的用量（不含内部目录），用于列表和用量页面，后台统计完成前为nil
+	owners   map[string]fileOwner // 文件相对路径（正斜杠） -> 上传者
+	users    map[string]int64     // 用户 -> 上传文件的总大小
+	file     string               // 保存上传者记录的文件，为空表示只保存在内存中
+	interval time.Duration        // 后台重新统计的间隔
+}
+
+// 创建用量统计，配置了文件时从中恢复上传者记录
+func newUsageTracker(store storage, paths *pathPolicy, file string, interval time.Duration) (*usageTracker, error) {
+	u := &usageTracker{
+		store:    store,
+		paths:    paths,
+		dirs:     make(map[string]dirUsage),
+		owners:   make(map[string]fileOwner),
+		users:    make(map[string]int64),
+		interval: interval,
+	}
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		u.file = abs
+		data, err := os.ReadFile(abs)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &u.owners); err != nil {
+				return nil, fmt.Errorf("解析用量文件 %s 失败: %v", abs, err)
+			}
+		}
+		for _, owner := range u.owners {
+			u.users[owner.User] += owner.Size
+		}
+	}
+	go func() {
+		u.refreshTree()
+		u.refreshLoop()
+	}()
+	return u, nil
+}
+
+// 一次遍历统计存储中所有目录的递归用量，跳过根目录下的内部目录和 paths 隐藏的路径（不跟随符号链接）
+func computeUsageTree(fsys fs.FS, paths *pathPolicy) map[string]dirUsage {
+	now := time.Now()
+	tree := map[string]dirUsage{".": {Computed: now}}
+	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return nil
+		}
+		parent := path.Dir(p)
+		usage := tree[parent]
+		if d.IsDir() {
+			if parent == "." && internalDirs[d.Name()] || paths.hidden(p, true) {
+				return fs.SkipDir
+			}
+			usage.Dirs++
+			tree[parent] = usage
+			tree[p] = dirUsage{Computed: now}
+			return nil
+		}
+		if paths.hidden(p, false) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		usage.Files++
+		usage.Size += info.Size()
+		tree[parent] = usage
+		return nil
+	})
+
+	// 从最深的目录开始，把每个目录的用量加到上级目录
+	dirs := make([]string, 0, len(tree))
+	for dir := range tree {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+	for _, dir := range dirs {
+		if dir == "." {
+			continue
+		}
+		usage := tree[dir]
+		parent := tree[path.Dir(dir)]
+		parent.Size += usage.Size
+		parent.Files += usage.Files
+		parent.Dirs += usage.Dirs
+		tree[path.Dir(dir)] = parent
+	}
+	return tree
+}
+
+// 重新统计所有目录的用量
+func (u *usageTracker) refreshTree() {
+	tree := computeUsageTree(u.store, u.paths)
+	u.mu.Lock()
+	u.tree = tree
+	u.mu.Unlock()
+}
+
+// 列表和用量页面中目录的递归用量：使用后台统计的结果，统计之后新建的目录立即统计；
+// 后台第一次统计完成之前返回false
+func (u *usageTracker) treeUsage(dir string) (dirUsage, bool) {
+	u.mu.Lock()
+	if u.tree == nil {
+		u.mu.Unlock()
+		return dirUsage{}, false
+	}
+	usage, ok := u.tree[dir]
+	u.mu.Unlock()
+	if ok {
+		return usage, true
+	}
+
+	usage, err := computeDirUsage(u.store, dir, u.paths)
+	if err != nil {
+		return dirUsage{}, false
+	}
+	u.mu.Lock()
+	if u.tree != nil {
+		u.tree[dir] = usage
+	}
+	u.mu.Unlock()
+	return usage, true
+}
+
+// 统计目录的递归用量（不跟随符号链接），dir 是目录在 fsys 中的名称；paths 不为nil时跳过隐藏的路径
+func computeDirUsage(fsys fs.FS, dir string, paths *pathPolicy) (dirUsage, error) {
+	usage := dirUsage{Computed: time.Now()}
+	err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 无权限等情况跳过该条目，不影响整体统计
+			if p == dir {
+				return err
+			}
+			return nil
+		}
+		if p == dir {
+			return nil
+		}
+		if d.IsDir() {
+			if paths.hidden(p, true) {
+				return fs.SkipDir
+			}
+			usage.Dirs++
+			return nil
+		}
+		if paths.hidden(p, false) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		usage.Files++
+		usage.Size += info.Size()
+		return nil
+	})
+	return usage, err
+}
+
+// 目录的递归用量，没有缓存时立即统计；之后由后台定期重新统计
+func (u *usageTracker) dirUsage(dir string) (dirUsage, error) {
+	u.mu.Lock()
+	usage, ok := u.dirs[dir]
+	u.mu.Unlock()
+	if ok {
+		return usage, nil
+	}
+
+	usage, err := computeDirUsage(u.store, dir, nil)
+	if err != nil {
+		return dirUsage{}, err
+	}
+	u.mu.Lock()
+	u.dirs[dir] = usage
+	u.mu.Unlock()
+	return usage, nil
+}
+
+// 用户上传文件的总大小
+func (u *usageTracker) userUsage(user string) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.users[user]
+}
+
+// 记录一次上传：更新上传者记录，并把大小变化计入已缓存的上级目录
+// name 是文件在存储中的名称，previousSize 是被覆盖的旧文件大小，新建文件时为0
+func (u *usageTracker) recordUpload(user, name string, size, previousSize int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if old, ok := u.owners[name]; ok {
+		u.users[old.User] -= old.Size
+	}
+	u.owners[name] = fileOwner{User: user, Size: size}
+	u.users[user] += size
+
+	files := 0
+	if previousSize == 0 {
+		files = 1
+	}
+	u.adjustDirs(name, size-previousSize, files)
+	u.save()
+}
+
+// 记录文件或目录被移出原来的位置（隔离、删除等），目录中所有上传的文件一并移除
+func (u *usageTracker) recordRemove(name string) {
+	// 不是通过上传产生的文件不在记录中，已缓存的目录用量等后台重新统计时校正
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, owned := u.owners[name]
+	changed := false
+	for key, owner := range u.owners {
+		if !nameWithin(key, name) {
+			continue
+		}
+		u.users[owner.User] -= owner.Size
+		delete(u.owners, key)
+		u.adjustDirs(key, -owner.Size, -1)
+		changed = true
+	}
+	if changed {
+		u.save()
+	}
+
+	// 被移走的目录：从上级目录中减去其余的用量，并删除它和其中子目录的统计
+	if removed, ok := u.tree[name]; ok {
+		for dir := path.Dir(name); ; dir = path.Dir(dir) {
+			if usage, ok := u.tree[dir]; ok {
+				usage.Size -= removed.Size
+				usage.Files -= removed.Files
+				usage.Dirs -= removed.Dirs + 1
+				u.tree[dir] = usage
+			}
+			if dir == "." {
+				break
+			}
+		}
+		for dir := range u.tree {
+			if nameWithin(dir, name) {
+				delete(u.tree, dir)
+			}
+		}
+	} else if !owned {
+		// 不知道被移走的大小，删除上级目录的统计，下次用到时重新统计（根目录的统计不会用到）
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			delete(u.tree, dir)
+		}
+	}
+}
+
+// 名称是否为 dir 本身或位于其中（dir 为 "." 时总是成立）
+func nameWithin(name, dir string) bool {
+	return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// 把大小和文件数的变化计入所有已缓存的上级目录；调用时需持有锁
+func (u *usageTracker) adjustDirs(name string, delta int64, files int) {
+	for dir, usage := range u.dirs {
+		if name != dir && nameWithin(name, dir) {
+			usage.Size += delta
+			usage.Files += files
+			u.dirs[dir] = usage
+		}
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if usage, ok := u.tree[dir]; ok {
+			usage.Size += delta
+			usage.Files += files
+			u.tree[dir] = usage
+		}
+		if dir == "." {
+			break
+		}
+	}
+}
+
+// 保存上传者记录 - 先写临时文件再重命名；调用时需持有锁
+func (u *usageTracker) save() {
+	if u.file == "" {
+		return
+	}
+	data, err := json.Marshal(u.owners)
+	if err != nil {
+		log.Printf("保存用量记录失败: %v", err)
+		return
+	}
+	tmp := u.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("保存用量记录失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, u.file); err != nil {
+		log.Printf("保存用量记录失败: %v", err)
+	}
+}
+
+// 后台定期重新统计所有目录和已缓存的目录，并校正上传者记录（文件可能在服务器之外被删除或修改）
+func (u *usageTracker) refreshLoop() {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		u.refresh()
+	}
+}
+
+func (u *usageTracker) refresh() {
+	u.refreshTree()
+
+	u.mu.Lock()
+	dirs := make([]string, 0, len(u.dirs))
+	for dir := range u.dirs {
+		dirs = append(dirs, dir)
+	}
+	u.mu.Unlock()
+
+	for _, dir := range dirs {
+		usage, err := computeDirUsage(u.store, dir, nil)
+		u.mu.Lock()
+		if err != nil {
+			// 目录已不存在
+			delete(u.dirs, dir)
+		} else {
+			u.dirs[dir] = usage
+		}
+		u.mu.Unlock()
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	changed := false
+	for key, owner := range u.owners {
+		info, err := u.store.Stat(key)
+		switch {
+		case err != nil || info.IsDir():
+			u.users[owner.User] -= owner.Size
+			delete(u.owners, key)
+			changed = true
+		case info.Size() != owner.Size:
+			u.users[owner.User] += info.Size() - owner.Size
+			owner.Size = info.Size()
+			u.owners[key] = owner
+			changed = true
+		}
+	}
+	if changed {
+		u.save()
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "a/one.txt", "12345")
+	writeStoreFile(t, store, "a/b/two.txt", "123")
+	file := filepath.Join(t.TempDir(), "usage.json")
+	u, err := newUsageTracker(store, nil, file, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := u.dirUsage("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Size != 8 || usage.Files != 2 || usage.Dirs != 1 {
+		t.Errorf("dirUsage = %+v, want 8 bytes in 2 files and 1 dir", usage)
+	}
+
+	// 上传计入缓存的上级目录，覆盖时只计入差值
+	writeStoreFile(t, store, "a/b/three.txt", "1234")
+	u.recordUpload("user:alice", "a/b/three.txt", 4, 0)
+	writeStoreFile(t, store, "a/b/three.txt", "12")
+	u.recordUpload("user:bob", "a/b/three.txt", 2, 4)
+	usage, _ = u.dirUsage("a")
+	if usage.Size != 10 || usage.Files != 3 {
+		t.Errorf("dirUsage after uploads = %+v, want 10 bytes in 3 files", usage)
+	}
+	if u.userUsage("user:alice") != 0 || u.userUsage("user:bob") != 2 {
+		t.Errorf("user usage: alice %d, bob %d, want 0 and 2", u.userUsage("user:alice"), u.userUsage("user:bob"))
+	}
+
+	// 上传者记录保存到文件，重新加载后恢复
+	reloaded, err := newUsageTracker(store, nil, file, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.userUsage("user:bob"); got != 2 {
+		t.Errorf("reloaded bob usage = %d, want 2", got)
+	}
+
+	// 文件在服务器之外被删除后，重新统计时校正
+	store.Remove("a/b/three.txt")
+	reloaded.refresh()
+	if got := reloaded.userUsage("user:bob"); got != 0 {
+		t.Errorf("bob usage after external delete = %d, want 0", got)
+	}
+}
+
+// 等待后台第一次统计完成后重新统计，避免后台的结果覆盖测试中写入文件之后的统计
+func refreshUsageTree(t *testing.T, u *usageTracker) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		u.mu.Lock()
+		ready := u.tree != nil
+		u.mu.Unlock()
+		if ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background usage tree was not computed")
+		}
+	}
+	u.refreshTree()
+}
+
+func TestComputeUsageTree(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	os.MkdirAll(filepath.Join(root, trashDirName), 0755)
+	os.WriteFile(filepath.Join(root, "top.txt"), []byte("1"), 0644)
+	os.WriteFile(filepath.Join(root, "a", "one.txt"), []byte("12345"), 0644)
+	os.WriteFile(filepath.Join(root, "a", "b", "two.txt"), []byte("123"), 0644)
+	os.WriteFile(filepath.Join(root, trashDirName, "x"), []byte("1234567890"), 0644)
+
+	tree := computeUsageTree(newLocalStorage(root), nil)
+	tests := []struct {
+		dir                string
+		size               int64
+		files, directories int
+	}{
+		{".", 9, 3, 2},
+		{"a", 8, 2, 1},
+		{"a/b", 3, 1, 0},
+	}
+	for _, tt := range tests {
+		got := tree[tt.dir]
+		if got.Size != tt.size || got.Files != tt.files || got.Dirs != tt.directories {
+			t.Errorf("tree[%s] = %+v, want %d bytes, %d files, %d dirs", tt.dir, got, tt.size, tt.files, tt.directories)
+		}
+	}
+	// 内部目录不统计
+	if _, ok := tree[trashDirName]; ok {
+		t.Error("tree contains the trash directory")
+	}
+}
+
+func TestComputeUsageTreeHidden(t *testing.T) {
+	root := t.TempDir()
+	store := newLocalStorage(root)
+	writeStoreFile(t, store, "a/one.txt", "12345")
+	writeStoreFile(t, store, "a/skip.tmp", "123")
+	writeStoreFile(t, store, "private/secret.txt", "1234567890")
+	paths, err := newPathPolicy(FileSettings{Hidden: []string{"*.tmp", "/private/"}}, store, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 隐藏的文件和目录不计入用量
+	tree := computeUsageTree(store, paths)
+	if got := tree["."]; got.Size != 5 || got.Files != 1 || got.Dirs != 1 {
+		t.Errorf("tree[.] = %+v, want 5 bytes, 1 file, 1 dir", got)
+	}
+	if _, ok := tree["private"]; ok {
+		t.Error("tree contains the hidden directory")
+	}
+	usage, err := computeDirUsage(store, "a", paths)
+	if err != nil || usage.Size != 5 || usage.Files != 1 {
+		t.Errorf("computeDirUsage(a) = %+v, %v, want 5 bytes in 1 file", usage, err)
+	}
+}
+
+func TestTreeUsage(t *testing.T) {
+	store := newMemStorage()
+	writeStoreFile(t, store, "a/b/two.txt", "123")
+	u, err := newUsageTracker(store, nil, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshUsageTree(t, u)
+
+	// 统计之后新建的目录立即统计
+	writeStoreFile(t, store, "c/new.txt", "12")
+	if usage, ok := u.treeUsage("c"); !ok || usage.Size != 2 || usage.Files != 1 {
+		t.Errorf("treeUsage(c) = %+v, %v", usage, ok)
+	}
+
+	// 上传计入所有上级目录
+	writeStoreFile(t, store, "a/b/three.txt", "1234")
+	u.recordUpload("user:alice", "a/b/three.txt", 4, 0)
+	if usage, _ := u.treeUsage("a"); usage.Size != 7 || usage.Files != 2 {
+		t.Errorf("treeUsage(a) after upload = %+v, want 7 bytes in 2 files", usage)
+	}
+
+	// 移走的目录从上级目录中减去
+	store.Remove("a/b")
+	u.recordRemove("a/b")
+	if usage, _ := u.treeUsage("a"); usage.Size != 0 || usage.Files != 0 || usage.Dirs != 0 {
+		t.Errorf("treeUsage(a) after remove = %+v, want empty", usage)
+	}
+	if usage, _ := u.treeUsage("."); usage.Size != 0 || usage.Files != 0 || usage.Dirs != 1 {
+		t.Errorf("treeUsage(root) after remove = %+v, want only directory a", usage)
+	}
+	if got := u.userUsage("user:alice"); got != 0 {
+		t.Errorf("alice usage after remove = %d, want 0", got)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// 矩形树图最多显示的格子数，其余的合并成一个
+const treemapMaxCells = 200
+
+// 矩形树图的宽高比例，和页面中容器的 aspect-ratio 一致
+const (
+	treemapWidth  = 160.0
+	treemapHeight = 90.0
+)
+
+// 用量页面中的一项：子目录或文件
+type usageEntry struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"` // 目录为其用量页面的链接，文件为下载链接
+	IsDir bool   `json:"is_dir"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files,omitempty"` // 目录中的文件数（递归）
+	Dirs  int    `json:"dirs,omitempty"`  // 目录中的子目录数（递归）
+}
+
+// 目录的用量，按大小从大到小列出其中的子目录和文件
+type usageReport struct {
+	Path    string       `json:"path"`
+	Size    int64        `json:"size"`
+	Files   int          `json:"files"`
+	Dirs    int          `json:"dirs"`
+	Ready   bool         `json:"ready"` // 后台统计是否已完成，未完成时目录的大小为0
+	Entries []usageEntry `json:"entries"`
+}
+
+// 目录的用量页面的路径（已转义），relativePath 为空表示根目录
+func usagePath(relativePath string) string {
+	if relativePath == "" {
+		return "/usage/"
+	}
+	return (&url.URL{Path: "/usage/" + relativePath + "/"}).EscapedPath()
+}
+
+// 统计目录中每个子目录和文件的用量，name 是目录在存储中的名称
+func buildUsageReport(config *ServerConfig, relPath, name string) (usageReport, error) {
+	entries, err := config.store.ReadDir(name)
+	if err != nil {
+		return usageReport{}, err
+	}
+	entries = config.paths.filter(name, entries)
+
+	report := usageReport{Path: "/" + relPath, Ready: true, Entries: make([]usageEntry, 0, len(entries))}
+	for _, entry := range entries {
+		// 根目录下的内部目录不统计
+		if relPath == "" && internalDirs[entry.Name()] {
+			continue
+		}
+		entryRel := path.Join(relPath, entry.Name())
+		item := usageEntry{Name: entry.Name(), IsDir: entry.IsDir()}
+		if entry.IsDir() {
+			item.Path = config.basePath + usagePath(entryRel)
+			usage, ok := config.usage.treeUsage(path.Join(name, entry.Name()))
+			if !ok {
+				report.Ready = false
+			}
+			item.Size, item.Files, item.Dirs = usage.Size, usage.Files, usage.Dirs
+			report.Files += usage.Files
+			report.Dirs += usage.Dirs + 1
+		} else {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			item.Path = config.basePath + downloadPath(entryRel)
+			item.Size = info.Size()
+			report.Files++
+		}
+		report.Size += item.Size
+		report.Entries = append(report.Entries, item)
+	}
+
+	sort.SliceStable(report.Entries, func(i, j int) bool {
+		if report.Entries[i].Size != report.Entries[j].Size {
+			return report.Entries[i].Size > report.Entries[j].Size
+		}
+		return report.Entries[i].Name < report.Entries[j].Name
+	})
+	return report, nil
+}
+
+// 处理用量页面请求：GET /usage/<目录>，以JSON方式请求时返回JSON，否则显示矩形树图和列表
+func handleUsagePage(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	urlRelativePath := strings.TrimPrefix(r.URL.Path, "/usage/")
+	cleaned, name, err := config.paths.validate(urlRelativePath)
+	if err != nil {
+		httpError(w, r, http.StatusForbidden, "forbidden_path")
+		return
+	}
+	if cleaned == "." {
+		cleaned = ""
+	}
+
+	info, err := config.store.Stat(name)
+	if err != nil || !info.IsDir() {
+		httpError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	report, err := buildUsageReport(config, cleaned, name)
+	if err != nil {
+		httpError(w, r, http.StatusInternalServerError, "read_dir_failed")
+		return
+	}
+	if errorFormat(r) == "json" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	renderUsagePage(w, r, config, cleaned, report)
+}
+
+// 矩形树图中一个格子的位置（百分比）
+type treemapRect struct {
+	X, Y, W, H float64
+}
+
+// 矩形树图中的一个格子
+type treemapCell struct {
+	treemapRect
+	Name     string
+	URL      string // 为空表示合并的其余项
+	Class    string // 按类型着色的CSS类
+	SizeText string
+}
+
+// 按 squarified 算法把面积从大到小排好序的值排进矩形，每一行中加入格子直到最差的长宽比变大
+func squarify(values []float64, x, y, w, h float64) []treemapRect {
+	rects := make([]treemapRect, 0, len(values))
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	if total <= 0 {
+		return rects
+	}
+	areas := make([]float64, len(values))
+	for i, v := range values {
+		areas[i] = v * w * h / total
+	}
+
+	for start := 0; start < len(areas); {
+		short := math.Min(w, h)
+		end := start + 1
+		for end < len(areas) && worstRatio(areas[start:end+1], short) <= worstRatio(areas[start:end], short) {
+			end++
+		}
+
+		var sum float64
+		for _, a := range areas[start:end] {
+			sum += a
+		}
+		thickness := sum / short
+		offset := 0.0
+		for _, a := range areas[start:end] {
+			length := a / thickness
+			if w >= h {
+				rects = append(rects, treemapRect{X: x, Y: y + offset, W: thickness, H: length})
+			} else {
+				rects = append(rects, treemapRect{X: x + offset, Y: y, W: length, H: thickness})
+			}
+			offset += length
+		}
+		if w >= h {
+			x += thickness
+			w -= thickness
+		} else {
+			y += thickness
+			h -= thickness
+		}
+		start = end
+	}
+	return rects
+}
+
+// 一行格子沿长度为 short 的边排列时最差的长宽比
+func worstRatio(row []float64, short float64) float64 {
+	var sum float64
+	min, max := row[0], row[0]
+	for _, a := range row {
+		sum += a
+		min = math.Min(min, a)
+		max = math.Max(max, a)
+	}
+	s2, w2 := sum*sum, short*short
+	return math.Max(w2*max/s2, s2/(w2*min))
+}
+
+// 生成矩形树图的格子，跳过空的条目，超出数量的条目合并成一个
+func buildTreemap(lang string, entries []usageEntry) []treemapCell {
+	var cells []treemapCell
+	var values []float64
+	var otherSize int64
+	otherCount := 0
+	for _, entry := range entries {
+		if entry.Size <= 0 {
+			continue
+		}
+		if len(cells) >= treemapMaxCells {
+			otherSize += entry.Size
+			otherCount++
+			continue
+		}
+		class := "treemap-folder"
+		if !entry.IsDir {
+			class = "treemap-" + fileCategory(entry.Name)
+		}
+		cells = append(cells, treemapCell{Name: entry.Name, URL: entry.Path, Class: class, SizeText: humanizeSize(entry.Size)})
+		values = append(values, float64(entry.Size))
+	}
+	if otherCount > 0 {
+		cells = append(cells, treemapCell{Name: translate(lang, "usage.other", otherCount), Class: "treemap-other", SizeText: humanizeSize(otherSize)})
+		values = append(values, float64(otherSize))
+	}
+
+	// 合并的格子不一定最小，排进矩形前按大小重新排序
+	order := make([]int, len(cells))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return values[order[i]] > values[order[j]] })
+	sorted := make([]float64, len(order))
+	for i, idx := range order {
+		sorted[i] = values[idx]
+	}
+
+	result := make([]treemapCell, len(cells))
+	for i, rect := range squarify(sorted, 0, 0, treemapWidth, treemapHeight) {
+		cell := cells[order[i]]
+		cell.treemapRect = treemapRect{
+			X: percent(rect.X, treemapWidth),
+			Y: percent(rect.Y, treemapHeight),
+			W: percent(rect.W, treemapWidth),
+			H: percent(rect.H, treemapHeight),
+		}
+		result[i] = cell
+	}
+	return result
+}
+
+// 换算成百分比，保留3位小数
+func percent(v, total float64) float64 {
+	return math.Round(v/total*100000) / 1000
+}
+
+// 列表中的一行
+type usageEntryView struct {
+	usageEntry
+	Icon     string
+	SizeText string
+	Items    int     // 目录中的文件和子目录总数
+	Percent  float64 // 占当前目录的百分比
+}
+
+// 渲染用量页面
+func renderUsagePage(w http.ResponseWriter, r *http.Request, config *ServerConfig, relPath string, report usageReport) {
+	lang := requestLanguage(r)
+	views := make([]usageEntryView, 0, len(report.Entries))
+	for _, entry := range report.Entries {
+		view := usageEntryView{usageEntry: entry, Icon: "folder", SizeText: humanizeSize(entry.Size), Items: entry.Files + entry.Dirs}
+		if !entry.IsDir {
+			view.Icon = fileCategory(entry.Name)
+		}
+		if report.Size > 0 {
+			view.Percent = percent(float64(entry.Size), float64(report.Size))
+		}
+		views = append(views, view)
+	}
+
+	// 根目录没有上级目录
+	browseURL := config.basePath + "/"
+	parentURL := ""
+	if relPath != "" {
+		browseURL = config.basePath + (&url.URL{Path: "/" + relPath + "/"}).EscapedPath()
+		parent := path.Dir(relPath)
+		if parent == "." {
+			parent = ""
+		}
+		parentURL = config.basePath + usagePath(parent)
+	}
+
+	data := struct {
+		Lang      string
+		Theme     string
+		BasePath  string
+		Path      string
+		BrowseURL string
+		ParentURL string
+		Report    usageReport
+		SizeText  string
+		Treemap   []treemapCell
+		Entries   []usageEntryView
+	}{
+		Lang:      lang,
+		Theme:     requestTheme(r),
+		BasePath:  config.basePath,
+		Path:      report.Path,
+		BrowseURL: browseURL,
+		ParentURL: parentURL,
+		Report:    report,
+		SizeText:  humanizeSize(report.Size),
+		Treemap:   buildTreemap(lang, report.Entries),
+		Entries:   views,
+	}
+
+	var buf bytes.Buffer
+	if err := config.assets.templates.ExecuteTemplate(&buf, "usage.html", data); err != nil {
+		log.Printf("模板执行错误: %v", err)
+		httpError(w, r, http.StatusInternalServerError, "template_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
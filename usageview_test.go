@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSquarify(t *testing.T) {
+	values := []float64{6, 6, 4, 3, 2, 2, 1}
+	rects := squarify(values, 0, 0, 6, 4)
+	if len(rects) != len(values) {
+		t.Fatalf("got %d rects, want %d", len(rects), len(values))
+	}
+	// 每个格子的面积与值成正比，且都在矩形之内
+	for i, rect := range rects {
+		if area := rect.W * rect.H; math.Abs(area-values[i]) > 1e-9 {
+			t.Errorf("rect %d area = %v, want %v", i, area, values[i])
+		}
+		if rect.X < -1e-9 || rect.Y < -1e-9 || rect.X+rect.W > 6+1e-9 || rect.Y+rect.H > 4+1e-9 {
+			t.Errorf("rect %d = %+v is outside the bounds", i, rect)
+		}
+	}
+	if rects := squarify([]float64{0, 0}, 0, 0, 1, 1); len(rects) != 0 {
+		t.Errorf("zero values: %d rects, want 0", len(rects))
+	}
+}
+
+func TestBuildTreemap(t *testing.T) {
+	entries := []usageEntry{{Name: "dir", IsDir: true, Size: 500, Path: "/usage/dir/"}}
+	for i := 0; i < treemapMaxCells+5; i++ {
+		entries = append(entries, usageEntry{Name: fmt.Sprintf("f%d.jpg", i), Size: 1, Path: "/download/f.jpg"})
+	}
+	entries = append(entries, usageEntry{Name: "empty.txt"})
+
+	cells := buildTreemap("en", entries)
+	if len(cells) != treemapMaxCells+1 {
+		t.Fatalf("got %d cells, want %d", len(cells), treemapMaxCells+1)
+	}
+	if cells[0].Name != "dir" || cells[0].Class != "treemap-folder" || cells[0].URL != "/usage/dir/" {
+		t.Errorf("first cell = %+v", cells[0])
+	}
+	// 超出数量的条目合并成一个没有链接的格子
+	var other *treemapCell
+	var area float64
+	for i := range cells {
+		area += cells[i].W * cells[i].H
+		if cells[i].Class == "treemap-other" {
+			other = &cells[i]
+		}
+		if cells[i].Name == "empty.txt" {
+			t.Error("empty entry has a cell")
+		}
+	}
+	if other == nil || other.URL != "" || !strings.Contains(other.Name, "6") {
+		t.Errorf("merged cell = %+v", other)
+	}
+	if math.Abs(area-100*100) > 1 {
+		t.Errorf("cells cover %v%%², want the whole map", area)
+	}
+}
+
+func TestUsagePage(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "big/a.bin", strings.Repeat("x", 100))
+	writeTestFile(t, config, "big/sub/b.bin", strings.Repeat("x", 50))
+	writeTestFile(t, config, "small.txt", "12")
+	refreshUsageTree(t, config.usage)
+
+	r := httptest.NewRequest(http.MethodGet, "/usage/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := serve(config, r)
+	var report usageReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("report = %s: %v", w.Body, err)
+	}
+	if !report.Ready || report.Size != 152 || report.Files != 3 || report.Dirs != 2 || len(report.Entries) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	// 按大小从大到小，回收站等内部目录不列出
+	if e := report.Entries[0]; e.Name != "big" || !e.IsDir || e.Size != 150 || e.Path != "/usage/big/" {
+		t.Errorf("first entry = %+v", e)
+	}
+	if e := report.Entries[1]; e.Name != "small.txt" || e.Path != "/download/small.txt" {
+		t.Errorf("second entry = %+v", e)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/usage/big/", nil)
+	r.Header.Set("Accept", "text/html")
+	if w := serve(config, r); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "treemap") {
+		t.Errorf("usage page: status = %d", w.Code)
+	}
+
+	// 列表中的目录显示递归统计的大小
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/", nil)); !strings.Contains(w.Body.String(), humanizeSize(150)) {
+		t.Error("listing does not show the directory size")
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/usage/small.txt", http.StatusNotFound},
+		{"/usage/missing/", http.StatusNotFound},
+		{"/usage/.trash/", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := serve(config, httptest.NewRequest(http.MethodGet, tt.path, nil)); w.Code != tt.status {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+}
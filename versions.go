@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 历史版本目录名，位于共享目录的根目录下；每个文件的历史版本保存在 .versions/<文件的相对路径>/ 中
+const versionsDirName = ".versions"
+
+// 文件的一个历史版本，内容保存在 <id>，元数据保存在 <id>.json
+type fileVersion struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`       // 该版本原来的修改时间
+	Saved   time.Time `json:"saved"`          // 被覆盖的时间
+	User    string    `json:"user"`           // 覆盖它的用户
+	Scan    string    `json:"scan,omitempty"` // 下载前扫描的结果，没有扫描过时为空
+}
+
+// 版本操作的错误
+var (
+	errVersionNotFound  = errors.New("version_not_found")
+	errVersionTargetDir = errors.New("version_target_is_dir") // 文件的位置现在是目录
+)
+
+// 历史版本存储 - 上传覆盖文件时保存原来的版本，按数量和保存时间清理
+type versionStore struct {
+	mu          sync.Mutex
+	store       storage
+	maxVersions int
+	maxAge      time.Duration
+	usage       *usageTracker
+}
+
+// 根据配置创建版本存储，关闭时返回nil
+func newVersionStore(settings VersionSettings, store storage, usage *usageTracker) (*versionStore, error) {
+	if settings.Disabled || isReadOnlyStorage(store) {
+		return nil, nil
+	}
+	v := &versionStore{
+		store:       store,
+		maxVersions: settings.MaxVersions,
+		maxAge:      time.Duration(settings.MaxAgeDays) * 24 * time.Hour,
+		usage:       usage,
+	}
+	if err := storageMkdirAll(store, versionsDirName); err != nil {
+		return nil, err
+	}
+	go v.expireLoop()
+	return v, nil
+}
+
+// 文件（存储中的名称）的版本目录
+func (v *versionStore) fileDir(name string) string {
+	return path.Join(versionsDirName, name)
+}
+
+// 把即将被覆盖的文件保存为历史版本
+func (v *versionStore) save(name, user string) (fileVersion, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	dir := v.fileDir(name)
+	version, err := v.keep(dir, name, user)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	v.prune(dir, version.Saved)
+	return version, nil
+}
+
+// 把文件移到版本目录中并写入元数据；调用时需持有锁
+func (v *versionStore) keep(dir, name, user string) (fileVersion, error) {
+	info, err := v.store.Stat(name)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fileVersion{}, err
+	}
+	now := time.Now()
+	version := fileVersion{
+		ID:      now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Saved:   now,
+		User:    user,
+	}
+
+	if err := storageMkdirAll(v.store, dir); err != nil {
+		return fileVersion{}, err
+	}
+	data, err := json.MarshalIndent(version, "", "  ")
+	if err != nil {
+		return fileVersion{}, err
+	}
+	if err := storageWriteFile(v.store, path.Join(dir, version.ID+".json"), data); err != nil {
+		return fileVersion{}, err
+	}
+	if err := v.store.Rename(name, path.Join(dir, version.ID)); err != nil {
+		v.store.Remove(path.Join(dir, version.ID+".json"))
+		return fileVersion{}, err
+	}
+	return version, nil
+}
+
+// 把刚保存的版本放回原处，用于覆盖它的上传失败时
+func (v *versionStore) putBack(name, id string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	dir := v.fileDir(name)
+	if err := v.store.Rename(path.Join(dir, id), name); err != nil {
+		log.Printf("恢复被覆盖的文件失败: %v (文件: %s)", err, name)
+		return
+	}
+	v.store.Remove(path.Join(dir, id+".json"))
+}
+
+// 读取目录中的所有版本，从新到旧；调用时需持有锁
+func (v *versionStore) readVersions(dir string) []fileVersion {
+	entries, _ := v.store.ReadDir(dir)
+	versions := make([]fileVersion, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := fs.ReadFile(v.store, path.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var version fileVersion
+		if err := json.Unmarshal(data, &version); err != nil || version.ID == "" {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Saved.After(versions[j].Saved) })
+	return versions
+}
+
+// 文件的所有历史版本，从新到旧
+func (v *versionStore) list(name string) []fileVersion {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.readVersions(v.fileDir(name))
+}
+
+// 文件（存储中的名称）是否有历史版本
+func (v *versionStore) has(name string) bool {
+	entries, _ := v.store.ReadDir(v.fileDir(name))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			return true
+		}
+	}
+	return false
+}
+
+// 查找一个版本，返回其内容在存储中的名称
+func (v *versionStore) find(name, id string) (fileVersion, string, error) {
+	if !validItemID(id) {
+		return fileVersion{}, "", errVersionNotFound
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	dir := v.fileDir(name)
+	data, err := fs.ReadFile(v.store, path.Join(dir, id+".json"))
+	if err != nil {
+		return fileVersion{}, "", errVersionNotFound
+	}
+	var version fileVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		return fileVersion{}, "", err
+	}
+	return version, path.Join(dir, id), nil
+}
+
+// 记录版本内容的扫描结果
+func (v *versionStore) markScanned(name, id, status string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	metaName := path.Join(v.fileDir(name), id+".json")
+	data, err := fs.ReadFile(v.store, metaName)
+	if err != nil {
+		return
+	}
+	var version fileVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		return
+	}
+	version.Scan = status
+	if data, err = json.MarshalIndent(version, "", "  "); err == nil {
+		err = storageWriteFile(v.store, metaName, data)
+	}
+	if err != nil {
+		log.Printf("记录历史版本的扫描结果失败: %v (文件: %s, 版本: %s)", err, name, id)
+	}
+}
+
+// 版本内容的扫描状态，为空时可以下载。启用病毒扫描时，版本保存前可能还没有扫描完、隔离失败，
+// 或是在启用扫描之前上传的，所以没有扫描过的版本先提交扫描，等待配置的时间，结果在扫描完成后记入元数据
+func versionScanStatus(config *ServerConfig, r *http.Request, name string, version fileVersion, versionName string) string {
+	switch {
+	case version.Scan == "clean":
+		return ""
+	case version.Scan != "":
+		return version.Scan
+	case config.scanner == nil:
+		return ""
+	}
+	if status := config.scanner.status(versionName); status != "" {
+		return status
+	}
+	job := config.scanner.submit(versionName, uploadUser(r, config))
+	go func() {
+		<-job.done
+		config.versions.markScanned(name, version.ID, job.outcome.Status)
+	}()
+	config.scanner.await([]*scanJob{job})
+	select {
+	case <-job.done:
+		if job.outcome.Status == "clean" {
+			return ""
+		}
+		return job.outcome.Status
+	default:
+		return "pending"
+	}
+}
+
+// 恢复一个版本：当前的文件先保存为新的版本，再把选中的版本放回原处，与上传覆盖一样计入用量；
+// 恢复完成后才按数量清理，避免选中的版本被清理掉。name 必须是经过路径策略检查的名称
+func (v *versionStore) restore(name, id, user string) (fileVersion, error) {
+	version, _, err := v.find(name, id)
+	if err != nil {
+		return fileVersion{}, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	dir := v.fileDir(name)
+	info, err := v.store.Stat(path.Join(dir, id))
+	if err != nil {
+		return fileVersion{}, errVersionNotFound
+	}
+	current, err := v.store.Lstat(name)
+	switch {
+	case err == nil && current.IsDir():
+		return fileVersion{}, errVersionTargetDir
+	case err == nil:
+		if _, err := v.keep(dir, name, user); err != nil {
+			return fileVersion{}, err
+		}
+		v.usage.recordRemove(name)
+	case !errors.Is(err, fs.ErrNotExist):
+		return fileVersion{}, err
+	}
+	if err := storageMkdirAll(v.store, path.Dir(name)); err != nil {
+		return fileVersion{}, err
+	}
+	if err := v.store.Rename(path.Join(dir, id), name); err != nil {
+		return fileVersion{}, err
+	}
+	v.usage.recordUpload(user, name, info.Size(), 0)
+	v.store.Remove(path.Join(dir, id+".json"))
+	v.prune(dir, time.Now())
+	return version, nil
+}
+
+// 删除超出数量和过期的版本；调用时需持有锁
+func (v *versionStore) prune(dir string, now time.Time) {
+	for i, version := range v.readVersions(dir) {
+		tooMany := v.maxVersions > 0 && i >= v.maxVersions
+		expired := v.maxAge > 0 && now.Sub(version.Saved) > v.maxAge
+		if !tooMany && !expired {
+			continue
+		}
+		v.store.Remove(path.Join(dir, version.ID))
+		v.store.Remove(path.Join(dir, version.ID+".json"))
+	}
+	// 没有版本的目录一并删除
+	if dir != versionsDirName {
+		if entries, err := v.store.ReadDir(dir); err == nil && len(entries) == 0 {
+			v.store.Remove(dir)
+		}
+	}
+}
+
+// 每小时清理一次所有文件的过期版本
+func (v *versionStore) expireLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		v.mu.Lock()
+		var dirs []string
+		fs.WalkDir(v.store, versionsDirName, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				dirs = append(dirs, p)
+			}
+			return nil
+		})
+		// 从最深的目录开始，子目录清空后上级目录也能删除
+		for i := len(dirs) - 1; i >= 0; i-- {
+			v.prune(dirs[i], now)
+		}
+		v.mu.Unlock()
+	}
+}
+
+// 版本页面中的一项
+type versionView struct {
+	fileVersion
+	SizeText    string
+	ModTimeText string
+	SavedText   string
+}
+
+// 处理历史版本请求：
+// GET /versions/<文件> 列出版本，GET /versions/<文件>?download=<id> 下载版本，POST /versions/<文件>?restore=<id> 恢复版本；
+// 页面提交的表单完成后返回版本页面，其他请求返回JSON
+func handleVersions(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	store := config.versions
+	urlRelativePath := strings.TrimPrefix(r.URL.Path, "/versions/")
+	cleaned, name, err := config.paths.validate(urlRelativePath)
+	if err != nil || cleaned == "" || cleaned == "." {
+		httpError(w, r, http.StatusForbidden, "forbidden_path")
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("download") != "":
+		version, versionName, err := store.find(name, query.Get("download"))
+		if err != nil {
+			httpError(w, r, http.StatusNotFound, "version_not_found")
+			return
+		}
+		info, err := config.store.Stat(versionName)
+		if err != nil {
+			httpError(w, r, http.StatusNotFound, "version_not_found")
+			return
+		}
+		switch versionScanStatus(config, r, name, version, versionName) {
+		case "":
+		case "pending":
+			httpError(w, r, http.StatusLocked, "scan_pending")
+			return
+		default:
+			httpError(w, r, http.StatusForbidden, "file_blocked")
+			return
+		}
+		release, ok := config.limiter.acquireDownload()
+		if !ok {
+			tooManyRequests(w, r, 5*time.Second, "too_many_downloads")
+			return
+		}
+		defer release()
+		fileName := path.Base(cleaned)
+		ext := path.Ext(fileName)
+		fileName = strings.TrimSuffix(fileName, ext) + " (" + version.ModTime.Format("2006-01-02 150405") + ")" + ext
+		serveFile(w, r, config.store, versionName, fileName, info, config.limiter)
+
+	case r.Method == http.MethodGet:
+		versions := store.list(name)
+		if errorFormat(r) == "json" {
+			writeJSON(w, http.StatusOK, versions)
+			return
+		}
+		renderVersionsPage(w, r, config, cleaned, name, versions)
+
+	case r.Method == http.MethodPost && query.Get("restore") != "":
+		// 与上传覆盖一样，当前的文件还在等待扫描时不能替换
+		if config.scanner != nil && config.scanner.status(name) == "pending" {
+			httpError(w, r, http.StatusConflict, "scan_in_progress")
+			return
+		}
+		id := query.Get("restore")
+		user := uploadUser(r, config)
+		version, err := store.restore(name, id, user)
+		if errors.Is(err, errVersionNotFound) {
+			httpError(w, r, http.StatusNotFound, "version_not_found")
+			return
+		}
+		if errors.Is(err, errVersionTargetDir) {
+			httpError(w, r, http.StatusConflict, "version_target_is_dir")
+			return
+		}
+		if err != nil {
+			log.Printf("恢复历史版本失败: %v (文件: %s, 版本: %s)", err, cleaned, id)
+			httpError(w, r, http.StatusInternalServerError, "version_restore_failed")
+			return
+		}
+		log.Printf("已恢复历史版本: %s (版本: %s, 用户: %s)", cleaned, id, user)
+		// 没有扫描过的版本恢复后与新上传的文件一样先扫描
+		if config.scanner != nil && version.Scan != "clean" {
+			config.scanner.submit(name, user)
+		}
+		if errorFormat(r) == "json" {
+			writeJSON(w, http.StatusOK, map[string]string{"path": cleaned, "restored": id})
+			return
+		}
+		http.Redirect(w, r, config.basePath+versionsPath(cleaned), http.StatusSeeOther)
+
+	default:
+		httpError(w, r, http.StatusMethodNotAllowed, "unsupported_method")
+	}
+}
+
+// 文件的版本页面URL（不含URL前缀）
+func versionsPath(relativePath string) string {
+	return (&url.URL{Path: "/versions/" + relativePath}).EscapedPath()
+}
+
+// 渲染版本页面
+func renderVersionsPage(w http.ResponseWriter, r *http.Request, config *ServerConfig, relPath, name string, versions []fileVersion) {
+	views := make([]versionView, 0, len(versions))
+	for _, version := range versions {
+		views = append(views, versionView{
+			fileVersion: version,
+			SizeText:    humanizeSize(version.Size),
+			ModTimeText: version.ModTime.Format("2006-01-02 15:04:05"),
+			SavedText:   version.Saved.Format("2006-01-02 15:04:05"),
+		})
+	}
+	data := struct {
+		Lang        string
+		Theme       string
+		BasePath    string
+		Name        string
+		PageURL     string
+		DownloadURL string
+		ParentURL   string
+		Current     *FileInfo
+		Versions    []versionView
+		MaxVersions int
+		MaxAgeDays  int
+	}{
+		Lang:        requestLanguage(r),
+		Theme:       requestTheme(r),
+		BasePath:    config.basePath,
+		Name:        relPath,
+		PageURL:     config.basePath + versionsPath(relPath),
+		DownloadURL: config.basePath + "/download/" + relPath,
+		ParentURL:   prepareParentPath("/"+relPath, config.basePath),
+		Versions:    views,
+		MaxVersions: config.settings.Versions.MaxVersions,
+		MaxAgeDays:  config.settings.Versions.MaxAgeDays,
+	}
+	if info, err := config.store.Stat(name); err == nil && !info.IsDir() {
+		current := FileInfo{Size: humanizeSize(info.Size()), ModTime: info.ModTime().Format("2006-01-02 15:04:05")}
+		data.Current = &current
+	}
+
+	var buf bytes.Buffer
+	if err := config.assets.templates.ExecuteTemplate(&buf, "versions.html", data); err != nil {
+		log.Printf("模板执行错误: %v", err)
+		httpError(w, r, http.StatusInternalServerError, "template_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
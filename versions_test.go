@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在内存存储上创建版本存储
+func newTestVersionStore(t *testing.T, settings VersionSettings) (*versionStore, storage) {
+	t.Helper()
+	store := newMemStorage()
+	usage, err := newUsageTracker(store, nil, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := newVersionStore(settings, store, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return versions, store
+}
+
+func TestVersionStoreSave(t *testing.T) {
+	versions, store := newTestVersionStore(t, VersionSettings{MaxVersions: 2})
+	for _, content := range []string{"v1", "v2", "v3"} {
+		writeStoreFile(t, store, "a.txt", content)
+		if _, err := versions.save("a.txt", "tester"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := store.Stat("a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("saved file is still in place: %v", err)
+	}
+	if !versions.has("a.txt") {
+		t.Error("has = false after saving versions")
+	}
+
+	// 超出数量时只保留最新的版本
+	list := versions.list("a.txt")
+	if len(list) != 2 {
+		t.Fatalf("versions = %+v, want 2", list)
+	}
+	_, versionName, err := versions.find("a.txt", list[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(store, versionName); string(data) != "v3" {
+		t.Errorf("newest version = %q, want v3", data)
+	}
+}
+
+func TestVersionStoreRestore(t *testing.T) {
+	versions, store := newTestVersionStore(t, VersionSettings{})
+	writeStoreFile(t, store, "docs/a.txt", "old")
+	version, err := versions.save("docs/a.txt", "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeStoreFile(t, store, "docs/a.txt", "new")
+
+	// 当前的文件保存为新的版本
+	if _, err := versions.restore("docs/a.txt", version.ID, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := fs.ReadFile(store, "docs/a.txt"); string(data) != "old" {
+		t.Errorf("restored content = %q, want old", data)
+	}
+	list := versions.list("docs/a.txt")
+	if len(list) != 1 || list[0].Size != 3 || list[0].ID == version.ID {
+		t.Errorf("versions after restore = %+v", list)
+	}
+	if _, err := versions.restore("docs/a.txt", version.ID, "tester"); !errors.Is(err, errVersionNotFound) {
+		t.Errorf("restoring twice: err = %v, want errVersionNotFound", err)
+	}
+}
+
+func TestVersionStoreFindRejectsInvalidIDs(t *testing.T) {
+	versions, _ := newTestVersionStore(t, VersionSettings{})
+	for _, id := range []string{"", "..", "../x", `a\b`, "a.json", "missing"} {
+		if _, _, err := versions.find("a.txt", id); !errors.Is(err, errVersionNotFound) {
+			t.Errorf("find(%q): err = %v, want errVersionNotFound", id, err)
+		}
+	}
+}
+
+func TestVersionStorePruneExpired(t *testing.T) {
+	versions, store := newTestVersionStore(t, VersionSettings{MaxAgeDays: 1})
+	writeStoreFile(t, store, "a.txt", "old")
+	if _, err := versions.save("a.txt", "tester"); err != nil {
+		t.Fatal(err)
+	}
+
+	versions.mu.Lock()
+	versions.prune(versions.fileDir("a.txt"), time.Now().Add(25*time.Hour))
+	versions.mu.Unlock()
+	if list := versions.list("a.txt"); len(list) != 0 {
+		t.Errorf("expired versions were kept: %+v", list)
+	}
+	// 没有版本的目录一并删除
+	if _, err := store.Stat(versions.fileDir("a.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("empty version directory was kept: %v", err)
+	}
+}
+
+func TestVersionsHandler(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	for _, content := range []string{"first", "second"} {
+		if w := serve(config, newUploadRequest(t, "/upload/", map[string]string{"a.txt": content})); w.Code != http.StatusOK {
+			t.Fatalf("upload: status = %d, body = %s", w.Code, w.Body)
+		}
+	}
+	if items, _ := config.trash.list(); len(items) != 0 {
+		t.Errorf("overwritten file went to the trash: %+v", items)
+	}
+
+	// 列表中有历史版本的链接
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/", nil)); !strings.Contains(w.Body.String(), `href="/versions/a.txt"`) {
+		t.Error("listing has no versions link")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/versions/a.txt", nil)
+	r.Header.Set("Accept", "application/json")
+	w := serve(config, r)
+	var versions []fileVersion
+	if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil || len(versions) != 1 {
+		t.Fatalf("versions = %s, %v", w.Body, err)
+	}
+	id := versions[0].ID
+
+	w = serve(config, httptest.NewRequest(http.MethodGet, "/versions/a.txt?download="+id, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "first" {
+		t.Errorf("download version: status = %d, body = %q", w.Code, w.Body)
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, "a (") {
+		t.Errorf("Content-Disposition = %q, want the version time in the name", disposition)
+	}
+
+	w = serve(config, httptest.NewRequest(http.MethodPost, "/versions/a.txt?restore="+id, nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/versions/a.txt" {
+		t.Errorf("restore: status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if data, _ := fs.ReadFile(config.store, "a.txt"); string(data) != "first" {
+		t.Errorf("restored content = %q, want first", data)
+	}
+
+	tests := []struct {
+		method, target string
+		status         int
+		code           string
+	}{
+		{http.MethodGet, "/versions/a.txt?download=missing", http.StatusNotFound, "version_not_found"},
+		{http.MethodPost, "/versions/a.txt?restore=missing", http.StatusNotFound, "version_not_found"},
+		{http.MethodPost, "/versions/a.txt", http.StatusMethodNotAllowed, "unsupported_method"},
+		{http.MethodGet, "/versions/.versions/a.txt", http.StatusForbidden, "forbidden_path"},
+	}
+	for _, tt := range tests {
+		w := serve(config, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.status || w.Header().Get("X-Error-Code") != tt.code {
+			t.Errorf("%s %s: status = %d, X-Error-Code = %q, want %d %s", tt.method, tt.target, w.Code, w.Header().Get("X-Error-Code"), tt.status, tt.code)
+		}
+	}
+}
+
+// 启用历史版本的测试配置，上传两次生成一个版本
+func newVersionTestConfig(t *testing.T) (*ServerConfig, storage, string) {
+	t.Helper()
+	store := newMemStorage()
+	settings := defaultSettings()
+	settings.Trash.Disabled = true
+	settings.Duplicates.Disabled = true
+	config := newTestConfig(t, settings, store)
+	for _, content := range []string{"first", "second version"} {
+		if w := serve(config, newUploadRequest(t, "/upload/", map[string]string{"a.txt": content})); w.Code != http.StatusOK {
+			t.Fatalf("upload: status = %d: %s", w.Code, w.Body)
+		}
+	}
+	versions := config.versions.list("a.txt")
+	if len(versions) != 1 {
+		t.Fatalf("versions = %+v, want 1", versions)
+	}
+	return config, store, versions[0].ID
+}
+
+func TestVersionRestore(t *testing.T) {
+	config, store, id := newVersionTestConfig(t)
+	r := httptest.NewRequest(http.MethodPost, "/versions/a.txt?restore="+id, nil)
+	user := uploadUser(r, config)
+	if got := config.usage.userUsage(user); got != int64(len("second version")) {
+		t.Fatalf("usage before restore = %d", got)
+	}
+
+	w := serve(config, r)
+	if w.Code != http.StatusSeeOther && w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", w.Code, w.Body)
+	}
+	if data, err := fs.ReadFile(store, "a.txt"); err != nil || string(data) != "first" {
+		t.Errorf("a.txt = %q, %v, want first", data, err)
+	}
+	// 被替换的文件保存为新的版本，用量按恢复的内容计算
+	if versions := config.versions.list("a.txt"); len(versions) != 1 || versions[0].Size != int64(len("second version")) {
+		t.Errorf("versions after restore = %+v", versions)
+	}
+	if got := config.usage.userUsage(user); got != int64(len("first")) {
+		t.Errorf("usage after restore = %d, want %d", got, len("first"))
+	}
+}
+
+func TestVersionRestoreOverDirectory(t *testing.T) {
+	config, store, id := newVersionTestConfig(t)
+	if err := store.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	store.Mkdir("a.txt")
+
+	w := serve(config, httptest.NewRequest(http.MethodPost, "/versions/a.txt?restore="+id, nil))
+	if w.Code != http.StatusConflict || w.Header().Get("X-Error-Code") != "version_target_is_dir" {
+		t.Errorf("status = %d, code = %q, want 409 version_target_is_dir", w.Code, w.Header().Get("X-Error-Code"))
+	}
+	if info, err := store.Stat("a.txt"); err != nil || !info.IsDir() {
+		t.Errorf("directory was replaced: %v", err)
+	}
+}
+
+func TestVersionRestoreHiddenPath(t *testing.T) {
+	config, _, id := newVersionTestConfig(t)
+	// 后台统计用量时会读取隐藏规则，等它完成后再修改
+	refreshUsageTree(t, config.usage)
+	rule, _, err := parseIgnoreRule("", "*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.paths.rules = append(config.paths.rules, rule)
+
+	w := serve(config, httptest.NewRequest(http.MethodPost, "/versions/a.txt?restore="+id, nil))
+	if w.Code != http.StatusForbidden || w.Header().Get("X-Error-Code") != "forbidden_path" {
+		t.Errorf("status = %d, code = %q, want 403 forbidden_path", w.Code, w.Header().Get("X-Error-Code"))
+	}
+}
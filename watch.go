@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 目录变化事件，通过SSE推送给正在浏览该目录的页面
+type dirEvent struct {
+	Type    string    `json:"type"`               // create, delete, rename, modify，事件丢失时为 reload
+	Name    string    `json:"name,omitempty"`     // 条目名称
+	OldName string    `json:"old_name,omitempty"` // 重命名前的名称
+	File    *FileInfo `json:"file,omitempty"`     // 条目信息，删除时为空
+}
+
+// 目录监视的实现 - 目录是存储中的名称，检测到变化时调用 dirWatcher.notify
+type watchBackend interface {
+	watch(dir string) error
+	unwatch(dir string)
+}
+
+// 目录监视器 - 只监视有页面正在浏览的目录，把变化分发给订阅者
+type dirWatcher struct {
+	mu       sync.Mutex
+	subs     map[string]map[chan dirEvent]struct{} // 目录名称 -> 订阅者
+	backends map[string]watchBackend               // 目录名称 -> 正在使用的监视实现
+	native   watchBackend                          // 系统提供的监视（inotify），不可用或不是本地存储时为nil
+	poller   watchBackend                          // 轮询，系统监视不可用或失败时使用
+	store    storage
+	basePath string
+	paths    *pathPolicy // 隐藏的文件不推送
+
+	// 在推送的文件信息中标出扫描状态和历史版本，dir 是目录在存储中的名称
+	annotate func(dir string, files []FileInfo)
+}
+
+// 根据配置创建目录监视器，关闭实时更新时返回nil
+func newDirWatcher(settings WatchSettings, store storage, basePath string, paths *pathPolicy) (*dirWatcher, error) {
+	w := &dirWatcher{
+		subs:     make(map[string]map[chan dirEvent]struct{}),
+		backends: make(map[string]watchBackend),
+		store:    store,
+		basePath: basePath,
+		paths:    paths,
+	}
+	interval := time.Duration(settings.PollInterval) * time.Second
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	switch settings.Mode {
+	case "off":
+		return nil, nil
+	case "poll":
+		w.poller = newPollBackend(store, interval, w.notify)
+	case "", "auto":
+		// 系统目录监视只能用于磁盘上的目录，其他存储使用轮询
+		if root, ok := storageLocalPath(store, "."); ok {
+			native, err := newNativeBackend(root, w.notify)
+			if err != nil {
+				log.Printf("系统目录监视不可用，改为每 %v 轮询: %v", interval, err)
+			}
+			w.native = native
+		}
+		w.poller = newPollBackend(store, interval, w.notify)
+	default:
+		return nil, fmt.Errorf("无效的 watch.mode: %s", settings.Mode)
+	}
+	return w, nil
+}
+
+// 订阅目录的变化，返回事件通道和取消订阅的函数
+func (w *dirWatcher) subscribe(dir string) (<-chan dirEvent, func(), error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.backends[dir]; !ok {
+		backend := w.poller
+		if w.native != nil {
+			if err := w.native.watch(dir); err != nil {
+				// 如超出系统的监视数上限，该目录改为轮询
+				log.Printf("监视目录失败，改为轮询: %v (目录: %s)", err, dir)
+			} else {
+				backend = w.native
+			}
+		}
+		if backend == w.poller {
+			if err := w.poller.watch(dir); err != nil {
+				return nil, nil, err
+			}
+		}
+		w.backends[dir] = backend
+		w.subs[dir] = make(map[chan dirEvent]struct{})
+	}
+
+	ch := make(chan dirEvent, 64)
+	w.subs[dir][ch] = struct{}{}
+
+	cancel := func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs[dir], ch)
+		if len(w.subs[dir]) == 0 {
+			// 最后一个订阅者离开后停止监视
+			w.backends[dir].unwatch(dir)
+			delete(w.backends, dir)
+			delete(w.subs, dir)
+		}
+	}
+	return ch, cancel, nil
+}
+
+// 处理监视实现报告的变化：补上条目信息后发给该目录的所有订阅者
+func (w *dirWatcher) notify(dir, eventType, name, oldName string) {
+	w.mu.Lock()
+	subs := make([]chan dirEvent, 0, len(w.subs[dir]))
+	for ch := range w.subs[dir] {
+		subs = append(subs, ch)
+	}
+	w.mu.Unlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	rel := dir
+	if rel == "." {
+		rel = ""
+	}
+
+	event := dirEvent{Type: eventType, Name: name, OldName: oldName}
+	if eventType == "delete" {
+		// 隐藏的文件被删除时不推送，以免泄露名称
+		if w.paths.hidden(path.Join(rel, name), false) || w.paths.hidden(path.Join(rel, name), true) {
+			return
+		}
+	} else if eventType != "reload" {
+		info, err := w.store.Lstat(path.Join(dir, name))
+		if err != nil {
+			// 条目已经不存在，随后会收到删除事件
+			return
+		}
+		entries := w.paths.filter(dir, []fs.DirEntry{fs.FileInfoToDirEntry(info)})
+		files := buildFileList(entries, "/"+rel, w.basePath)
+		if w.annotate != nil {
+			w.annotate(dir, files)
+		}
+		if len(files) != 1 {
+			// 内部目录、隐藏的文件等不在列表中显示的条目；重命名成这样的名称时从页面中移除原来的条目
+			if eventType != "rename" || oldName == "" {
+				return
+			}
+			event = dirEvent{Type: "delete", Name: oldName}
+		} else {
+			event.File = &files[0]
+		}
+	}
+
+	for _, ch := range subs {
+		select {
+		case ch <- event:
+		default:
+			// 客户端处理不过来时丢弃事件，页面刷新后会重新同步
+			log.Printf("目录事件队列已满，丢弃事件: %s %s", eventType, name)
+		}
+	}
+}
+
+// 处理目录事件订阅请求（Server-Sent Events）
+func handleDirEvents(w http.ResponseWriter, r *http.Request, config *ServerConfig) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, http.StatusMethodNotAllowed, "unsupported_method")
+		return
+	}
+
+	urlRelativePath := strings.TrimPrefix(r.URL.Path, "/events/")
+	_, name, err := config.paths.validate(urlRelativePath)
+	if err != nil {
+		log.Printf("路径验证失败: %v (原始路径: %s)", err, urlRelativePath)
+		httpError(w, r, http.StatusForbidden, "forbidden_path")
+		return
+	}
+	fileInfo, err := config.store.Stat(name)
+	if err != nil || !fileInfo.IsDir() {
+		httpError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	events, cancel, err := config.watcher.subscribe(name)
+	if err != nil {
+		log.Printf("订阅目录事件失败: %v (路径: %s)", err, name)
+		httpError(w, r, http.StatusInternalServerError, "internal")
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// 让nginx等反向代理不要缓冲事件流
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	// 定期发送注释行保持连接，防止被代理或NAT因空闲断开
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
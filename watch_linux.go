@@ -0,0 +1,159 @@
+//go:build linux
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// 关心的inotify事件：新建、删除、移入移出（重命名）和写入完成
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_CLOSE_WRITE | syscall.IN_ONLYDIR
+
+// 基于inotify的目录监视，目录是共享目录中的名称
+type inotifyBackend struct {
+	fd     int
+	root   string // 共享目录的绝对路径
+	mu     sync.Mutex
+	wds    map[int32]string // 监视描述符 -> 目录
+	dirs   map[string]int32 // 目录 -> 监视描述符
+	notify func(dir, eventType, name, oldName string)
+}
+
+// 创建系统提供的目录监视
+func newNativeBackend(root string, notify func(dir, eventType, name, oldName string)) (watchBackend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	b := &inotifyBackend{
+		fd:     fd,
+		root:   root,
+		wds:    make(map[int32]string),
+		dirs:   make(map[string]int32),
+		notify: notify,
+	}
+	go b.readLoop()
+	return b, nil
+}
+
+func (b *inotifyBackend) watch(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wd, err := syscall.InotifyAddWatch(b.fd, filepath.Join(b.root, filepath.FromSlash(dir)), inotifyMask)
+	if err != nil {
+		return err
+	}
+	b.wds[int32(wd)] = dir
+	b.dirs[dir] = int32(wd)
+	return nil
+}
+
+func (b *inotifyBackend) unwatch(dir string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wd, ok := b.dirs[dir]
+	if !ok {
+		return
+	}
+	// 目录已被删除时内核已经移除了监视，忽略错误
+	syscall.InotifyRmWatch(b.fd, uint32(wd))
+	delete(b.wds, wd)
+	delete(b.dirs, dir)
+}
+
+// 移出的条目，等待同一批事件中对应的移入事件
+type pendingMove struct {
+	dir  string
+	name string
+}
+
+// 读取并分发inotify事件
+func (b *inotifyBackend) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(b.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			log.Printf("读取inotify事件失败: %v", err)
+			return
+		}
+
+		// 重命名由一对 IN_MOVED_FROM / IN_MOVED_TO 组成，通过cookie配对
+		moves := make(map[uint32]pendingMove)
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(raw.Len)]), "\x00")
+			offset = nameStart + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// 事件队列溢出，通知所有页面重新加载
+				for _, dir := range b.watchedDirs() {
+					b.notify(dir, "reload", "", "")
+				}
+				continue
+			}
+
+			b.mu.Lock()
+			dir, ok := b.wds[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 && ok {
+				// 目录被删除或移走，内核已移除监视
+				delete(b.wds, raw.Wd)
+				delete(b.dirs, dir)
+			}
+			b.mu.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+
+			switch {
+			case raw.Mask&syscall.IN_CREATE != 0:
+				b.notify(dir, "create", name, "")
+			case raw.Mask&syscall.IN_DELETE != 0:
+				b.notify(dir, "delete", name, "")
+			case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+				b.notify(dir, "modify", name, "")
+			case raw.Mask&syscall.IN_MOVED_FROM != 0:
+				moves[raw.Cookie] = pendingMove{dir: dir, name: name}
+			case raw.Mask&syscall.IN_MOVED_TO != 0:
+				from, paired := moves[raw.Cookie]
+				delete(moves, raw.Cookie)
+				switch {
+				case paired && from.dir == dir:
+					b.notify(dir, "rename", name, from.name)
+				case paired:
+					// 移到另一个目录
+					b.notify(from.dir, "delete", from.name, "")
+					b.notify(dir, "create", name, "")
+				default:
+					// 从未监视的目录移入
+					b.notify(dir, "create", name, "")
+				}
+			}
+		}
+
+		// 没有配对的移出事件：条目被移到了未监视的目录
+		for _, from := range moves {
+			b.notify(from.dir, "delete", from.name, "")
+		}
+	}
+}
+
+// 当前监视的所有目录
+func (b *inotifyBackend) watchedDirs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dirs := make([]string, 0, len(b.dirs))
+	for dir := range b.dirs {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// 创建系统提供的目录监视 - 目前只支持Linux的inotify，其他系统使用轮询
+func newNativeBackend(root string, notify func(dir, eventType, name, oldName string)) (watchBackend, error) {
+	return nil, errors.New("当前系统不支持inotify")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// 轮询时记录的条目状态
+type entryState struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// 轮询方式的目录监视 - 定期读取目录并与上次的结果比较，无法识别重命名（表现为删除加新建）
+type pollBackend struct {
+	mu       sync.Mutex
+	store    storage
+	interval time.Duration
+	stops    map[string]chan struct{} // 目录 -> 停止轮询的信号
+	notify   func(dir, eventType, name, oldName string)
+}
+
+func newPollBackend(store storage, interval time.Duration, notify func(dir, eventType, name, oldName string)) *pollBackend {
+	return &pollBackend{store: store, interval: interval, stops: make(map[string]chan struct{}), notify: notify}
+}
+
+func (p *pollBackend) watch(dir string) error {
+	snapshot, err := scanDir(p.store, dir)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.stops[dir]; ok {
+		return nil
+	}
+	stop := make(chan struct{})
+	p.stops[dir] = stop
+	go p.poll(dir, snapshot, stop)
+	return nil
+}
+
+func (p *pollBackend) unwatch(dir string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if stop, ok := p.stops[dir]; ok {
+		close(stop)
+		delete(p.stops, dir)
+	}
+}
+
+// 定期比较目录内容，报告新建、删除和修改
+func (p *pollBackend) poll(dir string, previous map[string]entryState, stop chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := scanDir(p.store, dir)
+		if err != nil {
+			// 目录被删除等情况，保留上次的结果，等待目录恢复或取消订阅
+			log.Printf("轮询目录失败: %v (目录: %s)", err, dir)
+			continue
+		}
+		for name := range previous {
+			if _, ok := current[name]; !ok {
+				p.notify(dir, "delete", name, "")
+			}
+		}
+		for name, state := range current {
+			old, ok := previous[name]
+			switch {
+			case !ok:
+				p.notify(dir, "create", name, "")
+			case old.size != state.size || !old.modTime.Equal(state.modTime) || old.isDir != state.isDir:
+				p.notify(dir, "modify", name, "")
+			}
+		}
+		previous = current
+	}
+}
+
+// 读取目录中所有条目的状态
+func scanDir(store storage, dir string) (map[string]entryState, error) {
+	entries, err := store.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]entryState, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		states[entry.Name()] = entryState{size: info.Size(), modTime: info.ModTime(), isDir: entry.IsDir()}
+	}
+	return states, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 监视实现报告的一次变化
+type backendEvent struct {
+	dir, eventType, name, oldName string
+}
+
+func collectEvents() (chan backendEvent, func(dir, eventType, name, oldName string)) {
+	events := make(chan backendEvent, 64)
+	return events, func(dir, eventType, name, oldName string) {
+		events <- backendEvent{dir, eventType, name, oldName}
+	}
+}
+
+// 等待指定类型和名称的事件，忽略其他事件
+func waitEvent(t *testing.T, events chan backendEvent, eventType, name string) backendEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.eventType == eventType && e.name == name {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s %s", eventType, name)
+		}
+	}
+}
+
+func TestPollBackend(t *testing.T) {
+	store := newMemStorage()
+	store.Mkdir("docs")
+	writeStoreFile(t, store, "docs/old.txt", "old")
+	events, notify := collectEvents()
+	p := newPollBackend(store, 10*time.Millisecond, notify)
+	if err := p.watch("docs"); err != nil {
+		t.Fatal(err)
+	}
+	defer p.unwatch("docs")
+
+	writeStoreFile(t, store, "docs/new.txt", "new")
+	if e := waitEvent(t, events, "create", "new.txt"); e.dir != "docs" {
+		t.Errorf("event dir = %q, want docs", e.dir)
+	}
+	writeStoreFile(t, store, "docs/new.txt", "longer content")
+	waitEvent(t, events, "modify", "new.txt")
+	store.Remove("docs/old.txt")
+	waitEvent(t, events, "delete", "old.txt")
+
+	// 取消后不再轮询
+	p.unwatch("docs")
+	if len(p.stops) != 0 {
+		t.Error("directory is still polled after unwatch")
+	}
+	if err := p.watch("missing"); err == nil {
+		t.Error("watching a missing directory succeeded")
+	}
+}
+
+func TestNativeBackend(t *testing.T) {
+	root := t.TempDir()
+	events, notify := collectEvents()
+	b, err := newNativeBackend(root, notify)
+	if err != nil {
+		t.Skipf("native watcher unavailable: %v", err)
+	}
+	if err := b.watch("."); err != nil {
+		t.Fatal(err)
+	}
+	defer b.unwatch(".")
+
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644)
+	if e := waitEvent(t, events, "create", "a.txt"); e.dir != "." {
+		t.Errorf("event dir = %q, want .", e.dir)
+	}
+	os.Rename(filepath.Join(root, "a.txt"), filepath.Join(root, "b.txt"))
+	if e := waitEvent(t, events, "rename", "b.txt"); e.oldName != "a.txt" {
+		t.Errorf("rename old name = %q, want a.txt", e.oldName)
+	}
+	os.Remove(filepath.Join(root, "b.txt"))
+	waitEvent(t, events, "delete", "b.txt")
+}
+
+func TestDirWatcherSubscribe(t *testing.T) {
+	store := newMemStorage()
+	store.Mkdir("docs")
+	dir := "docs"
+	paths, err := newPathPolicy(FileSettings{Hidden: []string{"*.tmp"}}, store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newDirWatcher(WatchSettings{Mode: "poll", PollInterval: 3600}, store, "/files", paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel, err := w.subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cancelOther, err := w.subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 隐藏的文件不推送；事件带上条目信息，链接包含URL前缀
+	writeStoreFile(t, store, "docs/draft.tmp", "draft")
+	w.notify(dir, "create", "draft.tmp", "")
+	writeStoreFile(t, store, "docs/report.txt", "report")
+	w.notify(dir, "create", "report.txt", "")
+	select {
+	case e := <-events:
+		if e.Type != "create" || e.File == nil || e.File.Path != "/files/download/docs/report.txt" {
+			t.Errorf("event = %+v, file = %+v", e, e.File)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered")
+	}
+
+	// 条目已经不存在时不发送新建事件
+	w.notify(dir, "create", "gone.txt", "")
+	w.notify(dir, "delete", "gone.txt", "")
+	if e := <-events; e.Type != "delete" || e.File != nil {
+		t.Errorf("event = %+v, want delete without file", e)
+	}
+
+	// 最后一个订阅者离开后停止监视
+	cancel()
+	if _, ok := w.backends[dir]; !ok {
+		t.Error("watch stopped while a subscriber remains")
+	}
+	cancelOther()
+	if len(w.backends) != 0 || len(w.subs) != 0 {
+		t.Error("watch kept after the last subscriber left")
+	}
+
+	if _, err := newDirWatcher(WatchSettings{Mode: "sometimes"}, store, "", nil); err == nil {
+		t.Error("invalid watch mode was accepted")
+	}
+	if w, err := newDirWatcher(WatchSettings{Mode: "off"}, store, "", nil); w != nil || err != nil {
+		t.Errorf("off mode = %v, %v, want nil watcher", w, err)
+	}
+}
+
+func TestDirEventsStream(t *testing.T) {
+	settings := defaultSettings()
+	settings.Watch = WatchSettings{Mode: "poll", PollInterval: 1}
+	config := newTestConfig(t, settings, nil)
+	writeTestFile(t, config, "docs/keep.txt", "keep")
+	server := httptest.NewServer(setupRoutes(config))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events/docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	writeTestFile(t, config, "docs/new.txt", "new")
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+			var event dirEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != "create" || event.Name != "new.txt" {
+				t.Fatalf("event = %+v, want create new.txt", event)
+			}
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for the create event")
+		}
+	}
+}
+
+func TestDirEventsRejectsFiles(t *testing.T) {
+	config := newTestConfig(t, nil, nil)
+	writeTestFile(t, config, "report.txt", "report")
+	if w := serve(config, httptest.NewRequest(http.MethodGet, "/events/report.txt", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("file: status = %d, want 404", w.Code)
+	}
+	if w := serve(config, httptest.NewRequest(http.MethodPost, "/events/", nil)); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 内置的页面模板、静态资源和语言文件
+//
+//go:embed web/templates web/static web/i18n
+var webFiles embed.FS
+
+// 叠加文件系统 - 优先从主题目录读取，不存在时回退到内置文件
+type overlayFS struct {
+	theme    fs.FS // 主题目录，可以为nil
+	fallback fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.theme != nil {
+		f, err := o.theme.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.fallback.Open(name)
+}
+
+// 页面资源：解析好的模板、静态文件及其版本号
+type webAssets struct {
+	templates *template.Template
+	static    fs.FS
+	version   string            // 静态资源内容的摘要，用于缓存失效
+	etags     map[string]string // 静态文件名 -> 该文件内容的摘要
+}
+
+// 加载页面资源 - 模板在启动时解析一次，主题目录中的同名文件覆盖内置文件
+func loadWebAssets(themeDir, basePath string) (*webAssets, error) {
+	var themeFS fs.FS
+	if themeDir != "" {
+		absThemeDir, err := filepath.Abs(themeDir)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(absThemeDir); err != nil {
+			return nil, err
+		}
+		themeFS = os.DirFS(absThemeDir)
+	}
+	// 静态资源：主题目录的 static/ 子目录覆盖内置的 web/static
+	embeddedStatic, err := fs.Sub(webFiles, "web/static")
+	if err != nil {
+		return nil, err
+	}
+	assets := &webAssets{static: embeddedStatic, etags: make(map[string]string)}
+	if err := hashFiles(embeddedStatic, assets.etags); err != nil {
+		return nil, err
+	}
+	if themeFS != nil {
+		themeStatic, err := fs.Sub(themeFS, "static")
+		if err != nil {
+			return nil, err
+		}
+		assets.static = overlayFS{theme: themeStatic, fallback: embeddedStatic}
+		// 主题中的同名文件覆盖内置文件的摘要
+		if err := hashFiles(themeStatic, assets.etags); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(assets.etags))
+	for name := range assets.etags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name + "\x00" + assets.etags[name] + "\x00"))
+	}
+	assets.version = hex.EncodeToString(h.Sum(nil))[:12]
+
+	// 模板以内置文件名为准，主题目录中的同名文件替换其内容
+	names, err = fs.Glob(webFiles, "web/templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	funcs := template.FuncMap{
+		// 静态资源URL，带版本号以便长期缓存
+		"asset": func(name string) string {
+			return basePath + "/static/" + name + "?v=" + assets.version
+		},
+		// 翻译消息：{{t .Lang "page.title"}}
+		"t": translate,
+	}
+	assets.templates = template.New("").Funcs(funcs)
+	for _, name := range names {
+		content, err := readThemeFile(themeFS, webFiles, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := assets.templates.New(path.Base(name)).Parse(string(content)); err != nil {
+			return nil, err
+		}
+	}
+	return assets, nil
+}
+
+// 读取模板文件，主题目录（templates/ 子目录）中存在时优先使用
+func readThemeFile(themeFS, embedded fs.FS, name string) ([]byte, error) {
+	if themeFS != nil {
+		content, err := fs.ReadFile(themeFS, path.Join("templates", path.Base(name)))
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return fs.ReadFile(embedded, name)
+}
+
+// 计算目录下每个文件内容的摘要，记入 sums
+func hashFiles(fsys fs.FS, sums map[string]string) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		sums[name] = hex.EncodeToString(sum[:])[:16]
+		return nil
+	})
+}
+
+// 保存主题选择的Cookie名
+const themeCookie = "theme"
+
+// 页面主题：auto跟随系统（prefers-color-scheme），light、dark固定
+var themes = []string{"auto", "light", "dark"}
+
+// 确定请求使用的主题，未选择或取值无效时为auto
+func requestTheme(r *http.Request) string {
+	if cookie, err := r.Cookie(themeCookie); err == nil && containsString(themes, cookie.Value) {
+		return cookie.Value
+	}
+	return "auto"
+}
+
+// 处理静态资源请求 - 带当前版本号的请求可以长期缓存，其余每次验证
+func handleStatic(assets *webAssets) http.Handler {
+	fileServer := http.StripPrefix("/static/", http.FileServer(http.FS(assets.static)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 不提供目录列表
+		if strings.HasSuffix(r.URL.Path, "/") {
+			httpError(w, r, http.StatusNotFound, "not_found")
+			return
+		}
+		if r.URL.Query().Get("v") == assets.version {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		// ETag 按文件内容计算，只有该文件变化时才需要重新下载
+		if etag, ok := assets.etags[strings.TrimPrefix(r.URL.Path, "/static/")]; ok {
+			w.Header().Set("ETag", `"`+etag+`"`)
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
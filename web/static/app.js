@@ -0,0 +1,882 @@
+// 页面参数由模板写在body的data属性上
+const page = document.body.dataset;
+
+// 当前语言的界面消息
+const messages = JSON.parse(page.messages || '{}');
+
+// 翻译界面消息，缺失时返回消息键
+function t(key) {
+    return messages[key] || key;
+}
+
+// 生成引用图标精灵的SVG
+function icon(name) {
+    return '<svg width="16" height="16"><use href="' + page.icons + '#' + name + '"></use></svg>';
+}
+
+document.addEventListener('DOMContentLoaded', function() {
+    // QR码交互
+    const qrButton = document.querySelector('.qr-code');
+    const qrPopup = document.querySelector('.qr-popup');
+    const qrClose = document.querySelector('.qr-close');
+
+    qrButton.addEventListener('click', function() {
+        qrPopup.classList.add('show');
+    });
+
+    qrClose.addEventListener('click', function() {
+        qrPopup.classList.remove('show');
+    });
+
+    // 点击外部关闭
+    document.addEventListener('click', function(e) {
+        if (!qrPopup.contains(e.target) && !qrButton.contains(e.target)) {
+            qrPopup.classList.remove('show');
+        }
+    });
+
+    // 切换IP时更新二维码，页面加载时刷新二维码
+    const ipSelect = document.querySelector('.ip-selector select');
+    if (ipSelect) {
+        ipSelect.addEventListener('change', function() {
+            updateQRCode(ipSelect);
+        });
+    }
+    refreshQRCode();
+
+    // 切换语言：保存到Cookie后刷新页面
+    const languageSelect = document.getElementById('languageSelect');
+    if (languageSelect) {
+        languageSelect.addEventListener('change', function() {
+            document.cookie = 'lang=' + encodeURIComponent(languageSelect.value) +
+                '; path=' + (page.basePath || '') + '/; max-age=31536000; SameSite=Lax';
+            window.location.reload();
+        });
+    }
+
+    // 切换主题：自动 -> 浅色 -> 深色，保存到Cookie，无需刷新
+    const themeToggle = document.getElementById('themeToggle');
+    if (themeToggle) {
+        themeToggle.addEventListener('click', function() {
+            const themes = ['auto', 'light', 'dark'];
+            const current = document.documentElement.dataset.theme || 'auto';
+            const next = themes[(themes.indexOf(current) + 1) % themes.length];
+            document.documentElement.dataset.theme = next;
+            document.cookie = 'theme=' + next +
+                '; path=' + (page.basePath || '') + '/; max-age=31536000; SameSite=Lax';
+        });
+    }
+
+    // 文件上传相关脚本
+    setupFileUpload();
+
+    // 共享剪贴板
+    setupClipboard();
+
+    // 目录实时更新
+    setupLiveUpdates();
+
+    // 删除文件
+    setupDelete();
+
+    // 复制校验和
+    setupChecksum();
+});
+
+// 从服务器的JSON错误响应中取出消息
+function errorMessage(xhr) {
+    try {
+        return JSON.parse(xhr.responseText).message || xhr.statusText;
+    } catch (e) {
+        return xhr.statusText;
+    }
+}
+
+// 刷新当前二维码
+function refreshQRCode() {
+    const selectElement = document.querySelector('.ip-selector select');
+    if (selectElement) {
+        updateQRCode(selectElement);
+    }
+}
+
+// 更新QR码和路径 - 只影响二维码，不影响页面链接
+function updateQRCode(selectElement) {
+    const selectedOption = selectElement.options[selectElement.selectedIndex];
+    const currentPath = page.currentPath;
+    // 基础URL由服务器生成，IPv6地址已带方括号
+    const baseURL = selectedOption.dataset.base;
+    const fullURL = baseURL + (currentPath.startsWith('/') ? currentPath : '/' + currentPath);
+
+    console.log('Updating QR code for URL:', fullURL);
+
+    // 使用Ajax请求本地生成的二维码
+    fetch(page.basePath + '/generate-qrcode?data=' + encodeURIComponent(fullURL))
+        .then(response => {
+            if (!response.ok) {
+                throw new Error('二维码生成请求失败: ' + response.status);
+            }
+            return response.text();
+        })
+        .then(dataUrl => {
+            // 更新二维码图片
+            const qrImg = document.getElementById('qrCodeImg');
+            if (qrImg) {
+                qrImg.src = dataUrl;
+                console.log('二维码已更新');
+            }
+
+            // 更新显示的URL
+            const urlElement = document.getElementById('currentUrl');
+            if (urlElement) {
+                urlElement.innerText = fullURL;
+            }
+        })
+        .catch(error => {
+            console.error('获取二维码失败:', error);
+        });
+
+    // 不再更新页面URL和页面链接
+}
+
+// 设置文件上传功能
+function setupFileUpload() {
+    const uploadForm = document.getElementById('uploadForm');
+    const fileInput = document.getElementById('fileInput');
+    const fileList = document.getElementById('fileList');
+    const uploadContainer = document.querySelector('.upload-container');
+    const uploadProgress = document.querySelector('.upload-progress-bar');
+    const uploadStatus = document.querySelector('.upload-status');
+    const uploadToggle = document.getElementById('uploadToggle');
+    const uploadSection = document.getElementById('uploadSection');
+
+    if (!uploadForm || !fileInput) return;
+
+    // 显示/隐藏上传表单
+    if (uploadToggle && uploadSection) {
+        uploadSection.style.display = 'none';
+        uploadToggle.addEventListener('click', function(e) {
+            e.preventDefault();
+            if (uploadSection.style.display === 'none') {
+                uploadSection.style.display = 'block';
+                uploadToggle.innerHTML = icon('close') + ' ' + t('cancel_upload');
+            } else {
+                uploadSection.style.display = 'none';
+                uploadToggle.innerHTML = icon('upload') + ' ' + t('upload');
+            }
+        });
+    }
+
+    // 上传队列：每个文件单独请求，最多同时上传 maxConcurrent 个
+    const maxConcurrent = 3;
+    const tasks = [];
+    let batch = null; // 当前这一轮上传的统计：开始时间和开始时已上传的字节数
+
+    // 上传目标：当前目录
+    let uploadURL = page.basePath + '/upload/';
+    const currentPath = page.currentPath;
+    if (currentPath && currentPath !== "/" && currentPath !== ".") {
+        uploadURL += currentPath.replace(/^\/+/, '').split('/').map(encodeURIComponent).join('/');
+    }
+    // 设置表单action属性，确保即使JavaScript失效，表单也能提交到正确URL
+    uploadForm.action = uploadURL;
+
+    // 文件选择后加入队列
+    fileInput.addEventListener('change', function() {
+        addFiles(Array.from(fileInput.files, function(file) {
+            return {file: file, relpath: ''};
+        }));
+        fileInput.value = '';
+    });
+
+    // 选择文件夹：webkitRelativePath 带有文件夹名，如 "photos/2024/a.jpg"
+    const folderInput = document.getElementById('folderInput');
+    if (folderInput) {
+        folderInput.addEventListener('change', function() {
+            addFiles(Array.from(folderInput.files, function(file) {
+                return {file: file, relpath: file.webkitRelativePath || ''};
+            }));
+            folderInput.value = '';
+        });
+    }
+
+    // 拖放文件处理
+    if (uploadContainer) {
+        uploadContainer.addEventListener('dragover', function(e) {
+            e.preventDefault();
+            uploadContainer.classList.add('drag-active');
+        });
+
+        uploadContainer.addEventListener('dragleave', function() {
+            uploadContainer.classList.remove('drag-active');
+        });
+
+        uploadContainer.addEventListener('drop', function(e) {
+            e.preventDefault();
+            uploadContainer.classList.remove('drag-active');
+            droppedFiles(e.dataTransfer).then(addFiles);
+        });
+    }
+
+    // 把文件加入队列并显示，每项为 {file, relpath}
+    function addFiles(files) {
+        if (!files || files.length === 0) return;
+        if (tasks.length === 0) fileList.replaceChildren();
+        for (let i = 0; i < files.length; i++) {
+            const task = {file: files[i].file, relpath: files[i].relpath, status: 'pending', loaded: 0, xhr: null};
+            task.el = renderTask(task);
+            fileList.appendChild(task.el.item);
+            tasks.push(task);
+        }
+        uploadStatus.textContent = '';
+    }
+
+    // 队列中一个文件的显示：名称、大小、进度条、状态以及取消/重试按钮
+    function renderTask(task) {
+        const item = document.createElement('div');
+        item.className = 'file-list-item';
+
+        const info = document.createElement('div');
+        info.className = 'upload-item-info';
+        const name = document.createElement('div');
+        name.className = 'upload-item-name';
+        name.innerHTML = icon('file');
+        name.append(task.relpath || task.file.name);
+        const size = document.createElement('span');
+        size.className = 'upload-item-size';
+        size.textContent = formatFileSize(task.file.size);
+        name.appendChild(size);
+        const progress = document.createElement('div');
+        progress.className = 'upload-progress';
+        const bar = document.createElement('div');
+        bar.className = 'upload-progress-bar';
+        progress.appendChild(bar);
+        const status = document.createElement('div');
+        status.className = 'upload-item-status';
+        status.textContent = t('upload_pending');
+        info.append(name, progress, status);
+
+        const cancel = taskButton('close', t('cancel'), function() {
+            cancelTask(task);
+        });
+        const retry = taskButton('retry', t('retry'), function() {
+            task.status = 'pending';
+            task.loaded = 0;
+            updateTask(task, t('upload_pending'));
+            startUploads();
+        });
+        retry.hidden = true;
+
+        item.append(info, cancel, retry);
+        return {item: item, bar: bar, status: status, cancel: cancel, retry: retry};
+    }
+
+    function taskButton(name, title, onClick) {
+        const button = document.createElement('button');
+        button.type = 'button';
+        button.className = 'upload-item-action';
+        button.title = title;
+        button.setAttribute('aria-label', title);
+        button.innerHTML = icon(name);
+        button.addEventListener('click', onClick);
+        return button;
+    }
+
+    // 根据任务状态更新显示
+    function updateTask(task, message) {
+        const percent = task.file.size > 0 ? task.loaded / task.file.size * 100 : (task.status === 'done' ? 100 : 0);
+        task.el.bar.style.width = Math.min(percent, 100) + '%';
+        task.el.item.dataset.status = task.status;
+        task.el.status.textContent = message;
+        task.el.cancel.hidden = task.status === 'done' || task.status === 'failed' || task.status === 'cancelled';
+        task.el.retry.hidden = task.status !== 'failed' && task.status !== 'cancelled';
+    }
+
+    // 取消排队中或上传中的文件，已完成的文件从列表中移除
+    function cancelTask(task) {
+        if (task.status === 'uploading') {
+            task.xhr.abort();
+            return;
+        }
+        if (task.status === 'pending') {
+            task.status = 'cancelled';
+            updateTask(task, t('upload_cancelled'));
+            updateOverall();
+        }
+    }
+
+    // 启动排队中的上传，直到达到并发上限
+    function startUploads() {
+        if (!batch) {
+            const loaded = tasks.reduce(function(sum, task) { return sum + task.loaded; }, 0);
+            batch = {start: Date.now(), loaded: loaded};
+        }
+        let running = tasks.filter(function(task) { return task.status === 'uploading'; }).length;
+        for (const task of tasks) {
+            if (running >= maxConcurrent) break;
+            if (task.status === 'pending') {
+                uploadTask(task);
+                running++;
+            }
+        }
+        updateOverall();
+    }
+
+    // 上传单个文件
+    function uploadTask(task) {
+        task.status = 'uploading';
+        task.loaded = 0;
+        updateTask(task, '0%');
+
+        // relpath 必须在文件之前，服务器按顺序读取
+        const formData = new FormData();
+        if (task.relpath) {
+            formData.append('relpath', task.relpath);
+        }
+        formData.append('file', task.file);
+
+        const xhr = new XMLHttpRequest();
+        task.xhr = xhr;
+        xhr.open('POST', uploadURL, true);
+        // 服务器返回JSON格式的结果
+        xhr.setRequestHeader('Accept', 'application/json');
+
+        xhr.upload.addEventListener('progress', function(e) {
+            if (e.lengthComputable) {
+                // 进度包含表单编码的少量额外字节，按文件大小折算
+                task.loaded = Math.min(task.file.size, e.loaded / e.total * task.file.size);
+                updateTask(task, Math.floor(task.loaded / Math.max(task.file.size, 1) * 100) + '%');
+                updateOverall();
+            }
+        });
+
+        xhr.addEventListener('load', function() {
+            let result = null;
+            try {
+                result = JSON.parse(xhr.responseText);
+            } catch (e) {
+                // 非JSON响应，按HTTP状态处理
+            }
+            const fileResult = result && result.files && result.files[0];
+            if (fileResult && fileResult.status === 'ok') {
+                task.status = 'done';
+                task.loaded = task.file.size;
+                updateTask(task, fileResult.scan === 'pending' ? t('upload_scanning') : t('upload_done'));
+            } else {
+                task.status = 'failed';
+                const message = fileResult ? fileResult.message : (result && result.message) || xhr.statusText;
+                updateTask(task, t('upload_failed') + message);
+            }
+            finishTask();
+        });
+
+        xhr.addEventListener('error', function() {
+            task.status = 'failed';
+            updateTask(task, t('network_error'));
+            finishTask();
+        });
+
+        xhr.addEventListener('abort', function() {
+            task.status = 'cancelled';
+            task.loaded = 0;
+            updateTask(task, t('upload_cancelled'));
+            finishTask();
+        });
+
+        xhr.send(formData);
+    }
+
+    // 一个文件结束后继续上传队列中的下一个
+    function finishTask() {
+        startUploads();
+        const active = tasks.some(function(task) { return task.status === 'uploading' || task.status === 'pending'; });
+        if (active) return;
+
+        batch = null;
+        const done = tasks.filter(function(task) { return task.status === 'done'; }).length;
+        const failed = tasks.length - done;
+        const summary = document.createElement('div');
+        summary.className = failed > 0 ? 'upload-error' : 'upload-success';
+        summary.textContent = failed > 0 ? t('upload_partial').replace('%d', done).replace('%d', failed) : t('upload_success');
+        uploadStatus.replaceChildren(summary);
+
+        // 实时更新已连接时列表会自动出现新文件，否则刷新页面
+        if (done > 0 && failed === 0 && !liveUpdatesConnected()) {
+            setTimeout(function() {
+                window.location.reload();
+            }, 1500);
+        }
+    }
+
+    // 更新总进度、速度和剩余时间
+    function updateOverall() {
+        const active = tasks.filter(function(task) { return task.status !== 'cancelled'; });
+        const total = active.reduce(function(sum, task) { return sum + task.file.size; }, 0);
+        const loaded = active.reduce(function(sum, task) { return sum + task.loaded; }, 0);
+        uploadProgress.style.width = (total > 0 ? loaded / total * 100 : 0) + '%';
+        if (!batch) return;
+
+        const elapsed = (Date.now() - batch.start) / 1000;
+        const speed = elapsed > 0 ? Math.max(loaded - batch.loaded, 0) / elapsed : 0;
+        let text = formatFileSize(loaded) + ' / ' + formatFileSize(total) + ' · ' + formatFileSize(speed) + '/s';
+        if (speed > 0 && loaded < total) {
+            text += ' · ' + t('upload_eta') + formatDuration((total - loaded) / speed);
+        }
+        uploadStatus.textContent = text;
+    }
+
+    // 格式化文件大小
+    function formatFileSize(bytes) {
+        if (bytes < 1) return '0 B';
+        const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+        const i = Math.min(Math.floor(Math.log(bytes) / Math.log(1024)), units.length - 1);
+        return (bytes / Math.pow(1024, i)).toFixed(i === 0 ? 0 : 2) + ' ' + units[i];
+    }
+
+    // 格式化剩余时间
+    function formatDuration(seconds) {
+        seconds = Math.ceil(seconds);
+        const h = Math.floor(seconds / 3600);
+        const m = Math.floor(seconds % 3600 / 60);
+        const sec = seconds % 60;
+        const pad = function(n) { return String(n).padStart(2, '0'); };
+        return (h > 0 ? h + ':' + pad(m) : m) + ':' + pad(sec);
+    }
+
+    // 表单提交：开始上传队列中的文件
+    uploadForm.addEventListener('submit', function(e) {
+        e.preventDefault();
+        if (!tasks.some(function(task) { return task.status === 'pending'; })) {
+            uploadStatus.innerHTML = '<div class="upload-error">' + t('select_files') + '</div>';
+            return;
+        }
+        startUploads();
+    });
+}
+
+// 取出拖放的文件，文件夹会递归展开，返回 [{file, relpath}]
+function droppedFiles(dataTransfer) {
+    const items = dataTransfer.items;
+    if (!items || !items.length || !items[0].webkitGetAsEntry) {
+        return Promise.resolve(Array.from(dataTransfer.files, function(file) {
+            return {file: file, relpath: ''};
+        }));
+    }
+
+    // 必须在drop事件中同步取出所有条目，事件结束后列表就失效了
+    const entries = [];
+    for (let i = 0; i < items.length; i++) {
+        const entry = items[i].kind === 'file' ? items[i].webkitGetAsEntry() : null;
+        if (entry) entries.push(entry);
+    }
+    return Promise.all(entries.map(function(entry) {
+        return readEntry(entry, entry.isDirectory);
+    })).then(function(lists) {
+        return [].concat.apply([], lists);
+    });
+}
+
+// 递归读取文件系统条目，inFolder 表示条目位于拖放的文件夹中，需要带上相对路径
+function readEntry(entry, inFolder) {
+    if (entry.isFile) {
+        return new Promise(function(resolve) {
+            entry.file(function(file) {
+                resolve([{file: file, relpath: inFolder ? entry.fullPath.replace(/^\/+/, '') : ''}]);
+            }, function() {
+                resolve([]);
+            });
+        });
+    }
+    if (!entry.isDirectory) return Promise.resolve([]);
+
+    // readEntries 每次只返回一部分条目，需要反复调用直到返回空
+    const reader = entry.createReader();
+    const children = [];
+    return new Promise(function(resolve) {
+        function readBatch() {
+            reader.readEntries(function(batch) {
+                if (batch.length === 0) {
+                    Promise.all(children.map(function(child) {
+                        return readEntry(child, true);
+                    })).then(function(lists) {
+                        resolve([].concat.apply([], lists));
+                    });
+                    return;
+                }
+                children.push.apply(children, batch);
+                readBatch();
+            }, function() {
+                resolve([]);
+            });
+        }
+        readBatch();
+    });
+}
+
+// 共享剪贴板：发送文本片段，列出最近的片段并提供复制、二维码和删除
+function setupClipboard() {
+    const toggle = document.getElementById('clipboardToggle');
+    const section = document.getElementById('clipboardSection');
+    const form = document.getElementById('clipboardForm');
+    const textInput = document.getElementById('clipboardText');
+    const status = document.getElementById('clipboardStatus');
+    const list = document.getElementById('clipList');
+    if (!toggle || !section) return;
+
+    const endpoint = page.basePath + '/clipboard/';
+
+    toggle.addEventListener('click', function() {
+        section.hidden = !section.hidden;
+        if (!section.hidden) {
+            loadClips();
+            textInput.focus();
+        }
+    });
+
+    form.addEventListener('submit', function(e) {
+        e.preventDefault();
+        fetch(endpoint, {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json', 'Accept': 'application/json'},
+            body: JSON.stringify({text: textInput.value})
+        }).then(function(response) {
+            return response.json().then(function(data) {
+                if (!response.ok) throw new Error(data.message || response.statusText);
+                return data;
+            });
+        }).then(function() {
+            textInput.value = '';
+            showStatus('');
+            loadClips();
+        }).catch(function(error) {
+            showStatus(t('clipboard_failed') + error.message);
+        });
+    });
+
+    // Ctrl/Cmd+Enter 直接发送
+    textInput.addEventListener('keydown', function(e) {
+        if (e.key === 'Enter' && (e.ctrlKey || e.metaKey)) {
+            form.requestSubmit();
+        }
+    });
+
+    function showStatus(message) {
+        status.textContent = message;
+        status.className = 'upload-status' + (message ? ' upload-error' : '');
+    }
+
+    function loadClips() {
+        fetch(endpoint, {headers: {'Accept': 'application/json'}})
+            .then(function(response) {
+                if (!response.ok) throw new Error(response.statusText);
+                return response.json();
+            })
+            .then(renderClips)
+            .catch(function(error) {
+                showStatus(t('clipboard_failed') + error.message);
+            });
+    }
+
+    function renderClips(clips) {
+        list.replaceChildren();
+        if (clips.length === 0) {
+            const empty = document.createElement('li');
+            empty.className = 'clip-meta';
+            empty.textContent = t('clipboard_empty');
+            list.appendChild(empty);
+            return;
+        }
+        clips.forEach(function(clip) {
+            list.appendChild(renderClip(clip));
+        });
+    }
+
+    function renderClip(clip) {
+        const item = document.createElement('li');
+        item.className = 'clip-item';
+
+        const body = document.createElement('div');
+        body.className = 'clip-body';
+        const text = document.createElement('pre');
+        text.className = 'clip-text';
+        text.textContent = clip.text;
+        const meta = document.createElement('div');
+        meta.className = 'clip-meta';
+        meta.textContent = new Date(clip.created).toLocaleString() + ' · ' +
+            t('clipboard_expires') + new Date(clip.expires).toLocaleString();
+        body.append(text, meta);
+
+        let qr = null;
+        if (clip.qrcode) {
+            qr = document.createElement('img');
+            qr.className = 'clip-qr';
+            qr.src = clip.qrcode;
+            qr.alt = t('clipboard_qr');
+            qr.hidden = true;
+            body.appendChild(qr);
+        }
+
+        const actions = document.createElement('div');
+        actions.className = 'clip-actions';
+        actions.appendChild(actionButton('copy', t('clipboard_copy'), function(button) {
+            copyText(clip.text).then(function() {
+                button.title = t('clipboard_copied');
+            });
+        }));
+        if (qr) {
+            actions.appendChild(actionButton('qrcode', t('clipboard_qr'), function() {
+                qr.hidden = !qr.hidden;
+            }));
+        }
+        actions.appendChild(actionButton('trash', t('clipboard_delete'), function() {
+            fetch(endpoint + encodeURIComponent(clip.id), {method: 'DELETE', headers: {'Accept': 'application/json'}})
+                .then(loadClips);
+        }));
+
+        item.append(body, actions);
+        return item;
+    }
+
+    function actionButton(name, title, onClick) {
+        const button = document.createElement('button');
+        button.type = 'button';
+        button.title = title;
+        button.setAttribute('aria-label', title);
+        button.innerHTML = icon(name);
+        button.addEventListener('click', function() {
+            onClick(button);
+        });
+        return button;
+    }
+}
+
+// 复制文本 - 局域网内通常是http访问，Clipboard API不可用时退回到execCommand
+function copyText(text) {
+    if (navigator.clipboard && window.isSecureContext) {
+        return navigator.clipboard.writeText(text);
+    }
+    const textarea = document.createElement('textarea');
+    textarea.value = text;
+    textarea.style.position = 'fixed';
+    textarea.style.opacity = '0';
+    document.body.appendChild(textarea);
+    textarea.select();
+    document.execCommand('copy');
+    textarea.remove();
+    return Promise.resolve();
+}
+
+// 目录事件连接，未启用实时更新时为null
+let liveEvents = null;
+
+function liveUpdatesConnected() {
+    return liveEvents !== null && liveEvents.readyState === EventSource.OPEN;
+}
+
+// 目录实时更新：通过SSE接收当前目录的变化，就地更新文件列表
+function setupLiveUpdates() {
+    const rows = document.getElementById('fileRows');
+    if (!page.events || !rows || !window.EventSource) return;
+
+    liveEvents = new EventSource(page.events);
+
+    liveEvents.addEventListener('create', function(e) {
+        const event = JSON.parse(e.data);
+        upsertRow(rows, event.file);
+    });
+    liveEvents.addEventListener('modify', function(e) {
+        const event = JSON.parse(e.data);
+        upsertRow(rows, event.file);
+    });
+    liveEvents.addEventListener('rename', function(e) {
+        const event = JSON.parse(e.data);
+        removeRow(rows, event.old_name);
+        upsertRow(rows, event.file);
+    });
+    liveEvents.addEventListener('delete', function(e) {
+        const event = JSON.parse(e.data);
+        removeRow(rows, event.name);
+    });
+    // 服务器丢失了事件，重新加载整个页面
+    liveEvents.addEventListener('reload', function() {
+        window.location.reload();
+    });
+}
+
+// 删除文件或目录：确认后发送删除请求，成功后移除该行（实时更新已连接时由事件移除）
+function setupDelete() {
+    const rows = document.getElementById('fileRows');
+    if (!rows) return;
+
+    rows.addEventListener('click', function(e) {
+        const button = e.target.closest('.delete-btn');
+        if (!button) return;
+        const name = button.closest('tr').dataset.name;
+        if (!window.confirm(t('delete_confirm').replace('%s', name))) return;
+
+        const dir = page.currentPath.replace(/^\/+|\/+$/g, '');
+        const target = (dir ? dir + '/' : '') + name;
+        const xhr = new XMLHttpRequest();
+        xhr.open('DELETE', page.basePath + '/delete/' + target.split('/').map(encodeURIComponent).join('/'));
+        xhr.setRequestHeader('X-Requested-With', 'XMLHttpRequest');
+        xhr.addEventListener('load', function() {
+            if (xhr.status === 200) {
+                removeRow(rows, name);
+            } else {
+                window.alert(t('delete_failed') + errorMessage(xhr));
+            }
+        });
+        xhr.addEventListener('error', function() {
+            window.alert(t('delete_failed') + xhr.statusText);
+        });
+        xhr.send();
+    });
+}
+
+// 复制文件的SHA-256校验和：大文件需要服务器计算一段时间，期间按钮不可用
+function setupChecksum() {
+    const rows = document.getElementById('fileRows');
+    if (!rows) return;
+
+    rows.addEventListener('click', function(e) {
+        const button = e.target.closest('.checksum-btn');
+        if (!button || button.disabled) return;
+        const name = button.closest('tr').dataset.name;
+        const dir = page.currentPath.replace(/^\/+|\/+$/g, '');
+        const target = (dir ? dir + '/' : '') + name;
+
+        button.disabled = true;
+        button.classList.add('busy');
+        const xhr = new XMLHttpRequest();
+        xhr.open('GET', page.basePath + '/hash/' + target.split('/').map(encodeURIComponent).join('/') + '?algo=sha256');
+        xhr.setRequestHeader('X-Requested-With', 'XMLHttpRequest');
+        xhr.addEventListener('loadend', function() {
+            button.disabled = false;
+            button.classList.remove('busy');
+            if (xhr.status !== 200) {
+                window.alert(t('checksum_failed') + errorMessage(xhr));
+                return;
+            }
+            const hash = JSON.parse(xhr.responseText).hash;
+            copyText(hash).then(function() {
+                button.title = t('checksum_copied') + hash;
+            });
+        });
+        xhr.send();
+    });
+}
+
+// 查找条目对应的行
+function findRow(rows, name) {
+    for (const row of rows.querySelectorAll('tr[data-name]')) {
+        if (row.dataset.name === name) return row;
+    }
+    return null;
+}
+
+// 添加或更新一行，按名称排序插入（与服务器的排序一致）
+function upsertRow(rows, file) {
+    if (!file) return;
+    const row = buildRow(file);
+    const existing = findRow(rows, file.name);
+    if (existing) {
+        existing.replaceWith(row);
+        return;
+    }
+    const empty = rows.querySelector('.empty-row');
+    if (empty) empty.remove();
+
+    let before = null;
+    for (const other of rows.querySelectorAll('tr[data-name]')) {
+        if (other.dataset.name > file.name) {
+            before = other;
+            break;
+        }
+    }
+    rows.insertBefore(row, before);
+}
+
+// 删除一行，目录变空时显示提示
+function removeRow(rows, name) {
+    const row = findRow(rows, name);
+    if (row) row.remove();
+    if (!rows.querySelector('tr[data-name]') && !rows.querySelector('.empty-row')) {
+        const empty = document.createElement('tr');
+        empty.className = 'empty-row';
+        const cell = document.createElement('td');
+        cell.colSpan = 4;
+        cell.style.textAlign = 'center';
+        cell.style.color = 'var(--text-light)';
+        cell.textContent = t('empty');
+        empty.appendChild(cell);
+        rows.appendChild(empty);
+    }
+}
+
+// 按模板的结构生成一行
+function buildRow(file) {
+    const row = document.createElement('tr');
+    row.dataset.name = file.name;
+
+    const nameCell = document.createElement('td');
+    const link = document.createElement('a');
+    link.href = file.path;
+    link.className = file.is_dir ? 'folder' : 'file file-' + file.icon;
+    const iconSpan = document.createElement('span');
+    iconSpan.className = 'icon';
+    iconSpan.innerHTML = icon(file.icon);
+    link.append(iconSpan, ' ' + file.name);
+    nameCell.appendChild(link);
+    if (file.scan) {
+        const badge = document.createElement('span');
+        badge.className = file.scan === 'pending' ? 'scan-badge' : 'scan-badge scan-blocked';
+        badge.textContent = t(file.scan === 'pending' ? 'scan_pending' : 'scan_blocked');
+        nameCell.append(' ', badge);
+    }
+
+    const timeCell = document.createElement('td');
+    timeCell.className = 'time';
+    timeCell.textContent = file.mod_time;
+
+    const sizeCell = document.createElement('td');
+    sizeCell.className = 'size';
+    sizeCell.textContent = file.size;
+    if (file.is_dir) sizeCell.title = t('items').replace('%d', file.items || 0);
+
+    const actionsCell = document.createElement('td');
+    actionsCell.className = 'actions';
+    const actions = document.createElement('div');
+    actions.className = 'row-actions';
+    if (file.versions) {
+        const versionsLink = document.createElement('a');
+        versionsLink.href = file.versions;
+        versionsLink.className = 'row-action versions-link';
+        versionsLink.title = t('versions');
+        versionsLink.setAttribute('aria-label', t('versions'));
+        versionsLink.innerHTML = icon('history');
+        actions.appendChild(versionsLink);
+    }
+    if (!file.is_dir) {
+        const checksumButton = document.createElement('button');
+        checksumButton.type = 'button';
+        checksumButton.className = 'row-action checksum-btn';
+        checksumButton.title = t('copy_checksum');
+        checksumButton.setAttribute('aria-label', t('copy_checksum'));
+        checksumButton.innerHTML = icon('hash');
+        actions.appendChild(checksumButton);
+    }
+    // 只读存储不显示删除按钮
+    if (page.readOnly === undefined) {
+        const deleteButton = document.createElement('button');
+        deleteButton.type = 'button';
+        deleteButton.className = 'row-action delete-btn';
+        deleteButton.title = t('delete');
+        deleteButton.setAttribute('aria-label', t('delete'));
+        deleteButton.innerHTML = icon('trash');
+        actions.appendChild(deleteButton);
+    }
+    actionsCell.appendChild(actions);
+
+    row.append(nameCell, timeCell, sizeCell, actionsCell);
+    return row;
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 在主题目录中写入文件
+func writeThemeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	full := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadWebAssetsTheme(t *testing.T) {
+	builtin, err := loadWebAssets("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	themeDir := t.TempDir()
+	writeThemeFile(t, themeDir, "templates/index.html", `{{define "index.html"}}themed {{asset "app.css"}}{{end}}`)
+	writeThemeFile(t, themeDir, "static/app.css", "body{color:red}")
+	themed, err := loadWebAssets(themeDir, "/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if themed.version == builtin.version {
+		t.Error("theme static files did not change the asset version")
+	}
+	if themed.etags["app.css"] == builtin.etags["app.css"] || themed.etags["favicon.svg"] != builtin.etags["favicon.svg"] {
+		t.Error("theme static files should change only their own ETags")
+	}
+
+	// 主题模板替换内置模板，asset 函数带URL前缀和版本号
+	var buf bytes.Buffer
+	if err := themed.templates.ExecuteTemplate(&buf, "index.html", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "themed /files/static/app.css?v=" + themed.version; buf.String() != want {
+		t.Errorf("template output = %q, want %q", buf.String(), want)
+	}
+
+	// 主题中的静态文件优先，其余回退到内置文件
+	css, err := fsReadString(themed, "app.css")
+	if err != nil || css != "body{color:red}" {
+		t.Errorf("app.css = %q, %v", css, err)
+	}
+	if _, err := fsReadString(themed, "favicon.svg"); err != nil {
+		t.Errorf("built-in favicon.svg is not available: %v", err)
+	}
+
+	if _, err := loadWebAssets(filepath.Join(themeDir, "missing"), ""); err == nil {
+		t.Error("missing theme directory was accepted")
+	}
+}
+
+func fsReadString(assets *webAssets, name string) (string, error) {
+	f, err := assets.static.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(f)
+	return buf.String(), err
+}
+
+func TestHandleStatic(t *testing.T) {
+	assets, err := loadWebAssets("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := handleStatic(assets)
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	// 带当前版本号的请求可以长期缓存
+	w := get("/static/app.css?v=" + assets.version)
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("versioned: status = %d, Cache-Control = %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	w = get("/static/app.css")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("unversioned: status = %d, Cache-Control = %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+
+	// ETag 按文件内容计算，未修改的文件返回 304
+	css, icon := w.Header().Get("ETag"), get("/static/favicon.svg").Header().Get("ETag")
+	if css == "" || css == icon {
+		t.Errorf("ETag app.css = %q, favicon.svg = %q, want distinct per-file tags", css, icon)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/static/app.css", nil)
+	r.Header.Set("If-None-Match", css)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status = %d, want 304", w.Code)
+	}
+
+	// 不提供目录列表
+	if w := get("/static/"); w.Code != http.StatusNotFound {
+		t.Errorf("directory: status = %d, want 404", w.Code)
+	}
+	if w := get("/static/missing.js"); w.Code != http.StatusNotFound {
+		t.Errorf("missing file: status = %d, want 404", w.Code)
+	}
+}
+
+func TestRequestTheme(t *testing.T) {
+	tests := map[string]string{
+		"":       "auto",
+		"dark":   "dark",
+		"light":  "light",
+		"purple": "auto",
+	}
+	for cookie, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: themeCookie, Value: cookie})
+		}
+		if got := requestTheme(r); got != want {
+			t.Errorf("theme cookie %q: requestTheme = %q, want %q", cookie, got, want)
+		}
+	}
+}